@@ -0,0 +1,833 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/damienstanton/cm/pkg/cm"
+)
+
+// bindgenFlags are the options accepted by `cm bindgen`, on top of the global flags
+var (
+	bindgenFlags = flag.NewFlagSet("bindgen", flag.ExitOnError)
+	bindgenOut   = bindgenFlags.String("out", "", "dir to write the bindings to (default bindgen/<language>/<package>)")
+)
+
+// exportMarker is the comment that marks the declaration on the following line for bindgen
+var exportMarker = regexp.MustCompile(`(?m)^[ \t]*//[ \t]*cm:export[ \t]*$`)
+
+// bindType is a C++ type bindgen knows how to pass through C to Go
+type bindType struct {
+	// c is the type in the C shim, with cRet used instead for return values
+	c    string
+	cRet string
+	// goType is the Go type, cgo the type cgo calls it, and zero is the Go zero value
+	goType string
+	cgo    string
+	zero   string
+}
+
+func (t bindType) isString() bool {
+	return t.goType == "string"
+}
+
+// bindTypes maps the supported C++ types, after const and references are dropped, onto their C and Go types.
+// std::string is passed as a NUL-terminated copy, which the receiver owns when it is returned.
+var bindTypes = map[string]bindType{
+	"int":                {c: "int", goType: "int", cgo: "C.int", zero: "0"},
+	"long":               {c: "long", goType: "int64", cgo: "C.long", zero: "0"},
+	"long long":          {c: "long long", goType: "int64", cgo: "C.longlong", zero: "0"},
+	"unsigned":           {c: "unsigned int", goType: "uint", cgo: "C.uint", zero: "0"},
+	"unsigned int":       {c: "unsigned int", goType: "uint", cgo: "C.uint", zero: "0"},
+	"size_t":             {c: "size_t", goType: "uint64", cgo: "C.size_t", zero: "0"},
+	"std::size_t":        {c: "size_t", goType: "uint64", cgo: "C.size_t", zero: "0"},
+	"double":             {c: "double", goType: "float64", cgo: "C.double", zero: "0"},
+	"float":              {c: "float", goType: "float32", cgo: "C.float", zero: "0"},
+	"bool":               {c: "bool", goType: "bool", cgo: "C.bool", zero: "false"},
+	"std::string":        {c: "const char*", cRet: "char*", goType: "string", cgo: "*C.char", zero: `""`},
+	"std::string_view":   {c: "const char*", cRet: "char*", goType: "string", cgo: "*C.char", zero: `""`},
+	"const char*":        {c: "const char*", goType: "string", cgo: "*C.char", zero: `""`},
+	"char const*":        {c: "const char*", goType: "string", cgo: "*C.char", zero: `""`},
+	"std::int32_t":       {c: "int32_t", goType: "int32", cgo: "C.int32_t", zero: "0"},
+	"std::int64_t":       {c: "int64_t", goType: "int64", cgo: "C.int64_t", zero: "0"},
+	"std::uint32_t":      {c: "uint32_t", goType: "uint32", cgo: "C.uint32_t", zero: "0"},
+	"std::uint64_t":      {c: "uint64_t", goType: "uint64", cgo: "C.uint64_t", zero: "0"},
+	"int32_t":            {c: "int32_t", goType: "int32", cgo: "C.int32_t", zero: "0"},
+	"int64_t":            {c: "int64_t", goType: "int64", cgo: "C.int64_t", zero: "0"},
+	"uint32_t":           {c: "uint32_t", goType: "uint32", cgo: "C.uint32_t", zero: "0"},
+	"uint64_t":           {c: "uint64_t", goType: "uint64", cgo: "C.uint64_t", zero: "0"},
+	"unsigned long":      {c: "unsigned long", goType: "uint64", cgo: "C.ulong", zero: "0"},
+	"unsigned long long": {c: "unsigned long long", goType: "uint64", cgo: "C.ulonglong", zero: "0"},
+}
+
+// bindParam is a parameter of an exported function
+type bindParam struct {
+	name string
+	typ  bindType
+}
+
+// bindFunc is an exported free function or method. ret is nil for void functions.
+type bindFunc struct {
+	// cpp is the name to call in C++: qualified for free functions, unqualified for methods
+	cpp    string
+	name   string
+	params []bindParam
+	ret    *bindType
+}
+
+// bindClass is an exported class, which Go code holds through an opaque pointer
+type bindClass struct {
+	cpp     string
+	name    string
+	ctors   [][]bindParam
+	methods []bindFunc
+}
+
+// bindings is everything exported from the project's headers
+type bindings struct {
+	headers []string
+	funcs   []bindFunc
+	classes []bindClass
+}
+
+// goPackageName returns the name of the Go package for a project: the project name in lower case without separators,
+// prefixed with lib if that does not start with a letter (2d-engine becomes lib2dengine)
+func goPackageName(project string) string {
+	pkg := strings.ToLower(strings.Replace(identifier(project), "_", "", -1))
+	if pkg == "" || pkg[0] < 'a' || pkg[0] > 'z' {
+		pkg = "lib" + pkg
+	}
+	return pkg
+}
+
+// runBindgen generates bindings for the declarations marked with `// cm:export` in the project's headers, builds the
+// C shim they go through along with the project's sources, and writes a Go package using it
+func runBindgen(target string, args []string) {
+	if len(args) != 1 || args[0] != "go" {
+		log.Fatalf("usage: cm bindgen go (Go is the only supported language)")
+	}
+	pkg := goPackageName(*name)
+	out := *bindgenOut
+	if out == "" {
+		out = filepath.Join(target, "bindgen", "go", pkg)
+	}
+	b, err := parseBindings(target)
+	if err != nil {
+		log.Fatalf("bindgen error: %+v", err)
+	}
+	if len(b.funcs) == 0 && len(b.classes) == 0 {
+		log.Fatalf("no supported declarations are marked with // cm:export")
+	}
+	log.Printf("exporting %d function(s) and %d class(es) to Go package %s", len(b.funcs), len(b.classes), pkg)
+
+	prefix := "cm_" + identifier(*name)
+	// cgo compiles any C++ source in the package dir, so the shim source, which is built here instead, goes in a subdir
+	if err := os.MkdirAll(filepath.Join(out, "shim"), 0777); err != nil {
+		log.Fatalf("dir write error: %v", err)
+	}
+	files := map[string]string{
+		"shim.h":        shimHeader(b, prefix),
+		"shim/shim.cpp": shimSource(b, prefix),
+		pkg + ".go":     goBindings(b, prefix, pkg),
+	}
+	for file, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(out, file), []byte(content), 0664); err != nil {
+			log.Fatalf("file write error: %v", err)
+		}
+	}
+
+	opts := buildOptions()
+	srcs, err := opts.LibrarySources(target + "/src")
+	if err != nil {
+		log.Fatalf("could not find project sources: %+v", err)
+	}
+	depArgs, err := opts.DependencyArgs(target)
+	if err != nil {
+		log.Fatalf("dependency error: %+v", err)
+	}
+	libArgs, err := opts.LibArgs(target + "/lib")
+	if err != nil {
+		log.Fatalf("%+v", err)
+	}
+	extra := append(srcs, "-shared", "-fPIC", "-I"+target+"/src")
+	if isDir(target + "/include") {
+		extra = append(extra, "-I"+target+"/include")
+	}
+	extra = append(extra, depArgs...)
+	extra = append(extra, libArgs...)
+	shim := filepath.Join(out, "lib"+pkg+"_shim.so")
+	log.Printf("compiling %s...", shim)
+	if err := opts.CompileFile(filepath.Join(out, "shim", "shim.cpp"), shim, extra...); err != nil {
+		log.Fatalf("%+v", err)
+	}
+	log.Printf("🎉 wrote Go package %s to %s", pkg, out)
+}
+
+// parseBindings finds the declarations marked for export in the headers in src/ and include/
+func parseBindings(target string) (bindings, error) {
+	var b bindings
+	for _, dir := range []string{"src", "include"} {
+		dir = filepath.Join(target, dir)
+		if !isDir(dir) {
+			continue
+		}
+		globs := make([]string, 0, len(cm.HeaderExts))
+		for _, ext := range cm.HeaderExts {
+			globs = append(globs, "*"+ext)
+		}
+		headers, err := cm.FindAll(dir, globs)
+		if err != nil {
+			return b, err
+		}
+		for _, h := range headers {
+			data, err := ioutil.ReadFile(h)
+			if err != nil {
+				return b, err
+			}
+			funcs, classes := parseHeader(string(data), h)
+			if len(funcs) == 0 && len(classes) == 0 {
+				continue
+			}
+			rel, _ := filepath.Rel(dir, h)
+			b.headers = append(b.headers, filepath.ToSlash(rel))
+			b.funcs = append(b.funcs, funcs...)
+			b.classes = append(b.classes, classes...)
+		}
+	}
+	return b, nil
+}
+
+// parseHeader returns the functions and classes declared right after an export marker. Declarations that use types
+// bindgen does not support are skipped with a warning.
+func parseHeader(src, path string) ([]bindFunc, []bindClass) {
+	code := blankComments(src)
+	funcs := make([]bindFunc, 0)
+	classes := make([]bindClass, 0)
+	for _, m := range exportMarker.FindAllStringIndex(src, -1) {
+		start := m[1]
+		for start < len(code) && strings.ContainsRune(" \t\r\n", rune(code[start])) {
+			start++
+		}
+		ns := namespaceAt(code, start)
+		line := strings.Count(src[:start], "\n") + 1
+		if c := classDecl.FindStringSubmatch(code[start:]); c != nil {
+			open := start + len(c[0]) - 1
+			body := code[open+1 : matchingBrace(code, open)]
+			classes = append(classes, parseClass(qualify(ns, c[2]), c[2], c[1] == "struct", body, path, line))
+			continue
+		}
+		end := start
+		for end < len(code) && code[end] != ';' && code[end] != '{' {
+			end++
+		}
+		f, err := parseFunc(code[start:end], "")
+		if err != nil {
+			log.Printf("%s:%d: skipping export: %v", path, line, err)
+			continue
+		}
+		f.cpp = qualify(ns, f.name)
+		funcs = append(funcs, f)
+	}
+	return funcs, classes
+}
+
+// classDecl matches the start of a class definition, up to its opening brace
+var classDecl = regexp.MustCompile(`^(class|struct)\s+(\w+)(?:\s+final)?\s*(?::[^{;]*)?\{`)
+
+// parseClass collects the public constructors and methods of a class. Static members, operators, deleted members and
+// anything with unsupported types are skipped. A class that declares no constructor at all gets the implicit default
+// constructor, and an abstract class gets none.
+func parseClass(cpp, name string, isStruct bool, body, path string, line int) bindClass {
+	c := bindClass{cpp: cpp, name: name}
+	public := isStruct
+	declared, abstract := false, false
+	access := regexp.MustCompile(`^\s*(public|private|protected)\s*:`)
+	for _, member := range splitMembers(body) {
+		for {
+			m := access.FindStringSubmatch(member)
+			if m == nil {
+				break
+			}
+			public = m[1] == "public"
+			member = member[len(m[0]):]
+		}
+		member = strings.TrimSpace(member)
+		if member == "" {
+			continue
+		}
+		member = initList.ReplaceAllString(member, ")")
+		deleted := deletedDecl.MatchString(member)
+		if pureDecl.MatchString(member) {
+			abstract = true
+		}
+		if ctor := ctorDecl.FindStringSubmatch(normalizeDecl(member)); ctor != nil && ctor[1] == name {
+			// any declared constructor, even a private or deleted one, suppresses the implicit default constructor
+			declared = true
+			if !public || deleted {
+				continue
+			}
+			params, err := parseParams(ctor[2])
+			if err != nil {
+				log.Printf("%s:%d: skipping constructor of %s: %v", path, line, name, err)
+				continue
+			}
+			c.ctors = append(c.ctors, params)
+			continue
+		}
+		if !public || deleted {
+			continue
+		}
+		if !strings.Contains(member, "(") || strings.Contains(member, "~") || strings.Contains(member, "operator") ||
+			strings.HasPrefix(member, "static ") || strings.HasPrefix(member, "friend ") {
+			continue
+		}
+		f, err := parseFunc(member, name)
+		if err != nil {
+			log.Printf("%s:%d: skipping %s member: %v", path, line, name, err)
+			continue
+		}
+		f.cpp = f.name
+		c.methods = append(c.methods, f)
+	}
+	switch {
+	case abstract:
+		c.ctors = nil
+	case !declared:
+		c.ctors = append(c.ctors, []bindParam{})
+	}
+	return c
+}
+
+// deletedDecl and pureDecl match deleted and pure virtual member functions
+var (
+	deletedDecl = regexp.MustCompile(`\)[^()]*=\s*delete\s*$`)
+	pureDecl    = regexp.MustCompile(`\)[^()]*=\s*0\s*$`)
+)
+
+// initList matches the member initializer list of a constructor defined in the class
+var initList = regexp.MustCompile(`(?s)\)\s*:[^:].*$`)
+
+// ctorDecl matches a constructor declaration, which has no return type
+var ctorDecl = regexp.MustCompile(`^(?:explicit\s+)?(\w+)\s*\((.*)\)(?:\s*noexcept)?$`)
+
+// trailingDecl and classicDecl match function declarations with a trailing and a leading return type
+var (
+	trailingDecl = regexp.MustCompile(`^auto\s+(\w+)\s*\((.*)\)\s*(?:const\s*)?(?:noexcept\s*)?->\s*(.+?)(?:\s+(?:override|final))*$`)
+	classicDecl  = regexp.MustCompile(`^(.+?[\s&*])(\w+)\s*\((.*)\)\s*(?:const\s*)?(?:noexcept\s*)?(?:(?:override|final)\s*)*$`)
+)
+
+// parseFunc parses a function or method declaration, without its body or terminating semicolon
+func parseFunc(decl, class string) (bindFunc, error) {
+	decl = normalizeDecl(decl)
+	var ret, name, params string
+	if m := trailingDecl.FindStringSubmatch(decl); m != nil {
+		name, params, ret = m[1], m[2], m[3]
+	} else if m := classicDecl.FindStringSubmatch(decl); m != nil {
+		ret, name, params = m[1], m[2], m[3]
+	} else {
+		return bindFunc{}, fmt.Errorf("cannot parse %q", decl)
+	}
+	f := bindFunc{name: name}
+	var err error
+	if f.params, err = parseParams(params); err != nil {
+		return f, fmt.Errorf("%s: %v", name, err)
+	}
+	if strings.TrimSpace(ret) != "void" {
+		t, err := lookupType(ret)
+		if err != nil {
+			return f, fmt.Errorf("%s: %v", name, err)
+		}
+		f.ret = &t
+	}
+	return f, nil
+}
+
+// normalizeDecl collapses whitespace and drops the specifiers and attributes that do not matter for bindings
+func normalizeDecl(decl string) string {
+	decl = regexp.MustCompile(`\[\[[^\]]*\]\]`).ReplaceAllString(decl, " ")
+	decl = regexp.MustCompile(`\s*=\s*(?:default|delete|0)\s*$`).ReplaceAllString(strings.TrimSpace(decl), "")
+	decl = strings.Join(strings.Fields(decl), " ")
+	for _, spec := range []string{"inline ", "virtual ", "constexpr ", "extern "} {
+		decl = strings.Replace(decl, spec, "", -1)
+	}
+	return strings.TrimSpace(decl)
+}
+
+// parseParams parses a parameter list, naming unnamed parameters after their position
+func parseParams(list string) ([]bindParam, error) {
+	params := make([]bindParam, 0)
+	list = strings.TrimSpace(list)
+	if list == "" || list == "void" {
+		return params, nil
+	}
+	for i, p := range strings.Split(list, ",") {
+		if eq := strings.Index(p, "="); eq >= 0 {
+			p = p[:eq]
+		}
+		p = strings.TrimSpace(p)
+		typ, pname := p, fmt.Sprintf("arg%d", i)
+		if m := regexp.MustCompile(`^(.*?[\s&*])(\w+)$`).FindStringSubmatch(p); m != nil {
+			if _, err := lookupType(p); err != nil {
+				typ, pname = m[1], m[2]
+			}
+		}
+		t, err := lookupType(typ)
+		if err != nil {
+			return nil, err
+		}
+		params = append(params, bindParam{name: goParamName(pname), typ: t})
+	}
+	return params, nil
+}
+
+// lookupType maps a C++ type onto a supported binding type. Const values and const references to strings are
+// supported, mutable references and other pointers are not.
+func lookupType(cpp string) (bindType, error) {
+	t := strings.Join(strings.Fields(strings.Replace(strings.Replace(cpp, "*", " * ", -1), "&", " & ", -1)), " ")
+	if t == "const char *" || t == "char const *" {
+		return bindTypes["const char*"], nil
+	}
+	isConst := regexp.MustCompile(`\bconst\b`).MatchString(t)
+	isRef := strings.HasSuffix(t, "&")
+	t = strings.TrimSpace(strings.TrimSuffix(t, "&"))
+	t = strings.Join(strings.Fields(regexp.MustCompile(`\bconst\b`).ReplaceAllString(t, "")), " ")
+	bt, ok := bindTypes[t]
+	if !ok || strings.Contains(t, "*") || (isRef && (!isConst || !bt.isString())) {
+		return bindType{}, fmt.Errorf("unsupported type %q", strings.TrimSpace(cpp))
+	}
+	if bt.cRet == "" {
+		bt.cRet = bt.c
+	}
+	return bt, nil
+}
+
+// reservedNames may not be used as parameter names in the generated code: Go keywords and the names of the locals
+// the wrappers declare
+var reservedNames = map[string]bool{
+	"break": true, "case": true, "chan": true, "const": true, "continue": true, "default": true, "defer": true,
+	"else": true, "fallthrough": true, "for": true, "func": true, "go": true, "goto": true, "if": true,
+	"import": true, "interface": true, "map": true, "package": true, "range": true, "return": true, "select": true,
+	"struct": true, "switch": true, "type": true, "var": true,
+	"o": true, "res": true, "err": true, "cErr": true, "self": true,
+}
+
+// goParamName makes a C++ parameter name usable in the shim and in Go
+func goParamName(name string) string {
+	if reservedNames[name] {
+		return name + "_"
+	}
+	return name
+}
+
+// goName turns a C++ name like get_value into an exported Go name like GetValue
+func goName(name string) string {
+	var b strings.Builder
+	for _, part := range strings.Split(name, "_") {
+		if part != "" {
+			b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+		}
+	}
+	return b.String()
+}
+
+// blankComments replaces comments and string and character literals with spaces, so braces and semicolons in them
+// do not confuse the parser. Offsets are kept intact.
+func blankComments(src string) string {
+	b := []byte(src)
+	for i := 0; i < len(b); i++ {
+		switch {
+		case b[i] == '/' && i+1 < len(b) && b[i+1] == '/':
+			for ; i < len(b) && b[i] != '\n'; i++ {
+				b[i] = ' '
+			}
+		case b[i] == '/' && i+1 < len(b) && b[i+1] == '*':
+			for ; i < len(b) && !(b[i] == '*' && i+1 < len(b) && b[i+1] == '/'); i++ {
+				if b[i] != '\n' {
+					b[i] = ' '
+				}
+			}
+			if i+1 < len(b) {
+				b[i], b[i+1] = ' ', ' '
+				i++
+			}
+		case b[i] == '"' || b[i] == '\'':
+			quote := b[i]
+			for i++; i < len(b) && b[i] != quote && b[i] != '\n'; i++ {
+				if b[i] == '\\' && i+1 < len(b) {
+					b[i] = ' '
+					i++
+				}
+				b[i] = ' '
+			}
+		}
+	}
+	return string(b)
+}
+
+// namespaceAt returns the namespace open at the given offset of comment-free code, e.g. "a::b"
+func namespaceAt(code string, offset int) string {
+	stack := make([]string, 0)
+	last := 0
+	nsOpen := regexp.MustCompile(`(?:^|[\s;}])(?:inline\s+)?namespace\s+([\w:]+)\s*$`)
+	for i := 0; i < offset && i < len(code); i++ {
+		switch code[i] {
+		case '{':
+			name := ""
+			if m := nsOpen.FindStringSubmatch(code[last:i]); m != nil {
+				name = m[1]
+			}
+			stack = append(stack, name)
+			last = i + 1
+		case '}':
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+			last = i + 1
+		case ';':
+			last = i + 1
+		}
+	}
+	names := make([]string, 0, len(stack))
+	for _, n := range stack {
+		if n != "" {
+			names = append(names, n)
+		}
+	}
+	return strings.Join(names, "::")
+}
+
+// qualify prefixes name with the namespace, if there is one
+func qualify(ns, name string) string {
+	if ns == "" {
+		return name
+	}
+	return ns + "::" + name
+}
+
+// matchingBrace returns the offset of the brace closing the one at open, or the end of the code
+func matchingBrace(code string, open int) int {
+	depth := 0
+	for i := open; i < len(code); i++ {
+		switch code[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return len(code)
+}
+
+// splitMembers splits a class body into member declarations, dropping the bodies of members defined in the class
+func splitMembers(body string) []string {
+	members := make([]string, 0)
+	var cur strings.Builder
+	for i := 0; i < len(body); i++ {
+		switch body[i] {
+		case ';':
+			members = append(members, cur.String())
+			cur.Reset()
+		case '{':
+			i = matchingBrace(body, i)
+			members = append(members, cur.String())
+			cur.Reset()
+		default:
+			cur.WriteByte(body[i])
+		}
+	}
+	return append(members, cur.String())
+}
+
+// uniqueNames returns the Go names of the functions, numbering overloads after the first: Greet, Greet2, ...
+func uniqueNames(funcs []bindFunc) []string {
+	seen := make(map[string]int)
+	names := make([]string, len(funcs))
+	for i, f := range funcs {
+		n := goName(f.name)
+		seen[n]++
+		if seen[n] > 1 {
+			n = fmt.Sprintf("%s%d", n, seen[n])
+		}
+		names[i] = n
+	}
+	return names
+}
+
+// cParams returns the parameter list of a shim function, which always ends with the error out parameter
+func cParams(self string, params []bindParam) string {
+	list := make([]string, 0, len(params)+2)
+	if self != "" {
+		list = append(list, self+" self")
+	}
+	for _, p := range params {
+		list = append(list, p.typ.c+" "+p.name)
+	}
+	return strings.Join(append(list, "char** err"), ", ")
+}
+
+// cppArgs returns the arguments the shim passes on to C++, copying C strings into std::string
+func cppArgs(params []bindParam) string {
+	args := make([]string, 0, len(params))
+	for _, p := range params {
+		if p.typ.isString() && p.typ.cRet != p.typ.c {
+			args = append(args, "std::string("+p.name+")")
+		} else {
+			args = append(args, p.name)
+		}
+	}
+	return strings.Join(args, ", ")
+}
+
+// shimHeader returns the C header declaring the shim functions
+func shimHeader(b bindings, prefix string) string {
+	var h strings.Builder
+	h.WriteString("/* Code generated by cm bindgen; DO NOT EDIT. */\n\n")
+	h.WriteString("#pragma once\n\n#include <stdbool.h>\n#include <stddef.h>\n#include <stdint.h>\n\n")
+	h.WriteString("#ifdef __cplusplus\nextern \"C\" {\n#endif\n\n")
+	h.WriteString("/* Strings returned by these functions, and the error messages they set, are allocated with malloc and must\n")
+	h.WriteString(" * be freed by the caller. Objects created with _new must be destroyed with _delete. */\n\n")
+	for i, f := range b.funcs {
+		h.WriteString(shimDecl(f, prefix+"_"+uniqueNames(b.funcs)[i], "") + ";\n")
+	}
+	for _, c := range b.classes {
+		typ := prefix + "_" + c.name
+		fmt.Fprintf(&h, "\ntypedef struct %s %s;\n", typ, typ)
+		for i, params := range c.ctors {
+			fmt.Fprintf(&h, "%s* %s(%s);\n", typ, ctorName(typ, i), cParams("", params))
+		}
+		fmt.Fprintf(&h, "void %s_delete(%s* self);\n", typ, typ)
+		names := uniqueNames(c.methods)
+		for i, m := range c.methods {
+			h.WriteString(shimDecl(m, typ+"_"+names[i], typ+"*") + ";\n")
+		}
+	}
+	h.WriteString("\n#ifdef __cplusplus\n}\n#endif\n")
+	return h.String()
+}
+
+// ctorName returns the shim function for the i-th constructor of a class
+func ctorName(typ string, i int) string {
+	if i == 0 {
+		return typ + "_new"
+	}
+	return fmt.Sprintf("%s_new%d", typ, i+1)
+}
+
+// shimDecl returns the C signature of the shim function for f
+func shimDecl(f bindFunc, cname, self string) string {
+	ret := "void"
+	if f.ret != nil {
+		ret = f.ret.cRet
+	}
+	return fmt.Sprintf("%s %s(%s)", ret, cname, cParams(self, f.params))
+}
+
+// shimSource returns the C++ side of the shim, which calls into the project and turns exceptions into errors
+func shimSource(b bindings, prefix string) string {
+	var s strings.Builder
+	s.WriteString("// Code generated by cm bindgen; DO NOT EDIT.\n\n")
+	s.WriteString("#include <cstdlib>\n#include <cstring>\n#include <exception>\n#include <string>\n\n#include \"../shim.h\"\n")
+	for _, h := range b.headers {
+		fmt.Fprintf(&s, "#include %q\n", h)
+	}
+	s.WriteString(`
+namespace {
+
+// owned returns a malloc'd copy of s, which the caller frees
+char* owned(std::string const& s) {
+    auto p = static_cast<char*>(std::malloc(s.size() + 1));
+    std::memcpy(p, s.c_str(), s.size() + 1);
+    return p;
+}
+
+}  // namespace
+
+`)
+	// call writes a shim function evaluating expr, with ret nil for void functions
+	call := func(decl, expr string, ret *bindType) {
+		fmt.Fprintf(&s, "%s {\n    try {\n", decl)
+		switch {
+		case ret == nil:
+			fmt.Fprintf(&s, "        %s;\n        return;\n", expr)
+		case ret.isString() && ret.cRet != ret.c:
+			fmt.Fprintf(&s, "        return owned(std::string(%s));\n", expr)
+		default:
+			fmt.Fprintf(&s, "        return %s;\n", expr)
+		}
+		s.WriteString("    } catch (std::exception const& e) {\n        *err = owned(e.what());\n")
+		s.WriteString("    } catch (...) {\n        *err = owned(\"unknown C++ exception\");\n    }\n")
+		if ret == nil {
+			s.WriteString("}\n\n")
+		} else {
+			s.WriteString("    return {};\n}\n\n")
+		}
+	}
+	names := uniqueNames(b.funcs)
+	for i, f := range b.funcs {
+		call(shimDecl(f, prefix+"_"+names[i], ""), fmt.Sprintf("%s(%s)", f.cpp, cppArgs(f.params)), f.ret)
+	}
+	for _, c := range b.classes {
+		typ := prefix + "_" + c.name
+		// the opaque struct is never defined: pointers to it are really pointers to the C++ class
+		for i, params := range c.ctors {
+			decl := fmt.Sprintf("%s* %s(%s)", typ, ctorName(typ, i), cParams("", params))
+			ptr := bindType{c: typ + "*"}
+			call(decl, fmt.Sprintf("reinterpret_cast<%s*>(new %s(%s))", typ, c.cpp, cppArgs(params)), &ptr)
+		}
+		fmt.Fprintf(&s, "void %s_delete(%s* self) {\n    delete reinterpret_cast<%s*>(self);\n}\n\n", typ, typ, c.cpp)
+		methods := uniqueNames(c.methods)
+		for i, m := range c.methods {
+			expr := fmt.Sprintf("reinterpret_cast<%s*>(self)->%s(%s)", c.cpp, m.cpp, cppArgs(m.params))
+			call(shimDecl(m, typ+"_"+methods[i], typ+"*"), expr, m.ret)
+		}
+	}
+	return strings.TrimRight(s.String(), "\n") + "\n"
+}
+
+// goBindings returns the Go package wrapping the shim. Strings are copied across and freed on the side that did not
+// allocate them, C++ exceptions become errors, and objects are freed by Close or, failing that, a finalizer.
+func goBindings(b bindings, prefix, pkg string) string {
+	var g strings.Builder
+	g.WriteString("// Code generated by cm bindgen; DO NOT EDIT.\n\n")
+	fmt.Fprintf(&g, "// Package %s wraps the C++ library %s, built with cm.\npackage %s\n\n", pkg, *name, pkg)
+	fmt.Fprintf(&g, "// #cgo CFLAGS: -I${SRCDIR}\n// #cgo LDFLAGS: -L${SRCDIR} -l%s_shim -Wl,-rpath,${SRCDIR}\n", pkg)
+	g.WriteString("// #include <stdlib.h>\n// #include \"shim.h\"\nimport \"C\"\n\n")
+	imports := []string{`"errors"`, `"unsafe"`}
+	if len(b.classes) > 0 {
+		imports = []string{`"errors"`, `"runtime"`, `"unsafe"`}
+	}
+	fmt.Fprintf(&g, "import (\n\t%s\n)\n\n", strings.Join(imports, "\n\t"))
+	g.WriteString(`// cError turns an error message set by the shim into an error, freeing the message
+func cError(err *C.char) error {
+	if err == nil {
+		return nil
+	}
+	defer C.free(unsafe.Pointer(err))
+	return errors.New(C.GoString(err))
+}
+
+// goString copies a string returned by the shim into Go memory, freeing the C copy
+func goString(s *C.char) string {
+	defer C.free(unsafe.Pointer(s))
+	return C.GoString(s)
+}
+
+`)
+	if len(b.classes) > 0 {
+		g.WriteString("// ErrClosed is returned by the methods of an object that has been closed\n")
+		g.WriteString("var ErrClosed = errors.New(\"" + pkg + ": use of closed object\")\n\n")
+	}
+
+	// body writes the conversions, the call and the error check shared by every wrapper, followed by ret, the statement
+	// returning the result held in res
+	body := func(cname string, params []bindParam, self, zero, ret string) {
+		args := make([]string, 0, len(params)+2)
+		if self != "" {
+			args = append(args, self)
+		}
+		for _, p := range params {
+			if p.typ.isString() {
+				fmt.Fprintf(&g, "\tc_%s := C.CString(%s)\n\tdefer C.free(unsafe.Pointer(c_%s))\n", p.name, p.name, p.name)
+				args = append(args, "c_"+p.name)
+			} else {
+				args = append(args, fmt.Sprintf("%s(%s)", p.typ.cgo, p.name))
+			}
+		}
+		args = append(args, "&cErr")
+		g.WriteString("\tvar cErr *C.char\n\t")
+		if ret != "" {
+			g.WriteString("res := ")
+		}
+		fmt.Fprintf(&g, "C.%s(%s)\n", cname, strings.Join(args, ", "))
+		if self != "" {
+			g.WriteString("\truntime.KeepAlive(o)\n")
+		}
+		if ret == "" {
+			g.WriteString("\treturn cError(cErr)\n}\n\n")
+			return
+		}
+		fmt.Fprintf(&g, "\tif err := cError(cErr); err != nil {\n\t\treturn %s, err\n\t}\n%s}\n\n", zero, ret)
+	}
+	// returns gives the statement returning the Go value of res
+	returns := func(t *bindType) string {
+		switch {
+		case t == nil:
+			return ""
+		case t.isString() && t.cRet != t.c:
+			return "\treturn goString(res), nil\n"
+		case t.isString():
+			return "\treturn C.GoString(res), nil\n"
+		}
+		return fmt.Sprintf("\treturn %s(res), nil\n", t.goType)
+	}
+	goParams := func(params []bindParam) string {
+		list := make([]string, 0, len(params))
+		for _, p := range params {
+			list = append(list, p.name+" "+p.typ.goType)
+		}
+		return strings.Join(list, ", ")
+	}
+	results := func(t *bindType) (string, string) {
+		if t == nil {
+			return "error", ""
+		}
+		return "(" + t.goType + ", error)", t.zero
+	}
+
+	names := uniqueNames(b.funcs)
+	for i, f := range b.funcs {
+		res, zero := results(f.ret)
+		fmt.Fprintf(&g, "// %s calls %s.\nfunc %s(%s) %s {\n", names[i], f.cpp, names[i], goParams(f.params), res)
+		body(prefix+"_"+names[i], f.params, "", zero, returns(f.ret))
+	}
+	for _, c := range b.classes {
+		typ := prefix + "_" + c.name
+		gt := goName(c.name)
+		fmt.Fprintf(&g, "// %s wraps %s. Call Close to destroy the C++ object, or leave it to the garbage collector.\n", gt, c.cpp)
+		fmt.Fprintf(&g, "type %s struct {\n\tptr *C.%s\n}\n\n", gt, typ)
+		for i, params := range c.ctors {
+			ctor := "New" + gt
+			if i > 0 {
+				ctor = fmt.Sprintf("%s%d", ctor, i+1)
+			}
+			fmt.Fprintf(&g, "// %s constructs a %s.\nfunc %s(%s) (*%s, error) {\n", ctor, c.cpp, ctor, goParams(params), gt)
+			wrap := fmt.Sprintf("\to := &%s{ptr: res}\n\truntime.SetFinalizer(o, (*%s).Close)\n\treturn o, nil\n", gt, gt)
+			body(ctorName(typ, i), params, "", "nil", wrap)
+		}
+		fmt.Fprintf(&g, `// Close destroys the C++ object. It is safe to call Close more than once.
+func (o *%s) Close() {
+	if o.ptr != nil {
+		C.%s_delete(o.ptr)
+		o.ptr = nil
+		runtime.SetFinalizer(o, nil)
+	}
+}
+
+`, gt, typ)
+		methods := uniqueNames(c.methods)
+		for i, m := range c.methods {
+			n := methods[i]
+			if n == "Close" {
+				n = "CloseCpp"
+			}
+			res, zero := results(m.ret)
+			fmt.Fprintf(&g, "// %s calls %s::%s.\nfunc (o *%s) %s(%s) %s {\n", n, c.cpp, m.cpp, gt, n, goParams(m.params), res)
+			if m.ret == nil {
+				g.WriteString("\tif o.ptr == nil {\n\t\treturn ErrClosed\n\t}\n")
+			} else {
+				fmt.Fprintf(&g, "\tif o.ptr == nil {\n\t\treturn %s, ErrClosed\n\t}\n", zero)
+			}
+			body(typ+"_"+methods[i], m.params, "o.ptr", zero, returns(m.ret))
+		}
+	}
+	return strings.TrimRight(g.String(), "\n") + "\n"
+}
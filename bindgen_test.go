@@ -0,0 +1,213 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// describeParams renders parameters as "name goType" pairs, for comparing them
+func describeParams(params []bindParam) []string {
+	res := make([]string, 0, len(params))
+	for _, p := range params {
+		res = append(res, p.name+" "+p.typ.goType)
+	}
+	return res
+}
+
+func TestParseParams(t *testing.T) {
+	tests := []struct {
+		list    string
+		want    []string
+		wantErr bool
+	}{
+		{"", []string{}, false},
+		{"void", []string{}, false},
+		{"int a, double b", []string{"a int", "b float64"}, false},
+		{"int, double", []string{"arg0 int", "arg1 float64"}, false},
+		{"std::string const& who", []string{"who string"}, false},
+		{"const std::string &s, const char* c", []string{"s string", "c string"}, false},
+		{"unsigned long", []string{"arg0 uint64"}, false},
+		{"unsigned long n, long long m", []string{"n uint64", "m int64"}, false},
+		{"int x = 5, bool verbose = false", []string{"x int", "verbose bool"}, false},
+		{"int type, std::size_t err", []string{"type_ int", "err_ uint64"}, false},
+		{"int& x", nil, true},
+		{"int* p", nil, true},
+		{"std::string& s", nil, true},
+		{"std::vector<int> v", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := parseParams(tt.list)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseParams(%q) error = %v, want error %v", tt.list, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(describeParams(got), tt.want) {
+			t.Errorf("parseParams(%q) = %q, want %q", tt.list, describeParams(got), tt.want)
+		}
+	}
+}
+
+func TestParseFunc(t *testing.T) {
+	tests := []struct {
+		decl    string
+		name    string
+		params  []string
+		ret     string
+		wantErr bool
+	}{
+		{"std::string greet(std::string const& who)", "greet", []string{"who string"}, "string", false},
+		{"auto add(int a, int b) -> int", "add", []string{"a int", "b int"}, "int", false},
+		{"void reset()", "reset", []string{}, "", false},
+		{
+			"[[nodiscard]] std::string describe(std::string const& label) const",
+			"describe", []string{"label string"}, "string", false,
+		},
+		{"virtual auto area() const -> double override", "area", []string{}, "float64", false},
+		{"inline bool close(double) noexcept", "close", []string{"arg0 float64"}, "bool", false},
+		{"int* unsupported(int& x)", "", nil, "", true},
+		{"not a function", "", nil, "", true},
+	}
+	for _, tt := range tests {
+		f, err := parseFunc(tt.decl, "")
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseFunc(%q) error = %v, want error %v", tt.decl, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+		ret := ""
+		if f.ret != nil {
+			ret = f.ret.goType
+		}
+		if f.name != tt.name || ret != tt.ret || !reflect.DeepEqual(describeParams(f.params), tt.params) {
+			t.Errorf("parseFunc(%q) = %s(%q) %s, want %s(%q) %s", tt.decl, f.name, describeParams(f.params), ret,
+				tt.name, tt.params, tt.ret)
+		}
+	}
+}
+
+func TestParseClassConstructors(t *testing.T) {
+	tests := []struct {
+		name  string
+		body  string
+		ctors []string
+	}{
+		{
+			"declared",
+			"public: explicit Counter(int start) : n_(start) {} Counter() = default; private: int n_;",
+			[]string{"start int", ""},
+		},
+		{"implicit default", "public: int get() { return 1; }", []string{""}},
+		{"deleted default", "public: Counter() = delete; int get();", []string{}},
+		{"private constructor", "Counter(); public: int get();", []string{}},
+		{"unsupported constructor only", "public: Counter(Counter const& other);", []string{}},
+		{"abstract", "public: virtual ~Counter() = default; virtual auto area() const -> double = 0;", []string{}},
+		{"data member initialized to zero", "public: int n = 0; int get();", []string{""}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := parseClass("ns::Counter", "Counter", false, tt.body, "counter.hpp", 1)
+			got := make([]string, 0, len(c.ctors))
+			for _, params := range c.ctors {
+				got = append(got, strings.Join(describeParams(params), ", "))
+			}
+			if !reflect.DeepEqual(got, tt.ctors) {
+				t.Errorf("constructors = %q, want %q", got, tt.ctors)
+			}
+		})
+	}
+}
+
+func TestGoPackageName(t *testing.T) {
+	tests := map[string]string{
+		"geometry":   "geometry",
+		"My_Json":    "myjson",
+		"http-utils": "httputils",
+		"2d-engine":  "lib2dengine",
+		"3d":         "lib3d",
+		"":           "lib",
+	}
+	for project, want := range tests {
+		if got := goPackageName(project); got != want {
+			t.Errorf("goPackageName(%q) = %q, want %q", project, got, want)
+		}
+	}
+}
+
+func TestGoName(t *testing.T) {
+	tests := map[string]string{
+		"get_value": "GetValue",
+		"describe":  "Describe",
+		"Counter":   "Counter",
+		"_private":  "Private",
+		"a__b":      "AB",
+	}
+	for name, want := range tests {
+		if got := goName(name); got != want {
+			t.Errorf("goName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestParseHeader(t *testing.T) {
+	src := `#pragma once
+#include <string>
+
+namespace geo {
+inline namespace v1 {
+
+// cm:export
+std::string greet(std::string const& who);
+
+/* braces in comments { and strings "}" do not close namespaces */
+// cm:export
+auto area(double w, double h) -> double;
+
+// cm:export
+int* unsupported();
+
+// cm:export
+class Shape final : public Base {
+public:
+  Shape(double size) : size_(size) {}
+  auto describe() const -> std::string;
+  static Shape unit();
+  bool operator==(Shape const& other) const;
+private:
+  double size_;
+};
+
+}  // namespace v1
+}  // namespace geo
+
+// cm:export
+struct Point {
+  int x() const;
+};
+
+int not_exported();
+`
+	funcs, classes := parseHeader(src, "geo.hpp")
+	gotFuncs := make([]string, 0, len(funcs))
+	for _, f := range funcs {
+		gotFuncs = append(gotFuncs, f.cpp+"("+strings.Join(describeParams(f.params), ", ")+")")
+	}
+	if want := []string{"geo::v1::greet(who string)", "geo::v1::area(w float64, h float64)"}; !reflect.DeepEqual(
+		gotFuncs, want) {
+		t.Errorf("parseHeader() functions = %q, want %q", gotFuncs, want)
+	}
+	gotClasses := make([]string, 0, len(classes))
+	for _, c := range classes {
+		methods := make([]string, 0, len(c.methods))
+		for _, m := range c.methods {
+			methods = append(methods, m.cpp)
+		}
+		gotClasses = append(gotClasses, fmt.Sprintf("%s %d %s", c.cpp, len(c.ctors), strings.Join(methods, ",")))
+	}
+	if want := []string{"geo::v1::Shape 1 describe", "Point 1 x"}; !reflect.DeepEqual(gotClasses, want) {
+		t.Errorf("parseHeader() classes = %q, want %q", gotClasses, want)
+	}
+}
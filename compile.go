@@ -1,133 +1,85 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
-	"io"
 	"log"
-	"runtime"
-	"strings"
+
+	"github.com/damienstanton/cm/pkg/cm"
 )
 
-// compile executes the compilation process with the given compiler and arguments
-func compile(includepath *string, targetpath string, extra ...string) {
-	var libPath string
-	if *testMode {
-		targetpath = targetpath + "/tests"
-		libPath = strings.Replace(targetpath, "/tests", "/lib", 1)
-	} else {
-		targetpath = targetpath + "/src"
-		libPath = strings.Replace(targetpath, "/src", "/lib", 1)
-	}
-	globs := []string{
-		"*.cpp",
-		"*.cxx",
-		"*.cc",
-	}
-	targets, err := findAll(targetpath, globs)
-	if err != nil {
-		log.Fatalf("could not find target files: %+v", err)
+// outputDir, when set, replaces bin/ as the dir project binaries are written to
+var outputDir string
+
+// installRPath, when set, replaces the build dirs cm bakes into binaries as their rpath, so installed binaries find
+// their libraries relative to themselves
+var installRPath string
+
+// dry run flags of `cm build` and `cm test`, which print the build plan instead of executing it
+var (
+	dryRun   = buildFlags.Bool("n", false, "print the build plan without running or writing anything")
+	planJSON = buildFlags.Bool("json", false, "print the build plan of -n as JSON")
+)
+
+func init() {
+	testFlags.BoolVar(dryRun, "n", false, "print the test build plan without building anything (-affected still scans)")
+	testFlags.BoolVar(planJSON, "json", false, "print the test build plan of -n as JSON")
+}
+
+// buildOptions returns the build options given by the global flags
+func buildOptions() cm.BuildOptions {
+	return cm.BuildOptions{
+		Compiler:    *compiler,
+		Std:         *std,
+		Optimize:    *optimize,
+		IncludePath: *includepath,
+		OutputDir:   outputDir,
+		RPath:       installRPath,
+		Timeout:     compileTimeout,
+		Debug:       *debug,
+		Log:         log.New(log.Writer(), log.Prefix(), log.Flags()),
+		Trace:       trace,
 	}
-	binaryPath := strings.Replace(targetpath, "/src", "/bin", 1) + "/"
-	binaryNameFQ := binaryPath + *name
+}
+
+// project returns the project in the target dir, named by -o or cm.json
+func project(target string) *cm.Project {
+	return &cm.Project{Dir: target, Name: *name, Config: config}
+}
 
-	optLevel := "0"
-	if *optimize {
-		optLevel = "3"
+// compile builds the project, passing the extra args on to the compiler
+func compile(target string, extra ...string) {
+	opts := buildOptions()
+	opts.Extra = extra
+	if _, err := project(target).Build(opts); err != nil {
+		log.Fatalf("%+v", err)
 	}
+}
 
-	cArgs := []string{
-		"-std=" + *std,
-		"-Wall",
-		"-O" + optLevel,
-		"-o" + binaryNameFQ,
+// compileTests builds the project's tests, writing the harness files for as long as the build takes
+func compileTests(target string, t cm.TestOptions) {
+	if _, err := project(target).BuildTests(buildOptions(), t); err != nil {
+		log.Fatalf("%+v", err)
 	}
-	cArgs = append(cArgs, targets...)
-	cArgs = append(cArgs, extra...)
+}
 
-	if includepath == nil {
-		includepath = &targetpath
-	} else {
-		if *includepath == "" {
-			includepath = &targetpath
-		}
-		cArgs = append(cArgs, "-I"+*includepath)
-		log.Printf("checking for shared objects in %s...", libPath)
-		libs, err := dirIsEmpty(libPath)
-		if err != nil && err != io.EOF {
-			log.Fatalf("error reading lib dir: %+v", err)
-		}
-		if libs {
-			link := "-L" + libPath
-			cArgs = append(cArgs, link)
-			libs, err := linkLibs(libPath)
-			if err != nil {
-				log.Fatalf("error reading shared libraries: %+v", err)
-			}
-			for _, l := range libs {
-				if strings.HasSuffix(l, ".so") {
-					striplib := strings.Replace(l, "lib", "", 1)
-					trimmedLib := strings.Replace(striplib, ".so", "", 1)
-					log.Printf("linking shared object: %s", trimmedLib)
-					cArgs = append(cArgs, "-l"+trimmedLib)
-				}
-				if runtime.GOOS == "darwin" {
-					log.Println("compiling on macos, so rpath linking will be done after compilation")
-				} else {
-					cArgs = append(cArgs, "-Wl,-rpath,"+binaryPath)
-				}
-			}
-		} else {
-			log.Println("none found")
-		}
+// printPlan prints a build plan to stdout, as JSON with -json
+func printPlan(plan *cm.Plan, err error) {
+	if err != nil {
+		log.Fatalf("%+v", err)
 	}
-	out, err := wrap(*compiler, cArgs)
-	if err != nil || *debug {
-		fmt.Println("printing wrapped call for debugging:")
-		fmt.Println("═════════════════════════════════════")
-		fmt.Printf("\n%s %s", *compiler, strings.Join(cArgs, " "))
-		fmt.Printf("\n\n")
-		fmt.Println("═════════════════════════════════════")
-		if err != nil {
-			log.Fatalf("reason: %+v, %v", string(out), err)
-		}
+	if !*planJSON {
+		fmt.Print(plan)
+		return
 	}
-	if len(out) == 0 {
-		log.Println("🎉 compilation succeeded with no errors")
-		if runtime.GOOS == "darwin" {
-			libs, err := dirIsEmpty(libPath)
-			if err != nil && err != io.EOF {
-				log.Fatalf("error reading lib dir: %+v", err)
-			}
-			if libs {
-				libs, err := linkLibs(libPath)
-				if err != nil {
-					log.Fatalf("error reading shared libraries: %+v", err)
-				}
-				for _, l := range libs {
-					// -id "@loader_path/lib/libhello.so" bin/example
-					rPathArgs := []string{
-						"-id",
-						"\"@loader_path/lib/" + l + "\"",
-						binaryNameFQ,
-					}
-					out, err := wrap(
-						"install_name_tool",
-						rPathArgs,
-					)
-					if err != nil {
-						log.Printf("error with mac rpath tool: %v", err)
-						fmt.Printf("\n%s %s", "install_name_tool", strings.Join(rPathArgs, " "))
-						fmt.Printf("\n\n")
-						log.Println(string(out))
-					}
-					if len(out) == 0 {
-						log.Println("🎉 dynamic linking succeeded with no errors")
-					}
-
-				}
-			}
-		}
-	} else {
-		log.Print(string(out))
+	out, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		log.Fatalf("could not encode build plan: %+v", err)
 	}
+	fmt.Println(string(out))
+}
+
+// libSoname returns the soname of the library the project builds, if cm.json gives a version
+func libSoname() string {
+	return project("").Soname()
 }
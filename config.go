@@ -3,6 +3,8 @@ package main
 import (
 	"fmt"
 	"time"
+
+	"github.com/damienstanton/cm/pkg/cm"
 )
 
 const catchVersion = "v2.11.3"
@@ -17,4 +19,5 @@ func printBanner() {
 	fmt.Println("╚═════════════════════════╝")
 }
 
-// TODO: JSON parser
+// config holds the project configuration loaded from cm.json; it is the zero value when there is no cm.json
+var config cm.Config
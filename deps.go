@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// scanDeps asks the compiler for the files the given translation unit transitively depends on, using the same
+// dependency output (-MM) make-based builds use. System headers are left out. The source file itself comes first.
+func scanDeps(source string, flags []string) ([]string, error) {
+	args := append([]string{"-std=" + *std}, flags...)
+	args = append(args, "-MM", source)
+	out, err := wrap(*compiler, args)
+	if err != nil {
+		return nil, fmt.Errorf("could not scan dependencies of %s: %s", source, out)
+	}
+	return parseDeps(string(out)), nil
+}
+
+// ruleTarget matches the target of a make rule up to the colon that ends it
+var ruleTarget = regexp.MustCompile(`^[^\n]*?:(?:\s|$)`)
+
+// parseDeps parses a make rule as written by -MM, returning its prerequisites as clean paths
+func parseDeps(rule string) []string {
+	rule = strings.Replace(rule, "\\\n", " ", -1)
+	if loc := ruleTarget.FindStringIndex(rule); loc != nil {
+		rule = rule[loc[1]:]
+	}
+	// spaces in file names are escaped with a backslash
+	rule = strings.Replace(rule, "\\ ", "\x00", -1)
+	deps := make([]string, 0)
+	for _, f := range strings.Fields(rule) {
+		deps = append(deps, filepath.Clean(strings.Replace(f, "\x00", " ", -1)))
+	}
+	return deps
+}
+
+// includeFlags picks the flags that affect header resolution out of a list of compiler args
+func includeFlags(args []string) []string {
+	res := make([]string, 0)
+	for _, a := range args {
+		if strings.HasPrefix(a, "-I") || strings.HasPrefix(a, "-D") || strings.HasPrefix(a, "-isystem") {
+			res = append(res, a)
+		}
+	}
+	return res
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseDeps(t *testing.T) {
+	tests := []struct {
+		name string
+		rule string
+		want []string
+	}{
+		{"single line", "m_test.o: tests/m_test.cpp src/m.hpp\n", []string{"tests/m_test.cpp", "src/m.hpp"}},
+		{
+			"line continuations",
+			"m_test.o: /p/tests/m_test.cpp /p/tests/catch.hpp \\\n /p/src/m.hpp \\\n /p/src/util/../other.hpp\n",
+			[]string{"/p/tests/m_test.cpp", "/p/tests/catch.hpp", "/p/src/m.hpp", "/p/src/other.hpp"},
+		},
+		{
+			"escaped spaces",
+			"a.o: /my\\ project/tests/a.cpp /my\\ project/src/a\\ b.hpp\n",
+			[]string{"/my project/tests/a.cpp", "/my project/src/a b.hpp"},
+		},
+		{"no prerequisites", "a.o:\n", []string{}},
+		{"missing header with -MG", "a.o: a.cpp generated.hpp\n", []string{"a.cpp", "generated.hpp"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseDeps(tt.rule); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseDeps() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIncludeFlags(t *testing.T) {
+	args := []string{"-I/p/src", "-lfoo", "-DNDEBUG", "-L/p/lib", "-isystem/usr/include/x", "-Wl,-rpath,/p/lib", "-O2"}
+	want := []string{"-I/p/src", "-DNDEBUG", "-isystem/usr/include/x"}
+	if got := includeFlags(args); !reflect.DeepEqual(got, want) {
+		t.Errorf("includeFlags() = %q, want %q", got, want)
+	}
+}
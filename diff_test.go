@@ -0,0 +1,65 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		name      string
+		want, got string
+		diff      string
+	}{
+		{"equal", "1\n2\n", "1\n2\n", ""},
+		{"changed line", "1\n2\n3\n", "1\n5\n3\n", " 1\n-2\n+5\n 3\n"},
+		{"missing line", "1\n2\n3\n", "1\n3\n", " 1\n-2\n 3\n"},
+		{"extra line", "1\n2\n", "1\n2\n3\n", " 1\n 2\n+3\n"},
+		{"empty output", "1\n", "", "-1\n+\n"},
+		{"trailing newline ignored", "1\n2", "1\n2\n", ""},
+		{
+			"far apart changes are collapsed",
+			"a\n1\n2\n3\n4\n5\n6\n7\nb\n",
+			"A\n1\n2\n3\n4\n5\n6\n7\nB\n",
+			"-a\n+A\n 1\n 2\n@@ ... @@\n 6\n 7\n-b\n+B\n",
+		},
+		{
+			"close changes share their context",
+			"a\n1\n2\n3\nb\n",
+			"A\n1\n2\n3\nB\n",
+			"-a\n+A\n 1\n 2\n 3\n-b\n+B\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := diffLines(tt.want, tt.got); got != tt.diff {
+				t.Errorf("diffLines() =\n%s\nwant\n%s", got, tt.diff)
+			}
+		})
+	}
+}
+
+func TestFirstDifference(t *testing.T) {
+	tests := []struct {
+		a, b []string
+		want string
+	}{
+		{[]string{"1", "2"}, []string{"1", "2"}, ""},
+		{[]string{"1", "2"}, []string{"1", "3"}, "first difference at line 2:\n-2\n+3\n"},
+		{[]string{"1"}, []string{"1", "2"}, "first difference at line 2:\n-\n+2\n"},
+		{[]string{"1", ""}, []string{"1"}, "first difference at line 2:\n-\n+\n"},
+	}
+	for _, tt := range tests {
+		if got := firstDifference(tt.a, tt.b); got != tt.want {
+			t.Errorf("firstDifference(%q, %q) = %q, want %q", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestDiffLinesLargeInput(t *testing.T) {
+	lines := strings.Repeat("x\n", 3000)
+	got := diffLines(lines+"a\n", lines+"b\n")
+	if want := "first difference at line 3001:\n-a\n+b\n"; got != want {
+		t.Errorf("diffLines() = %q, want %q", got, want)
+	}
+}
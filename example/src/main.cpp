@@ -0,0 +1,15 @@
+#include "greeting.hpp"
+
+extern "C" {
+#include "../lib/libhello.h"
+}
+
+auto main(int argc, char* argv[]) -> int {
+    if (argc < 2)
+        std::cout << hi("") << std::endl;
+    else
+        std::cout << hi(argv[1]) << std::endl;
+
+    std::cout << (std::string)HiFromGo() << std::endl;
+    return 0;
+}
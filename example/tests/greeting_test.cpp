@@ -1,13 +1,7 @@
-#include "../src/greeting.h"
+#include "../src/greeting.hpp"
 
 #include "catch.hpp"
 
-auto hi(std::string name) -> std::string {
-    if (name == "")
-        return "Hello, there";
-    return "Hello, " + name;
-}
-
 TEST_CASE("Greeting with no args", "[greeting]") {
     REQUIRE(hi("") == "Hello, there");
 }
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/damienstanton/cm/pkg/cm"
+)
+
+// repetition flags for `cm test`, used to tell flaky test cases apart from consistently failing ones
+var (
+	testCount   = testFlags.Int("count", 1, "run each test case n times")
+	testRetries = testFlags.Int("retry-failed", 0, "rerun test cases that never passed up to k more times")
+)
+
+// flakyThreshold is the number of test runs a test case must have been flaky in before it is highlighted
+const flakyThreshold = 2
+
+// caseOutcome collects every attempt at running one test case
+type caseOutcome struct {
+	name     string
+	attempts []testResult
+}
+
+// status classifies the test case: it passed if every attempt passed, is flaky if only some did, and otherwise has
+// the status of its last attempt
+func (o caseOutcome) status() string {
+	switch o.failures() {
+	case 0:
+		return statusPass
+	case len(o.attempts):
+		return o.attempts[len(o.attempts)-1].status
+	default:
+		return statusFlaky
+	}
+}
+
+// failures counts the attempts that did not pass
+func (o caseOutcome) failures() int {
+	n := 0
+	for _, a := range o.attempts {
+		if a.status != statusPass {
+			n++
+		}
+	}
+	return n
+}
+
+// lastFailure returns the most recent attempt that did not pass, or the last attempt if they all passed
+func (o caseOutcome) lastFailure() testResult {
+	for i := len(o.attempts) - 1; i >= 0; i-- {
+		if o.attempts[i].status != statusPass {
+			return o.attempts[i]
+		}
+	}
+	return o.attempts[len(o.attempts)-1]
+}
+
+// runRepeated runs the named test cases -count times, then reruns the ones that have not passed yet up to
+// -retry-failed times, stopping early once they pass
+func runRepeated(fw testFramework, binary string, names []string) []caseOutcome {
+	outcomes := make([]caseOutcome, len(names))
+	for i, n := range names {
+		outcomes[i].name = n
+	}
+	for run := 0; run < maxInt(*testCount, 1); run++ {
+		for i, r := range runIsolated(fw, binary, names, *testJobs, *testTimeout) {
+			outcomes[i].attempts = append(outcomes[i].attempts, r)
+		}
+	}
+	for retry := 1; retry <= *testRetries; retry++ {
+		idx := make([]int, 0)
+		failing := make([]string, 0)
+		for i, o := range outcomes {
+			if o.failures() == len(o.attempts) {
+				idx = append(idx, i)
+				failing = append(failing, o.name)
+			}
+		}
+		if len(failing) == 0 {
+			break
+		}
+		log.Printf("retrying %d failed test case(s) (retry %d of %d)...", len(failing), retry, *testRetries)
+		for k, r := range runIsolated(fw, binary, failing, *testJobs, *testTimeout) {
+			outcomes[idx[k]].attempts = append(outcomes[idx[k]].attempts, r)
+		}
+	}
+	return outcomes
+}
+
+// flakyStats is what cm remembers about a test case between runs, stored in .cm/flaky.json
+type flakyStats struct {
+	Attempts  int    `json:"attempts"`
+	Failures  int    `json:"failures"`
+	FlakyRuns int    `json:"flaky_runs"`
+	LastFlaky string `json:"last_flaky,omitempty"`
+}
+
+// recordFlakiness adds the outcomes of this run to the flakiness statistics of the project, and highlights the test
+// cases that have now been flaky in at least flakyThreshold runs
+func recordFlakiness(target string, outcomes []caseOutcome) error {
+	dir, err := cm.StateDir(target)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, "flaky.json")
+	stats := map[string]flakyStats{}
+	b, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		if err := json.Unmarshal(b, &stats); err != nil {
+			return err
+		}
+	}
+
+	for _, o := range outcomes {
+		s := stats[o.name]
+		s.Attempts += len(o.attempts)
+		s.Failures += o.failures()
+		if o.status() == statusFlaky {
+			s.FlakyRuns++
+			s.LastFlaky = time.Now().Format(time.RFC3339)
+		}
+		stats[o.name] = s
+		if s.FlakyRuns >= flakyThreshold {
+			log.Printf(
+				"⚠ %q has been flaky in %d runs (%d of %d attempts failed, last %s)",
+				o.name, s.FlakyRuns, s.Failures, s.Attempts, s.LastFlaky,
+			)
+		}
+	}
+
+	b, err = json.MarshalIndent(stats, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0664)
+}
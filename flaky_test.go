@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/damienstanton/cm/pkg/cm"
+)
+
+// outcome builds a caseOutcome with an attempt of each of the given statuses
+func outcome(name string, statuses ...string) caseOutcome {
+	o := caseOutcome{name: name}
+	for i, s := range statuses {
+		o.attempts = append(o.attempts, testResult{name: name, status: s, output: []byte{byte('0' + i)}})
+	}
+	return o
+}
+
+func TestCaseOutcomeStatus(t *testing.T) {
+	tests := []struct {
+		statuses    []string
+		status      string
+		failures    int
+		lastFailure byte
+	}{
+		{[]string{statusPass}, statusPass, 0, '0'},
+		{[]string{statusPass, statusPass, statusPass}, statusPass, 0, '2'},
+		{[]string{statusFail}, statusFail, 1, '0'},
+		{[]string{statusFail, statusCrash}, statusCrash, 2, '1'},
+		{[]string{statusCrash, statusTimeout, statusFail}, statusFail, 3, '2'},
+		{[]string{statusFail, statusPass}, statusFlaky, 1, '0'},
+		{[]string{statusPass, statusTimeout, statusPass}, statusFlaky, 1, '1'},
+		{[]string{statusPass, statusFail, statusFail}, statusFlaky, 2, '2'},
+	}
+	for _, tt := range tests {
+		o := outcome("t", tt.statuses...)
+		if got := o.status(); got != tt.status {
+			t.Errorf("status() of %q = %s, want %s", tt.statuses, got, tt.status)
+		}
+		if got := o.failures(); got != tt.failures {
+			t.Errorf("failures() of %q = %d, want %d", tt.statuses, got, tt.failures)
+		}
+		if got := o.lastFailure().output[0]; got != tt.lastFailure {
+			t.Errorf("lastFailure() of %q is attempt %c, want %c", tt.statuses, got, tt.lastFailure)
+		}
+	}
+}
+
+func TestRunRepeated(t *testing.T) {
+	defer func(count, retries, jobs int) { *testCount, *testRetries, *testJobs = count, retries, jobs }(
+		*testCount, *testRetries, *testJobs)
+	*testJobs = 2
+	tests := []struct {
+		count, retries int
+		want           map[string]string
+		attempts       map[string]int
+	}{
+		{
+			1, 0,
+			map[string]string{"pass": statusPass, "fail": statusFail, "flaky": statusFail},
+			map[string]int{"pass": 1, "fail": 1, "flaky": 1},
+		},
+		{
+			1, 3,
+			map[string]string{"pass": statusPass, "fail": statusFail, "flaky": statusFlaky},
+			map[string]int{"pass": 1, "fail": 4, "flaky": 2},
+		},
+		{
+			3, 1,
+			map[string]string{"pass": statusPass, "fail": statusFail, "flaky": statusFlaky},
+			map[string]int{"pass": 3, "fail": 4, "flaky": 3},
+		},
+	}
+	for _, tt := range tests {
+		*testCount, *testRetries = tt.count, tt.retries
+		for _, o := range runRepeated(catch2{}, fakeTestBinary(t), []string{"pass", "fail", "flaky"}) {
+			if o.status() != tt.want[o.name] || len(o.attempts) != tt.attempts[o.name] {
+				t.Errorf("-count %d -retry-failed %d: %s is %s after %d attempts, want %s after %d",
+					tt.count, tt.retries, o.name, o.status(), len(o.attempts), tt.want[o.name], tt.attempts[o.name])
+			}
+		}
+	}
+}
+
+func TestRecordFlakiness(t *testing.T) {
+	target, err := ioutil.TempDir("", "cm-flaky")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(target)
+	runs := [][]caseOutcome{
+		{outcome("a", statusFail, statusPass), outcome("b", statusPass), outcome("c", statusFail)},
+		{outcome("a", statusPass, statusPass), outcome("b", statusPass, statusCrash)},
+		{outcome("a", statusTimeout, statusPass, statusPass)},
+	}
+	for _, outcomes := range runs {
+		if err := recordFlakiness(target, outcomes); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b, err := ioutil.ReadFile(filepath.Join(target, ".cm", "flaky.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats := map[string]flakyStats{}
+	if err := json.Unmarshal(b, &stats); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]flakyStats{
+		"a": {Attempts: 7, Failures: 2, FlakyRuns: 2},
+		"b": {Attempts: 3, Failures: 1, FlakyRuns: 1},
+		"c": {Attempts: 1, Failures: 1, FlakyRuns: 0},
+	}
+	for name, w := range want {
+		s := stats[name]
+		if s.Attempts != w.Attempts || s.Failures != w.Failures || s.FlakyRuns != w.FlakyRuns {
+			t.Errorf("stats of %s = %+v, want %+v", name, s, w)
+		}
+		if (s.LastFlaky != "") != (w.FlakyRuns > 0) {
+			t.Errorf("stats of %s have last flaky run %q after %d flaky runs", name, s.LastFlaky, s.FlakyRuns)
+		}
+	}
+}
+
+func TestRecordFlakinessCorruptStats(t *testing.T) {
+	target, err := ioutil.TempDir("", "cm-flaky")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(target)
+	dir, _ := cm.StateDir(target)
+	if err := ioutil.WriteFile(filepath.Join(dir, "flaky.json"), []byte("{"), 0664); err != nil {
+		t.Fatal(err)
+	}
+	if err := recordFlakiness(target, []caseOutcome{outcome("a", statusPass)}); err == nil {
+		t.Error("recordFlakiness() with a corrupt flaky.json did not fail")
+	}
+}
+
+func TestReportResultsPassesFlakyTests(t *testing.T) {
+	if !reportResults([]caseOutcome{outcome("a", statusPass), outcome("b", statusFail, statusPass)}) {
+		t.Error("reportResults() failed a run whose only failure was flaky")
+	}
+	if reportResults([]caseOutcome{outcome("a", statusFail, statusPass), outcome("b", statusCrash, statusFail)}) {
+		t.Error("reportResults() passed a run with a consistently failing test case")
+	}
+}
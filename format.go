@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/damienstanton/cm/pkg/cm"
+)
+
+// fmtFlags are the options accepted by `cm fmt`, on top of the global flags
+var (
+	fmtFlags   = flag.NewFlagSet("fmt", flag.ExitOnError)
+	fmtCheck   = fmtFlags.Bool("check", false, "print a diff of the files that are not formatted and exit non-zero instead of formatting them")
+	fmtChanged = fmtFlags.Bool("changed", false, "only format files changed in the working tree")
+	fmtSince   = fmtFlags.String("since", "HEAD", "git revision that -changed compares the working tree against")
+)
+
+// formatDirs are the dirs cm fmt formats by default, on top of the format_dirs in cm.json
+var formatDirs = []string{"src", "include", "tests", "cmd"}
+
+// runFmt runs clang-format over the project's sources and headers, which picks up the project's .clang-format. With
+// -check the files are left alone and the run fails if any of them would change.
+func runFmt(target string) {
+	if _, err := exec.LookPath("clang-format"); err != nil {
+		log.Fatalf("cm fmt needs clang-format: %+v", err)
+	}
+	files, err := formatFiles(target)
+	if err != nil {
+		log.Fatalf("could not find files to format: %+v", err)
+	}
+	if *fmtChanged {
+		changed, err := changedFiles(target, *fmtSince)
+		if err != nil {
+			log.Fatalf("could not determine changed files: %+v", err)
+		}
+		selected := make([]string, 0)
+		for _, f := range files {
+			if changed[f] {
+				selected = append(selected, f)
+			}
+		}
+		files = selected
+	}
+
+	unformatted := 0
+	for _, f := range files {
+		rel, err := filepath.Rel(target, f)
+		if err != nil {
+			rel = f
+		}
+		if !*fmtCheck {
+			if out, err := exec.Command("clang-format", "-i", f).CombinedOutput(); err != nil {
+				log.Fatalf("could not format %s: %v\n%s", rel, err, out)
+			}
+			continue
+		}
+		want, err := ioutil.ReadFile(f)
+		if err != nil {
+			log.Fatalf("could not read %s: %+v", rel, err)
+		}
+		var stderr bytes.Buffer
+		command := exec.Command("clang-format", f)
+		command.Stderr = &stderr
+		got, err := command.Output()
+		if err != nil {
+			log.Fatalf("could not format %s: %v\n%s", rel, err, stderr.String())
+		}
+		if !bytes.Equal(want, got) {
+			unformatted++
+			fmt.Printf("--- %s\n+++ %s (formatted)\n%s\n", rel, rel, diffLines(string(want), string(got)))
+		}
+	}
+	if unformatted > 0 {
+		log.Fatalf("%d of %d file(s) are not formatted (run cm fmt)", unformatted, len(files))
+	}
+	if *fmtCheck {
+		log.Printf("🎉 all %d file(s) are formatted", len(files))
+	} else {
+		log.Printf("🎉 formatted %d file(s)", len(files))
+	}
+}
+
+// formatFiles returns the sources and headers in the dirs cm fmt formats, leaving out the files git ignores, such as
+// the test framework cm copies into tests/
+func formatFiles(target string) ([]string, error) {
+	globs := append([]string{}, cm.SourceGlobs...)
+	for _, ext := range cm.HeaderExts {
+		globs = append(globs, "*"+ext)
+	}
+	files := make([]string, 0)
+	for _, dir := range append(formatDirs, config.FormatDirs...) {
+		dir = filepath.Join(target, dir)
+		if !isDir(dir) {
+			continue
+		}
+		found, err := cm.FindAll(dir, globs)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, found...)
+	}
+	if len(files) == 0 {
+		return files, nil
+	}
+	// check-ignore exits with 1 if no file is ignored, and outside a git repo nothing is
+	out, _ := git(target, append([]string{"check-ignore", "--"}, files...)...)
+	ignored := make(map[string]bool)
+	for _, f := range strings.Split(out, "\n") {
+		if f != "" {
+			ignored[f] = true
+		}
+	}
+	res := make([]string, 0, len(files))
+	for _, f := range files {
+		if !ignored[f] {
+			res = append(res, f)
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/damienstanton/cm/pkg/cm"
+)
+
+func TestFormatFiles(t *testing.T) {
+	defer func(c cm.Config) { config = c }(config)
+	target := tempProject(t)
+	writeFiles(t, target, map[string]string{
+		"src/main.cpp":             "",
+		"src/net/http.hpp":         "",
+		"src/net/http.cc":          "",
+		"src/README.md":            "",
+		"include/app/api.h":        "",
+		"tests/app_test.cpp":       "",
+		"tests/catch.hpp":          "",
+		"cmd/tool/main.cpp":        "",
+		"examples/demo.cpp":        "",
+		"vendor/dep/src/dep.cpp":   "",
+		"bin/generated.cpp":        "",
+		"src/generated/schema.hpp": "",
+	})
+	paths := func(rel ...string) []string {
+		res := make([]string, 0, len(rel))
+		for _, r := range rel {
+			res = append(res, filepath.Join(target, filepath.FromSlash(r)))
+		}
+		sort.Strings(res)
+		return res
+	}
+	check := func(what string, want []string) {
+		t.Helper()
+		got, err := formatFiles(target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("formatFiles() %s =\n%q\nwant\n%q", what, got, want)
+		}
+	}
+
+	config = cm.Config{}
+	all := []string{"src/main.cpp", "src/net/http.hpp", "src/net/http.cc", "src/generated/schema.hpp",
+		"include/app/api.h", "tests/app_test.cpp", "tests/catch.hpp", "cmd/tool/main.cpp"}
+	check("outside a git repo", paths(all...))
+
+	config = cm.Config{FormatDirs: []string{"examples", "missing"}}
+	check("with format_dirs", paths(append(all, "examples/demo.cpp")...))
+
+	writeFiles(t, target, map[string]string{".gitignore": "tests/catch.hpp\nsrc/generated/\n"})
+	if _, err := git(target, "init", "-q"); err != nil {
+		t.Skipf("git is not usable here: %v", err)
+	}
+	config = cm.Config{}
+	check("leaving out ignored files", paths("src/main.cpp", "src/net/http.hpp", "src/net/http.cc",
+		"include/app/api.h", "tests/app_test.cpp", "cmd/tool/main.cpp"))
+
+	empty := tempProject(t)
+	if got, err := formatFiles(empty); err != nil || len(got) != 0 {
+		t.Errorf("formatFiles() of an empty project = %q, %v", got, err)
+	}
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/damienstanton/cm/pkg/cm"
+)
+
+// testFramework abstracts over the C++ test frameworks cm can build tests with. Besides preparing the build, each
+// framework is an adapter for driving its test binaries: selecting and listing test cases, and parsing the results.
+type testFramework interface {
+	// name is a human readable name, including the version where cm knows it
+	name() string
+	// setup returns the extra compiler args of the framework and the harness files that are written into the tests
+	// dir, by path, while the tests are built
+	setup(target string) (args []string, harness map[string][]byte, err error)
+	// specArgs translates a name pattern and tag expression into test binary arguments
+	specArgs(pattern, tags string) []string
+	// listArgs are the test binary arguments that list test case names
+	listArgs() []string
+	// parseList extracts the test case names from the output of a listing
+	parseList(out []byte) []string
+	// caseArgs are the test binary arguments that run exactly the named test case
+	caseArgs(name string) []string
+	// reportArgs are the test binary arguments that replace the console output with a JUnit XML report
+	reportArgs() []string
+	// summary parses the test case counts from the console output of a test run
+	summary(out []byte) (testSummary, bool)
+	// header is the #include line test files need, and testCase opens a test case with the given name and tag; both
+	// are used to generate test boilerplate
+	header() string
+	testCase(name, tag string) string
+}
+
+// testSummary counts the test cases of a run, as reported by the framework
+type testSummary struct {
+	total  int
+	passed int
+	failed int
+}
+
+// selectFramework returns the test framework chosen with -framework, falling back to cm.json and then the embedded
+// catch2
+func selectFramework() (testFramework, error) {
+	fw := *testFw
+	if fw == "" {
+		fw = config.Test.Framework
+	}
+	switch fw {
+	case "", "catch2":
+		return catch2{}, nil
+	case "catch2-v3":
+		return catch2v3{}, nil
+	case "doctest":
+		return doctest{}, nil
+	case "custom":
+		var adapter testFramework = catch2{}
+		switch config.Test.Adapter {
+		case "", "catch2":
+		case "catch2-v3":
+			adapter = catch2v3{}
+		case "doctest":
+			adapter = doctest{}
+		default:
+			return nil, fmt.Errorf("unknown test adapter %q (want catch2, catch2-v3 or doctest)", config.Test.Adapter)
+		}
+		return custom{adapter}, nil
+	default:
+		return nil, fmt.Errorf("unknown test framework %q (want catch2, catch2-v3, doctest or custom)", fw)
+	}
+}
+
+// frameworkArgs returns the compiler args that put the configured framework headers and libraries on the paths
+func frameworkArgs(defaultLibs ...string) []string {
+	args := make([]string, 0)
+	if config.Test.Include != "" {
+		args = append(args, "-I"+config.Test.Include)
+	}
+	if config.Test.Lib != "" {
+		args = append(args, "-L"+config.Test.Lib, "-Wl,-rpath,"+config.Test.Lib)
+	}
+	libs := config.Test.Libs
+	if len(libs) == 0 {
+		libs = defaultLibs
+	}
+	for _, l := range libs {
+		args = append(args, "-l"+l)
+	}
+	return args
+}
+
+// catch2 is the catch2 v2 single header embedded in cm, which is the default framework
+type catch2 struct{}
+
+func (catch2) name() string {
+	return "catch " + catchVersion
+}
+
+func (catch2) setup(target string) ([]string, map[string][]byte, error) {
+	harness, err := cm.EmbeddedCatch2(target + "/tests")
+	return nil, harness, err
+}
+
+// specArgs passes the pattern and tags as separate arguments, which catch2 ANDs together as long as neither of them
+// contains a comma
+func (catch2) specArgs(pattern, tags string) []string {
+	spec := make([]string, 0, 2)
+	if pattern != "" {
+		spec = append(spec, pattern)
+	}
+	if tags != "" {
+		spec = append(spec, tags)
+	}
+	return spec
+}
+
+func (catch2) listArgs() []string {
+	return []string{"--list-test-names-only"}
+}
+
+func (catch2) parseList(out []byte) []string {
+	names := make([]string, 0)
+	for _, line := range strings.Split(string(out), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		// names starting with # are quoted, so they are not mistaken for a file name tag
+		if strings.HasPrefix(line, "\"#") && strings.HasSuffix(line, "\"") {
+			line = line[1 : len(line)-1]
+		}
+		names = append(names, line)
+	}
+	return names
+}
+
+func (catch2) caseArgs(name string) []string {
+	return []string{escapeTestName(name)}
+}
+
+// allPassed matches catch2's summary line when every test case passed
+var allPassed = regexp.MustCompile(`All tests passed \(\d+ assertions? in (\d+) test cases?\)`)
+
+func (catch2) summary(out []byte) (testSummary, bool) {
+	if m := allPassed.FindSubmatch(out); m != nil {
+		n, _ := strconv.Atoi(string(m[1]))
+		return testSummary{total: n, passed: n}, true
+	}
+	return parseCounts(out)
+}
+
+// reportArgs selects the JUnit reporter, which reports every leaf section as a test case named "test case/section"
+func (catch2) reportArgs() []string {
+	return []string{"-r", "junit"}
+}
+
+func (catch2) header() string {
+	return `#include "catch.hpp"`
+}
+
+func (catch2) testCase(name, tag string) string {
+	return fmt.Sprintf("TEST_CASE(%q, \"[%s]\")", name, tag)
+}
+
+// escapeTestName escapes the characters catch2 treats specially in a test spec, so the spec matches exactly one name.
+// catch2 v2 drops the backslash before a * at either end of a name and still treats it as a wildcard.
+func escapeTestName(name string) string {
+	var b strings.Builder
+	for i, c := range name {
+		switch {
+		case c == '\\' || c == ',' || c == '[' || c == ']' || c == '"' || c == '*':
+			b.WriteRune('\\')
+		case i == 0 && c == '~':
+			b.WriteRune('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// catch2v3 is an installed catch2 v3, which is no longer a single header but a pair of libraries, one of which
+// provides main. Tests include <catch2/catch_test_macros.hpp> and friends.
+type catch2v3 struct {
+	catch2
+}
+
+func (catch2v3) name() string {
+	return "catch v3"
+}
+
+func (catch2v3) setup(target string) ([]string, map[string][]byte, error) {
+	return frameworkArgs("Catch2Main", "Catch2"), nil, nil
+}
+
+func (catch2v3) listArgs() []string {
+	return []string{"--list-tests", "--verbosity", "quiet"}
+}
+
+func (catch2v3) header() string {
+	return "#include <catch2/catch_test_macros.hpp>"
+}
+
+// doctest is an installed doctest header. cm provides the main function, so tests only #include "doctest.h".
+type doctest struct{}
+
+func (doctest) name() string {
+	return "doctest"
+}
+
+func (doctest) setup(target string) ([]string, map[string][]byte, error) {
+	hostFile := target + "/tests/test_main.cpp"
+	host := "#define DOCTEST_CONFIG_IMPLEMENT_WITH_MAIN\n#include \"doctest.h\"\n"
+	return frameworkArgs(), map[string][]byte{hostFile: []byte(host)}, nil
+}
+
+// specArgs maps tags onto test suites, the closest thing doctest has to catch2's tags, so "[greeting]" selects the
+// test cases in the "greeting" suite
+func (doctest) specArgs(pattern, tags string) []string {
+	spec := make([]string, 0, 2)
+	if pattern != "" {
+		spec = append(spec, "--test-case="+pattern)
+	}
+	if tags != "" {
+		suites := strings.NewReplacer("][", ",", "[", "", "]", "").Replace(tags)
+		spec = append(spec, "--test-suite="+suites)
+	}
+	return spec
+}
+
+func (doctest) listArgs() []string {
+	return []string{"--list-test-cases", "--no-version"}
+}
+
+func (doctest) parseList(out []byte) []string {
+	names := make([]string, 0)
+	for _, line := range strings.Split(string(out), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "[doctest]") || strings.HasPrefix(line, "====") {
+			continue
+		}
+		names = append(names, line)
+	}
+	return names
+}
+
+func (doctest) caseArgs(name string) []string {
+	return []string{"--test-case=" + strings.Replace(name, ",", "\\,", -1)}
+}
+
+func (doctest) reportArgs() []string {
+	return []string{"--reporters=junit"}
+}
+
+func (doctest) summary(out []byte) (testSummary, bool) {
+	return parseCounts(out)
+}
+
+func (doctest) header() string {
+	return `#include "doctest.h"`
+}
+
+// testCase puts the test case in a test suite named after the tag, which is what -tags selects for doctest
+func (doctest) testCase(name, tag string) string {
+	return fmt.Sprintf("TEST_CASE(%q * doctest::test_suite(%q))", name, tag)
+}
+
+// custom is a framework the project brings itself: headers, libraries and a main-providing source file are read
+// from cm.json, and the test binary is driven through the adapter of a known framework.
+type custom struct {
+	testFramework
+}
+
+func (c custom) name() string {
+	return "custom (" + c.testFramework.name() + " adapter)"
+}
+
+func (custom) setup(target string) ([]string, map[string][]byte, error) {
+	args := make([]string, 0)
+	if config.Test.Main != "" {
+		main := config.Test.Main
+		if !filepath.IsAbs(main) {
+			main = filepath.Join(target, main)
+		}
+		args = append(args, main)
+	}
+	return append(args, frameworkArgs()...), nil, nil
+}
+
+// testCounts matches the "test cases: 3 | 2 passed | 1 failed" summary line shared by catch2 and doctest
+var testCounts = regexp.MustCompile(`test cases:\s*(\d+)((?:\s*\|\s*\d+ [a-z ]+)*)`)
+
+// parseCounts parses the test case counts line of catch2 and doctest. Both frameworks omit zero counts in places, so
+// the parts after the total are matched by name.
+func parseCounts(out []byte) (testSummary, bool) {
+	m := testCounts.FindSubmatch(out)
+	if m == nil {
+		return testSummary{}, false
+	}
+	var sum testSummary
+	sum.total, _ = strconv.Atoi(string(m[1]))
+	for _, part := range strings.Split(string(m[2]), "|") {
+		fields := strings.Fields(part)
+		if len(fields) < 2 {
+			continue
+		}
+		n, _ := strconv.Atoi(fields[0])
+		switch fields[1] {
+		case "passed":
+			sum.passed = n
+		case "failed":
+			// catch2 counts the test cases marked [!shouldfail] as "failed as expected", which is not a failure
+			if len(fields) == 2 {
+				sum.failed += n
+			}
+		}
+	}
+	return sum, true
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/damienstanton/cm/pkg/cm"
+)
+
+func TestSelectFramework(t *testing.T) {
+	defer func(fw string, c cm.Config) { *testFw, config = fw, c }(*testFw, config)
+	tests := []struct {
+		flag, framework, adapter string
+		want                     testFramework
+		wantErr                  bool
+	}{
+		{"", "", "", catch2{}, false},
+		{"", "doctest", "", doctest{}, false},
+		{"catch2-v3", "doctest", "", catch2v3{}, false},
+		{"", "custom", "", custom{catch2{}}, false},
+		{"", "custom", "doctest", custom{doctest{}}, false},
+		{"custom", "", "catch2-v3", custom{catch2v3{}}, false},
+		{"", "custom", "gtest", nil, true},
+		{"gtest", "", "", nil, true},
+	}
+	for _, tt := range tests {
+		*testFw = tt.flag
+		config.Test = cm.TestConfig{Framework: tt.framework, Adapter: tt.adapter}
+		got, err := selectFramework()
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("selectFramework() with -framework %q, cm.json %q/%q = %#v, %v, want %#v (error %v)",
+				tt.flag, tt.framework, tt.adapter, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestFrameworkArgs(t *testing.T) {
+	defer func(c cm.Config) { config = c }(config)
+	config.Test = cm.TestConfig{Include: "/opt/catch/include", Lib: "/opt/catch/lib"}
+	want := []string{"-I/opt/catch/include", "-L/opt/catch/lib", "-Wl,-rpath,/opt/catch/lib", "-lCatch2Main", "-lCatch2"}
+	if got := frameworkArgs("Catch2Main", "Catch2"); !reflect.DeepEqual(got, want) {
+		t.Errorf("frameworkArgs() = %q, want %q", got, want)
+	}
+	config.Test = cm.TestConfig{Libs: []string{"gtest_main"}}
+	if got := frameworkArgs("Catch2Main"); !reflect.DeepEqual(got, []string{"-lgtest_main"}) {
+		t.Errorf("frameworkArgs() with libs from cm.json = %q", got)
+	}
+}
+
+func TestEscapeTestName(t *testing.T) {
+	tests := map[string]string{
+		"adds":              "adds",
+		"fails, with comma": `fails\, with comma`,
+		"[not a tag]":       `\[not a tag\]`,
+		`says "hi"`:         `says \"hi\"`,
+		`back\slash`:        `back\\slash`,
+		"*wild*":            `\*wild\*`,
+		"a*b":               `a\*b`,
+		"~negated":          `\~negated`,
+		"not~negated":       "not~negated",
+	}
+	for name, want := range tests {
+		if got := escapeTestName(name); got != want {
+			t.Errorf("escapeTestName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestCaseArgs(t *testing.T) {
+	tests := []struct {
+		fw   testFramework
+		name string
+		want string
+	}{
+		{catch2{}, "fails, with comma", `fails\, with comma`},
+		{catch2v3{}, "[not a tag]", `\[not a tag\]`},
+		{doctest{}, "fails, with comma", `--test-case=fails\, with comma`},
+		{custom{doctest{}}, "adds", "--test-case=adds"},
+	}
+	for _, tt := range tests {
+		if got := tt.fw.caseArgs(tt.name); !reflect.DeepEqual(got, []string{tt.want}) {
+			t.Errorf("%s caseArgs(%q) = %q, want %q", tt.fw.name(), tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseCounts(t *testing.T) {
+	tests := []struct {
+		name string
+		out  string
+		want testSummary
+		ok   bool
+	}{
+		{
+			"catch2 failures",
+			"test cases: 3 | 1 passed | 2 failed\nassertions: 4 | 2 passed | 2 failed\n",
+			testSummary{3, 1, 2},
+			true,
+		},
+		{"catch2 all failed", "test cases: 2 | 2 failed\nassertions: 2 | 2 failed\n", testSummary{2, 0, 2}, true},
+		{"catch2 failed as expected", "test cases: 2 | 1 passed | 1 failed as expected\n", testSummary{2, 1, 0}, true},
+		{
+			"catch2 failed and failed as expected",
+			"test cases: 3 | 1 passed | 1 failed | 1 failed as expected\n",
+			testSummary{3, 1, 1},
+			true,
+		},
+		{
+			"doctest",
+			"[doctest] test cases: 3 | 2 passed | 1 failed | 0 skipped\n[doctest] assertions: 3 | 2 passed | 1 failed |\n",
+			testSummary{3, 2, 1},
+			true,
+		},
+		{"no summary", "No tests ran\n", testSummary{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseCounts([]byte(tt.out))
+			if ok != tt.ok || got != tt.want {
+				t.Errorf("parseCounts() = %+v, %v, want %+v, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestCatch2Summary(t *testing.T) {
+	tests := []struct {
+		out  string
+		want testSummary
+		ok   bool
+	}{
+		{"All tests passed (2 assertions in 2 test cases)\n", testSummary{2, 2, 0}, true},
+		{"All tests passed (1 assertion in 1 test case)\n", testSummary{1, 1, 0}, true},
+		{"test cases: 2 | 1 passed | 1 failed\n", testSummary{2, 1, 1}, true},
+		{"No tests ran\n", testSummary{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := catch2{}.summary([]byte(tt.out))
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("summary(%q) = %+v, %v, want %+v, %v", tt.out, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestParseList(t *testing.T) {
+	tests := []struct {
+		name string
+		fw   testFramework
+		out  string
+		want []string
+	}{
+		{"catch2", catch2{}, "adds\nfails, with comma\n\n", []string{"adds", "fails, with comma"}},
+		{"catch2 quoted hash", catch2{}, "\"#1 first\"\r\nsecond\r\n", []string{"#1 first", "second"}},
+		{"catch2 empty", catch2{}, "", []string{}},
+		{
+			"doctest",
+			doctest{},
+			"[doctest] listing all test case names\n" +
+				"===============================================================================\n" +
+				"adds\nfails, with comma\n" +
+				"===============================================================================\n" +
+				"[doctest] unskipped test cases passing the current filters: 2\n",
+			[]string{"adds", "fails, with comma"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.fw.parseList([]byte(tt.out)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseList() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSpecArgs(t *testing.T) {
+	tests := []struct {
+		name    string
+		fw      testFramework
+		pattern string
+		tags    string
+		want    []string
+	}{
+		{"catch2 nothing", catch2{}, "", "", []string{}},
+		{"catch2 pattern and tags", catch2{}, "Greeting*", "[greeting]", []string{"Greeting*", "[greeting]"}},
+		{"catch2 tags", catch2{}, "", "[a][b]", []string{"[a][b]"}},
+		{"doctest pattern", doctest{}, "Greeting*", "", []string{"--test-case=Greeting*"}},
+		{"doctest tags", doctest{}, "", "[a][b]", []string{"--test-suite=a,b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.fw.specArgs(tt.pattern, tt.tags); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("specArgs(%q, %q) = %q, want %q", tt.pattern, tt.tags, got, tt.want)
+			}
+		})
+	}
+}
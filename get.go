@@ -0,0 +1,200 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/damienstanton/cm/pkg/cm"
+)
+
+// getFlags is the flag set of `cm get`, which only takes the global flags
+var getFlags = flag.NewFlagSet("get", flag.ExitOnError)
+
+// runGet fetches the dependency given as <url>@<version> and adds it to cm.lock, replacing any other version of it.
+// Without arguments it checks out and builds every dependency at the commit locked in cm.lock.
+func runGet(target string, args []string) {
+	l, err := cm.ReadLock(target)
+	if err != nil {
+		log.Fatalf("could not read %s: %+v", cm.LockFile, err)
+	}
+	if len(args) == 0 {
+		if len(l.Dependencies) == 0 {
+			log.Printf("no dependencies in %s (usage: cm get <path-or-git-url>@<version>)", cm.LockFile)
+			return
+		}
+		for _, d := range l.Dependencies {
+			if _, err := fetchDependency(target, d); err != nil {
+				log.Fatalf("could not get %s: %+v", d.Name, err)
+			}
+		}
+		return
+	}
+
+	for _, a := range args {
+		d := parseDependency(a)
+		d, err = fetchDependency(target, d)
+		if err != nil {
+			log.Fatalf("could not get %s: %+v", a, err)
+		}
+		replaced := false
+		for i := range l.Dependencies {
+			if l.Dependencies[i].Name == d.Name {
+				l.Dependencies[i] = d
+				replaced = true
+			}
+		}
+		if !replaced {
+			l.Dependencies = append(l.Dependencies, d)
+		}
+	}
+	if err := cm.WriteLock(target, l); err != nil {
+		log.Fatalf("could not write %s: %+v", cm.LockFile, err)
+	}
+}
+
+// parseDependency splits <url>@<version> and names the dependency after the last element of the URL. An @ that is
+// followed by a path, as in git@github.com:user/repo, belongs to the URL.
+func parseDependency(arg string) cm.Dependency {
+	d := cm.Dependency{URL: arg}
+	if i := strings.LastIndex(arg, "@"); i > 0 && !strings.ContainsAny(arg[i+1:], "/:") {
+		d.URL, d.Version = arg[:i], arg[i+1:]
+	}
+	base := strings.TrimRight(d.URL, "/")
+	if i := strings.LastIndexAny(base, "/:"); i >= 0 {
+		base = base[i+1:]
+	}
+	d.Name = strings.TrimSuffix(base, ".git")
+	return d
+}
+
+// fetchDependency clones or updates the dependency in third_party/, checks out its locked commit (or resolves its
+// version to one, if it has no commit yet) and builds it. It returns the dependency with its commit filled in.
+func fetchDependency(target string, d cm.Dependency) (cm.Dependency, error) {
+	if d.Name == "" || d.Name == "." || d.Name == ".." {
+		return d, fmt.Errorf("cannot name a dependency after %q", d.URL)
+	}
+	dir := filepath.Join(target, cm.VendorDir, d.Name)
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		log.Printf("cloning %s into %s/%s...", d.URL, cm.VendorDir, d.Name)
+		if err := os.MkdirAll(filepath.Dir(dir), 0777); err != nil {
+			return d, err
+		}
+		if _, err := git(target, "clone", "--quiet", d.URL, dir); err != nil {
+			return d, err
+		}
+	} else if err != nil {
+		return d, err
+	} else if d.Commit == "" || !hasCommit(dir, d.Commit) {
+		log.Printf("fetching %s...", d.URL)
+		url := d.URL
+		// a relative local path is relative to the project, not the clone
+		if _, err := os.Stat(filepath.Join(target, url)); err == nil && !filepath.IsAbs(url) {
+			url = filepath.Join(target, url)
+		}
+		if _, err := git(dir, "fetch", "--quiet", "--tags", url, "+refs/heads/*:refs/remotes/origin/*"); err != nil {
+			return d, err
+		}
+	}
+
+	if d.Commit == "" {
+		commit, err := resolveVersion(dir, d.Version)
+		if err != nil {
+			return d, err
+		}
+		d.Commit = commit
+	}
+	if _, err := git(dir, "checkout", "--quiet", "--detach", d.Commit); err != nil {
+		return d, err
+	}
+	version := d.Version
+	if version == "" {
+		version = "default branch"
+	}
+	log.Printf("%s is at %s (%s)", d.Name, d.Commit, version)
+	return d, buildDependency(dir)
+}
+
+// hasCommit reports whether the repository in dir already has the given commit
+func hasCommit(dir, commit string) bool {
+	_, err := git(dir, "cat-file", "-e", commit+"^{commit}")
+	return err == nil
+}
+
+// resolveVersion returns the commit a tag, branch or commit refers to in the clone in dir. Branches are looked up on
+// the remote, so a version always means what it means upstream.
+func resolveVersion(dir, version string) (string, error) {
+	candidates := []string{"origin/HEAD"}
+	if version != "" {
+		candidates = []string{"refs/tags/" + version, "origin/" + version, version}
+	}
+	for _, c := range candidates {
+		if commit, err := git(dir, "rev-parse", "--verify", "--quiet", c+"^{commit}"); err == nil {
+			return commit, nil
+		}
+	}
+	if version == "" {
+		return git(dir, "rev-parse", "HEAD")
+	}
+	return "", fmt.Errorf("no tag, branch or commit named %q", version)
+}
+
+// buildDependency builds a dependency that is itself a cm library, after getting its own locked dependencies. Any
+// other dependency is used header-only.
+func buildDependency(dir string) error {
+	if _, err := os.Stat(filepath.Join(dir, cm.ConfigFile)); os.IsNotExist(err) {
+		return nil
+	}
+	c, err := cm.LoadConfig(dir)
+	if err != nil {
+		return fmt.Errorf("%s: %w", dir, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, cm.LockFile)); err == nil {
+		if err := runSelf(dir, "get"); err != nil {
+			return err
+		}
+	}
+	if c.Type != "lib" {
+		return nil
+	}
+	log.Printf("building %s...", filepath.Base(dir))
+	return runSelf(dir, "build")
+}
+
+// runSelf runs cm with the given command in dir, passing on the compiler flags
+func runSelf(dir, cmd string) error {
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	args := []string{cmd, "-compiler", *compiler, "-std", *std}
+	if *optimize {
+		args = append(args, "-max")
+	}
+	command := exec.Command(self, args...)
+	command.Dir = dir
+	out, err := command.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("cm %s in %s failed: %v\n%s", cmd, dir, err, out)
+	}
+	if *debug {
+		log.Print(string(out))
+	}
+	return nil
+}
+
+// isDir reports whether path is an existing dir
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// isFile reports whether path is an existing file that is not a dir
+func isFile(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
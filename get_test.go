@@ -0,0 +1,115 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/damienstanton/cm/pkg/cm"
+)
+
+func TestParseDependency(t *testing.T) {
+	tests := []struct {
+		arg  string
+		want cm.Dependency
+	}{
+		{"https://github.com/fmtlib/fmt", cm.Dependency{Name: "fmt", URL: "https://github.com/fmtlib/fmt"}},
+		{
+			"https://github.com/fmtlib/fmt@7.1.3",
+			cm.Dependency{Name: "fmt", URL: "https://github.com/fmtlib/fmt", Version: "7.1.3"},
+		},
+		{"https://github.com/fmtlib/fmt.git/", cm.Dependency{Name: "fmt", URL: "https://github.com/fmtlib/fmt.git/"}},
+		{"git@github.com:fmtlib/fmt.git", cm.Dependency{Name: "fmt", URL: "git@github.com:fmtlib/fmt.git"}},
+		{
+			"git@github.com:fmtlib/fmt.git@v7",
+			cm.Dependency{Name: "fmt", URL: "git@github.com:fmtlib/fmt.git", Version: "v7"},
+		},
+		{"git@host:repo", cm.Dependency{Name: "repo", URL: "git@host:repo"}},
+		{"../mylib@main", cm.Dependency{Name: "mylib", URL: "../mylib", Version: "main"}},
+		{"https://user@example.com/r", cm.Dependency{Name: "r", URL: "https://user@example.com/r"}},
+	}
+	for _, tt := range tests {
+		if got := parseDependency(tt.arg); got != tt.want {
+			t.Errorf("parseDependency(%q) = %+v, want %+v", tt.arg, got, tt.want)
+		}
+	}
+}
+
+// tempProject returns a new empty dir that is removed when the test ends
+func tempProject(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "cm-project")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+// writeFiles writes the files, keyed by slash separated path, into dir
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for p, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(p))
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestFetchDependency(t *testing.T) {
+	upstream := tempProject(t)
+	commit := func(content string) string {
+		writeFiles(t, upstream, map[string]string{"lib.hpp": content})
+		for _, args := range [][]string{
+			{"add", "-A"},
+			{"-c", "user.name=cm", "-c", "user.email=cm@localhost", "commit", "-q", "-m", content},
+		} {
+			if _, err := git(upstream, args...); err != nil {
+				t.Fatal(err)
+			}
+		}
+		head, _ := git(upstream, "rev-parse", "HEAD")
+		return head
+	}
+	if _, err := git(upstream, "init", "-q"); err != nil {
+		t.Skipf("git is not usable here: %v", err)
+	}
+	v1 := commit("// v1\n")
+	if _, err := git(upstream, "tag", "v1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := git(upstream, "checkout", "-q", "-b", "dev"); err != nil {
+		t.Fatal(err)
+	}
+	dev := commit("// dev\n")
+
+	target := tempProject(t)
+	checkedOut := func() string {
+		b, _ := ioutil.ReadFile(filepath.Join(target, "third_party", filepath.Base(upstream), "lib.hpp"))
+		return string(b)
+	}
+	d, err := fetchDependency(target, parseDependency(upstream+"@v1"))
+	if err != nil || d.Commit != v1 || checkedOut() != "// v1\n" {
+		t.Errorf("fetchDependency() of a tag = %+v, %v with %q checked out, want commit %s", d, err, checkedOut(), v1)
+	}
+	d, err = fetchDependency(target, parseDependency(upstream+"@dev"))
+	if err != nil || d.Commit != dev || checkedOut() != "// dev\n" {
+		t.Errorf("fetchDependency() of a branch = %+v, %v, want commit %s", d, err, dev)
+	}
+
+	// a locked commit is checked out even after the branch moved on upstream
+	commit("// dev 2\n")
+	d.Commit = v1
+	if d, err = fetchDependency(target, d); err != nil || d.Commit != v1 || checkedOut() != "// v1\n" {
+		t.Errorf("fetchDependency() of a locked commit = %+v, %v, want commit %s", d, err, v1)
+	}
+	if _, err := fetchDependency(target, parseDependency(upstream+"@v2")); err == nil {
+		t.Error("fetchDependency() of an unknown version did not fail")
+	}
+	if _, err := fetchDependency(target, cm.Dependency{URL: "/"}); err == nil {
+		t.Error("fetchDependency() of an unnamed dependency did not fail")
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/damienstanton/cm/pkg/cm"
+)
+
+// graphFlags are the options accepted by `cm graph`, on top of the global flags
+var (
+	graphFlags = flag.NewFlagSet("graph", flag.ExitOnError)
+	graphJSON  = graphFlags.Bool("json", false, "print the graph and its analysis as JSON instead of Graphviz DOT")
+	graphTop   = graphFlags.Int("top", 10, "number of most expensive headers to report")
+)
+
+// graphReport is the JSON output of `cm graph`: the include graph and what cm finds in it
+type graphReport struct {
+	*cm.IncludeGraph
+	Cycles      [][]string        `json:"cycles"`
+	Expensive   []cm.GraphFile    `json:"expensive"`
+	CrossModule []cm.CrossInclude `json:"cross_module"`
+}
+
+// runGraph prints the project's include graph as DOT, or JSON with -json, to stdout, and reports include cycles, the
+// most expensive headers and headers included from other modules
+func runGraph(target string) {
+	log.Println("scanning includes...")
+	g, err := project(target).IncludeGraph(buildOptions())
+	if err != nil {
+		log.Fatalf("%+v", err)
+	}
+	report := graphReport{g, g.Cycles(), g.Expensive(*graphTop), g.CrossModule()}
+	if *graphJSON {
+		out, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			log.Fatalf("could not encode include graph: %+v", err)
+		}
+		fmt.Println(string(out))
+	} else {
+		fmt.Print(g.DOT())
+	}
+
+	log.Printf("%d files, %d includes", len(g.Files), len(g.Edges))
+	if len(report.Cycles) == 0 {
+		log.Println("no include cycles")
+	}
+	for _, c := range report.Cycles {
+		log.Printf("include cycle: %s", strings.Join(c, " -> "))
+	}
+	if len(report.Expensive) > 0 {
+		log.Println("most expensive headers (size × translation units including them):")
+	}
+	for _, f := range report.Expensive {
+		log.Printf("  %-40s %8d bytes × %3d = %d", f.Path, f.Size, f.FanIn, f.Cost())
+	}
+	for _, c := range report.CrossModule {
+		log.Printf("%s (owned by %s) is included by %s: %s", c.Header, c.Owner, c.Module, strings.Join(c.IncludedBy, ", "))
+	}
+}
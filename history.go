@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/damienstanton/cm/pkg/cm"
+)
+
+// history flags for `cm test`
+var (
+	testHistory    = testFlags.Bool("history", false, "print a report of past test runs instead of running tests")
+	testLastFailed = testFlags.Bool("last-failed", false, "only run the test cases that failed in the last recorded run")
+)
+
+// historyFile is the file in the state dir every test run is appended to, one JSON object per line
+const historyFile = "history.jsonl"
+
+// historyRun is the record of one `cm test` run. Cases are missing from runs whose test binary crashed or wrote no
+// JUnit report, which only record their overall result.
+type historyRun struct {
+	Time      time.Time     `json:"time"`
+	Commit    string        `json:"commit,omitempty"`
+	Framework string        `json:"framework"`
+	Seconds   float64       `json:"seconds"`
+	Passed    bool          `json:"passed"`
+	Cases     []historyCase `json:"cases,omitempty"`
+}
+
+// historyCase is the record of one test case in a run
+type historyCase struct {
+	Name     string  `json:"name"`
+	Status   string  `json:"status"`
+	Seconds  float64 `json:"seconds"`
+	Attempts int     `json:"attempts"`
+}
+
+// recordHistory appends a test run to the project's history
+func recordHistory(target string, fw testFramework, start time.Time, passed bool, outcomes []caseOutcome) error {
+	dir, err := cm.StateDir(target)
+	if err != nil {
+		return err
+	}
+	run := historyRun{
+		Time:      start,
+		Framework: fw.name(),
+		Seconds:   time.Since(start).Seconds(),
+		Passed:    passed,
+	}
+	// outside a git repo (or before the first commit) runs are simply not tied to a commit
+	run.Commit, _ = git(target, "rev-parse", "--short", "HEAD")
+	for _, o := range outcomes {
+		last := o.attempts[len(o.attempts)-1]
+		run.Cases = append(run.Cases, historyCase{
+			Name:     o.name,
+			Status:   o.status(),
+			Seconds:  last.duration.Seconds(),
+			Attempts: len(o.attempts),
+		})
+	}
+	b, err := json.Marshal(run)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(dir, historyFile), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0664)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(b, '\n'))
+	return err
+}
+
+// loadHistory reads every recorded test run of the project, oldest first
+func loadHistory(target string) ([]historyRun, error) {
+	f, err := os.Open(filepath.Join(target, ".cm", historyFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	runs := make([]historyRun, 0)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var run historyRun
+		if err := json.Unmarshal(scanner.Bytes(), &run); err != nil {
+			return nil, fmt.Errorf("corrupt test history: %w", err)
+		}
+		runs = append(runs, run)
+	}
+	return runs, scanner.Err()
+}
+
+// caseRuns returns the runs that recorded individual test cases, oldest first
+func caseRuns(runs []historyRun) []historyRun {
+	res := make([]historyRun, 0, len(runs))
+	for _, r := range runs {
+		if len(r.Cases) > 0 {
+			res = append(res, r)
+		}
+	}
+	return res
+}
+
+// lastFailed returns the names of the test cases that failed in the most recent run. An older run is never used in its
+// place, since its failures may have been fixed since.
+func lastFailed(target string) (map[string]bool, error) {
+	runs, err := loadHistory(target)
+	if err != nil {
+		return nil, err
+	}
+	failed := map[string]bool{}
+	if len(runs) == 0 {
+		return failed, nil
+	}
+	last := runs[len(runs)-1]
+	if len(last.Cases) == 0 && !last.Passed {
+		return nil, fmt.Errorf("the last test run (%s) did not record its test cases", last.Time.Format(time.RFC822))
+	}
+	for _, c := range last.Cases {
+		if c.Status != statusPass {
+			failed[c.Name] = true
+		}
+	}
+	return failed, nil
+}
+
+// filterLastFailed keeps only the names that failed in the last recorded run
+func filterLastFailed(target string, names []string) []string {
+	failed, err := lastFailed(target)
+	if err != nil {
+		log.Fatalf("could not read test history: %+v", err)
+	}
+	res := make([]string, 0, len(failed))
+	for _, n := range names {
+		if failed[n] {
+			res = append(res, n)
+		}
+	}
+	if len(res) == 0 {
+		log.Println("no test cases failed in the last recorded run")
+	}
+	return res
+}
+
+// regressionFactor is how much slower than its median a test case must get to be reported as a duration regression
+const regressionFactor = 1.5
+
+// reportHistory prints the slowest test cases, test cases that started or stopped failing in their latest run, and test
+// cases whose latest run was markedly slower than their median duration
+func reportHistory(target string) {
+	runs, err := loadHistory(target)
+	if err != nil {
+		log.Fatalf("could not read test history: %+v", err)
+	}
+	if len(runs) == 0 {
+		log.Println("no test runs recorded yet")
+		return
+	}
+	passed := 0
+	for _, r := range runs {
+		if r.Passed {
+			passed++
+		}
+	}
+	last := runs[len(runs)-1]
+	at := last.Time.Format(time.RFC822)
+	if last.Commit != "" {
+		at += " on " + last.Commit
+	}
+	log.Printf("%d recorded test runs, %d passed; last run %s", len(runs), passed, at)
+
+	runs = caseRuns(runs)
+	if len(runs) == 0 {
+		log.Println("no runs recorded individual test cases")
+		return
+	}
+	// every run may have selected different test cases, so each one is compared against its own previous record
+	records := map[string][]historyCase{}
+	names := make([]string, 0)
+	for _, r := range runs {
+		for _, c := range r.Cases {
+			if _, ok := records[c.Name]; !ok {
+				names = append(names, c.Name)
+			}
+			records[c.Name] = append(records[c.Name], c)
+		}
+	}
+
+	slowest := make([]historyCase, 0, len(names))
+	for _, n := range names {
+		slowest = append(slowest, records[n][len(records[n])-1])
+	}
+	sort.SliceStable(slowest, func(i, j int) bool { return slowest[i].Seconds > slowest[j].Seconds })
+	if len(slowest) > 10 {
+		slowest = slowest[:10]
+	}
+	fmt.Println("\nslowest test cases:")
+	for _, c := range slowest {
+		fmt.Printf("    %8.3fs  %s\n", c.Seconds, c.Name)
+	}
+
+	newlyFailing := make([]string, 0)
+	fixed := make([]string, 0)
+	regressions := make([]string, 0)
+	for _, n := range names {
+		recs := records[n]
+		if len(recs) < 2 {
+			continue
+		}
+		cur, prev := recs[len(recs)-1], recs[len(recs)-2]
+		switch {
+		case cur.Status != statusPass && prev.Status == statusPass:
+			newlyFailing = append(newlyFailing, n)
+		case cur.Status == statusPass && prev.Status != statusPass:
+			fixed = append(fixed, n)
+		}
+		durations := make([]float64, 0, len(recs)-1)
+		for _, r := range recs[:len(recs)-1] {
+			durations = append(durations, r.Seconds)
+		}
+		if m := median(durations); m > 0 && cur.Seconds > m*regressionFactor && cur.Seconds-m > 0.01 {
+			regressions = append(regressions, fmt.Sprintf("%s: %.3fs, median %.3fs", n, cur.Seconds, m))
+		}
+	}
+	printList("newly failing:", newlyFailing)
+	printList("recently fixed:", fixed)
+	printList("duration regressions:", regressions)
+	fmt.Println("")
+}
+
+// printList prints a heading followed by the indented items, or "none"
+func printList(heading string, items []string) {
+	fmt.Println("\n" + heading)
+	if len(items) == 0 {
+		fmt.Println("    none")
+	}
+	for _, i := range items {
+		fmt.Println("    " + i)
+	}
+}
+
+// median returns the median of the values, or 0 if there are none
+func median(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]float64{}, values...)
+	sort.Float64s(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
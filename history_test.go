@@ -0,0 +1,123 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/damienstanton/cm/pkg/cm"
+)
+
+// historyTarget returns a project dir holding the given history lines
+func historyTarget(t *testing.T, lines string) string {
+	target, err := ioutil.TempDir("", "cm-history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(target) })
+	if lines != "" {
+		dir, _ := cm.StateDir(target)
+		if err := ioutil.WriteFile(filepath.Join(dir, historyFile), []byte(lines), 0664); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return target
+}
+
+func TestRecordHistory(t *testing.T) {
+	target := historyTarget(t, "")
+	start := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	flaky := outcome("b", statusFail, statusPass)
+	flaky.attempts[1].duration = 2 * time.Second
+	if err := recordHistory(target, doctest{}, start, true, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := recordHistory(target, catch2{}, start, false, []caseOutcome{outcome("a", statusFail), flaky}); err != nil {
+		t.Fatal(err)
+	}
+	runs, err := loadHistory(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 2 {
+		t.Fatalf("loadHistory() returned %d runs, want 2", len(runs))
+	}
+	if !runs[0].Passed || runs[0].Framework != "doctest" || runs[0].Cases != nil || !runs[0].Time.Equal(start) {
+		t.Errorf("first run = %+v", runs[0])
+	}
+	want := []historyCase{
+		{Name: "a", Status: statusFail, Attempts: 1},
+		{Name: "b", Status: statusFlaky, Seconds: 2, Attempts: 2},
+	}
+	if runs[1].Passed || !reflect.DeepEqual(runs[1].Cases, want) {
+		t.Errorf("second run = %+v, want cases %+v", runs[1], want)
+	}
+}
+
+func TestLoadHistory(t *testing.T) {
+	if runs, err := loadHistory(historyTarget(t, "")); err != nil || runs != nil {
+		t.Errorf("loadHistory() without a history = %v, %v", runs, err)
+	}
+	if _, err := loadHistory(historyTarget(t, "{\"passed\": true}\n{\"passed\": \n")); err == nil {
+		t.Error("loadHistory() of a corrupt history did not fail")
+	}
+}
+
+func TestLastFailed(t *testing.T) {
+	tests := []struct {
+		name    string
+		history string
+		want    map[string]bool
+		wantErr bool
+	}{
+		{"no history", "", map[string]bool{}, false},
+		{"failed run without cases", `{"passed": false}` + "\n", nil, true},
+		{
+			"latest case run",
+			`{"passed": false, "cases": [{"name": "a", "status": "FAIL"}, {"name": "b", "status": "PASS"}]}` + "\n" +
+				`{"passed": false, "cases": [{"name": "b", "status": "CRASH"}, {"name": "c", "status": "FLAKY"}]}` + "\n",
+			map[string]bool{"b": true, "c": true},
+			false,
+		},
+		{
+			"passed run without cases",
+			`{"passed": false, "cases": [{"name": "a", "status": "TIMEOUT"}]}` + "\n" + `{"passed": true}` + "\n",
+			map[string]bool{},
+			false,
+		},
+		{
+			"older failures are not used",
+			`{"passed": false, "cases": [{"name": "a", "status": "FAIL"}]}` + "\n" + `{"passed": false}` + "\n",
+			nil,
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := lastFailed(historyTarget(t, tt.history))
+			if (err != nil) != tt.wantErr || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("lastFailed() = %v, %v, want %v (error: %v)", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMedian(t *testing.T) {
+	tests := []struct {
+		values []float64
+		want   float64
+	}{
+		{nil, 0},
+		{[]float64{3}, 3},
+		{[]float64{3, 1, 2}, 2},
+		{[]float64{4, 1, 3, 2}, 2.5},
+	}
+	for _, tt := range tests {
+		if got := median(tt.values); got != tt.want {
+			t.Errorf("median(%v) = %v, want %v", tt.values, got, tt.want)
+		}
+	}
+}
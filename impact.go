@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/damienstanton/cm/pkg/cm"
+)
+
+// test impact flags for `cm test`
+var (
+	testAffected = testFlags.Bool("affected", false, "only build and run test files affected by changes since -since")
+	testSince    = testFlags.String("since", "HEAD", "git revision that -affected compares the working tree against")
+)
+
+// selectAffectedTests narrows -files down to the test files that transitively depend on a file changed since -since.
+// The test framework's harness files are only written while tests are built, so they never count as changed and are
+// allowed to be missing while scanning. It returns false if no test file is affected, in which case there is nothing
+// to build.
+func selectAffectedTests(target string, fwArgs []string, harness map[string][]byte) (bool, error) {
+	changed, err := changedFiles(target, *testSince)
+	if err != nil {
+		return false, err
+	}
+	testsPath := target + "/tests"
+	tests, err := cm.FindAll(testsPath, cm.SourceGlobs)
+	if err != nil {
+		return false, err
+	}
+	tests, err = cm.SelectFiles(tests, testFileGlobs(), "test_main.cpp")
+	if err != nil {
+		return false, err
+	}
+	depArgs, err := buildOptions().DependencyArgs(target)
+	if err != nil {
+		return false, err
+	}
+	flags := append([]string{"-MG", "-I" + testsPath, "-I" + target + "/src"}, includeFlags(fwArgs)...)
+	flags = append(flags, includeFlags(depArgs)...)
+	changed, err = withHeaders(target, changed, flags)
+	if err != nil {
+		return false, err
+	}
+	for h := range harness {
+		delete(changed, filepath.Clean(h))
+	}
+	affected := make([]string, 0)
+	for _, t := range tests {
+		if filepath.Base(t) == "test_main.cpp" {
+			continue
+		}
+		deps, err := scanDeps(t, flags)
+		if err != nil {
+			return false, err
+		}
+		for _, d := range deps {
+			if changed[d] {
+				log.Printf("%s is affected by changes to %s", filepath.Base(t), d)
+				affected = append(affected, filepath.Base(t))
+				break
+			}
+		}
+	}
+	if len(affected) == 0 {
+		return false, nil
+	}
+	*testFiles = strings.Join(affected, ",")
+	return true, nil
+}
+
+// changedFiles returns the absolute paths of the files that differ between the working tree and the given git
+// revision, including untracked files that are not ignored
+func changedFiles(target, rev string) (map[string]bool, error) {
+	root, err := git(target, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil, err
+	}
+	diff, err := git(target, "diff", "--name-only", rev, "--")
+	if err != nil {
+		return nil, err
+	}
+	untracked, err := git(target, "ls-files", "--others", "--exclude-standard", "--full-name")
+	if err != nil {
+		return nil, err
+	}
+	res := map[string]bool{}
+	for _, f := range strings.Split(diff+"\n"+untracked, "\n") {
+		if f != "" {
+			res[filepath.Join(root, f)] = true
+		}
+	}
+	return res, nil
+}
+
+// withHeaders adds the headers every changed source file in src/ implements to the set of changed files. Test binaries
+// link the project's sources, so a change to greeting.cpp affects every test that includes the project headers it
+// includes, such as greeting.hpp. Headers of dependencies and of the tests are not implemented by the project.
+func withHeaders(target string, changed map[string]bool, flags []string) (map[string]bool, error) {
+	src := filepath.Join(target, "src") + string(filepath.Separator)
+	res := map[string]bool{}
+	for f := range changed {
+		res[f] = true
+		if !strings.HasPrefix(f, src) || !isSource(f) || !isFile(f) {
+			continue
+		}
+		deps, err := scanDeps(f, flags)
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range deps {
+			if strings.HasPrefix(d, src) && !isSource(d) {
+				res[d] = true
+			}
+		}
+	}
+	return res, nil
+}
+
+// isSource reports whether the file is a C++ translation unit
+func isSource(file string) bool {
+	for _, g := range cm.SourceGlobs {
+		if ok, _ := filepath.Match(g, filepath.Base(file)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// git runs a git command in the given dir and returns its trimmed stdout
+func git(dir string, args ...string) (string, error) {
+	command := exec.Command("git", args...)
+	command.Dir = dir
+	out, err := command.Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return "", fmt.Errorf("git %s: %s", strings.Join(args, " "), strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestWithHeaders(t *testing.T) {
+	useCompiler(t)
+	target := tempProject(t)
+	writeFiles(t, target, map[string]string{
+		"src/greeting.cpp":   "#include \"greeting.hpp\"\n#include \"util/text.hpp\"\n#include <dep/dep.hpp>\n",
+		"src/greeting.hpp":   "int greeting();\n",
+		"src/util/text.hpp":  "int text();\n",
+		"src/hello.hpp":      "int hello();\n",
+		"vendor/dep/dep.hpp": "int dep();\n",
+	})
+	path := func(rel string) string { return filepath.Join(target, filepath.FromSlash(rel)) }
+	changed := map[string]bool{path("src/greeting.cpp"): true, path("src/removed.cpp"): true, path("README.md"): true}
+	got, err := withHeaders(target, changed, []string{"-I" + path("src"), "-I" + path("vendor")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]bool{
+		path("src/greeting.cpp"): true, path("src/greeting.hpp"): true, path("src/util/text.hpp"): true,
+		path("src/removed.cpp"): true, path("README.md"): true,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("withHeaders() =\n%v\nwant\n%v", got, want)
+	}
+}
+
+func TestChangedFiles(t *testing.T) {
+	root, err := ioutil.TempDir("", "cm-impact")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	root, _ = filepath.EvalSymlinks(root)
+	write := func(name, content string) {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(".gitignore", "bin/\n")
+	write("project/src/a.cpp", "int a();\n")
+	write("project/src/b.cpp", "int b();\n")
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"add", "-A"},
+		{"-c", "user.name=cm", "-c", "user.email=cm@localhost", "commit", "-q", "-m", "initial"},
+	} {
+		if _, err := git(root, args...); err != nil {
+			t.Skipf("git is not usable here: %v", err)
+		}
+	}
+	write("project/src/a.cpp", "int a(int);\n")
+	write("project/tests/new_test.cpp", "\n")
+	write("project/bin/project", "\n")
+
+	got, err := changedFiles(filepath.Join(root, "project"), "HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]bool{
+		filepath.Join(root, "project/src/a.cpp"):          true,
+		filepath.Join(root, "project/tests/new_test.cpp"): true,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("changedFiles() = %v, want %v", got, want)
+	}
+	if _, err := changedFiles(root, "no-such-rev"); err == nil {
+		t.Error("changedFiles() against an unknown revision did not fail")
+	}
+}
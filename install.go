@@ -0,0 +1,281 @@
+package main
+
+import (
+	"debug/elf"
+	"debug/macho"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/damienstanton/cm/pkg/cm"
+)
+
+// installFlags are the options accepted by `cm install`, on top of the global flags
+var (
+	installFlags  = flag.NewFlagSet("install", flag.ExitOnError)
+	installPrefix = installFlags.String("prefix", "/usr/local", "dir to install into; $DESTDIR is prepended for staged installs")
+)
+
+// runInstall builds the project and installs its binaries, libraries, headers and pkg-config file into $DESTDIR/-prefix
+func runInstall(target string) {
+	if err := install(target); err != nil {
+		log.Fatalf("install error: %+v", err)
+	}
+	log.Printf("🎉 installed %s", *name)
+}
+
+// install stages and installs the project, removing the staging dir whether or not that succeeds
+func install(target string) error {
+	stage, err := stageBuild(target)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(stage)
+	root := filepath.Join(os.Getenv("DESTDIR"), *installPrefix)
+	log.Printf("installing %s into %s...", *name, root)
+	return installTree(target, stage, root, strings.TrimRight(*installPrefix, "/"))
+}
+
+// stageBuild builds the project into a new temporary dir with an install rpath, leaving the project's bin/ alone
+func stageBuild(target string) (string, error) {
+	stage, err := ioutil.TempDir("", "cm-stage")
+	if err != nil {
+		return "", fmt.Errorf("could not create a staging dir: %w", err)
+	}
+	outputDir = stage
+	installRPath = "$ORIGIN/../lib"
+	if runtime.GOOS == "darwin" {
+		installRPath = "@loader_path/../lib"
+	}
+	if config.Type == "header-only" {
+		return stage, nil
+	}
+	log.Printf("compiling project...\n")
+	if _, err := project(target).Build(buildOptions()); err != nil {
+		os.RemoveAll(stage)
+		return "", err
+	}
+	return stage, nil
+}
+
+// installTree copies the staged build and the headers into root, laid out like a prefix, with a .pc file for prefix
+func installTree(target, stage, root, prefix string) error {
+	binaries := make([]string, 0)
+	if config.Type == "" || config.Type == "app" {
+		binaries = append(binaries, filepath.Join(stage, *name))
+	}
+	for _, dir := range cm.CommandDirs(target) {
+		binaries = append(binaries, filepath.Join(stage, filepath.Base(dir)))
+	}
+	for _, b := range binaries {
+		if err := installFile(b, filepath.Join(root, "bin", filepath.Base(b)), 0755); err != nil {
+			return err
+		}
+	}
+
+	if config.Type == "lib" {
+		built := filepath.Join(stage, "lib"+*name+".so")
+		binaries = append(binaries, built)
+		if err := installLibrary(built, filepath.Join(root, "lib")); err != nil {
+			return err
+		}
+	}
+	available, err := bundledLibs(target)
+	if err != nil {
+		return err
+	}
+	libs, err := neededLibs(binaries, available)
+	if err != nil {
+		return err
+	}
+	for _, l := range libs {
+		if err := installFile(l, filepath.Join(root, "lib", filepath.Base(l)), 0755); err != nil {
+			return err
+		}
+	}
+
+	if config.Type == "lib" || config.Type == "header-only" {
+		if err := installHeaders(target, filepath.Join(root, "include", *name)); err != nil {
+			return err
+		}
+		pc := filepath.Join(root, "lib", "pkgconfig", *name+".pc")
+		if err := os.MkdirAll(filepath.Dir(pc), 0777); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(pc, []byte(pkgConfig(prefix)), 0644); err != nil {
+			return err
+		}
+		log.Printf("installed %s", pc)
+	}
+	return nil
+}
+
+// bundledLibs returns the shared libraries the binaries may load: those in lib/ and those its dependencies built
+func bundledLibs(target string) ([]string, error) {
+	libs, _ := filepath.Glob(filepath.Join(target, "lib", "*.so"))
+	l, err := cm.ReadLock(target)
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range l.Dependencies {
+		built, _ := filepath.Glob(filepath.Join(target, cm.VendorDir, d.Name, "bin", "lib*.so*"))
+		libs = append(libs, built...)
+	}
+	return libs, nil
+}
+
+// neededLibs returns the libraries out of available the binaries load, directly or not, with both ends of symlinks
+func neededLibs(binaries, available []string) ([]string, error) {
+	byName := make(map[string]string)
+	for _, a := range available {
+		byName[filepath.Base(a)] = a
+	}
+	needed := make([]string, 0)
+	seen := make(map[string]bool)
+	queue := append([]string{}, binaries...)
+	for len(queue) > 0 {
+		imports, err := importedLibraries(queue[0])
+		if err != nil {
+			return nil, fmt.Errorf("could not read the libraries %s needs: %w", queue[0], err)
+		}
+		queue = queue[1:]
+		for _, imp := range imports {
+			path, ok := byName[filepath.Base(imp)]
+			if !ok || seen[path] {
+				continue
+			}
+			seen[path] = true
+			needed = append(needed, path)
+			if link, err := os.Readlink(path); err == nil {
+				dest := filepath.Join(filepath.Dir(path), link)
+				if !seen[dest] {
+					seen[dest] = true
+					needed = append(needed, dest)
+				}
+			}
+			queue = append(queue, path)
+		}
+	}
+	return needed, nil
+}
+
+// importedLibraries returns the shared libraries a binary is linked against, as recorded in the binary
+func importedLibraries(path string) ([]string, error) {
+	if runtime.GOOS == "darwin" {
+		f, err := macho.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return f.ImportedLibraries()
+	}
+	f, err := elf.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return f.ImportedLibraries()
+}
+
+// installLibrary installs the library as lib<name>.so.<version> with its soname and development symlinks
+func installLibrary(built, libDir string) error {
+	lib := "lib" + *name + ".so"
+	if config.Version == "" || runtime.GOOS == "darwin" {
+		return installFile(built, filepath.Join(libDir, lib), 0755)
+	}
+	real := lib + "." + config.Version
+	if err := installFile(built, filepath.Join(libDir, real), 0755); err != nil {
+		return err
+	}
+	links := map[string]string{libSoname(): real, lib: libSoname()}
+	for link, dest := range links {
+		if link == dest {
+			continue
+		}
+		path := filepath.Join(libDir, link)
+		os.Remove(path)
+		if err := os.Symlink(dest, path); err != nil {
+			return err
+		}
+		log.Printf("installed %s -> %s", path, dest)
+	}
+	return nil
+}
+
+// installHeaders copies the public headers, in include/ or else src/, into dest, keeping their relative paths
+func installHeaders(target, dest string) error {
+	src := filepath.Join(target, "include")
+	if !isDir(src) {
+		src = filepath.Join(target, "src")
+	}
+	headers := make([]string, 0)
+	for _, ext := range cm.HeaderExts {
+		found, err := cm.Find(src, "*"+ext)
+		if err != nil {
+			return err
+		}
+		headers = append(headers, found...)
+	}
+	for _, h := range headers {
+		rel, err := filepath.Rel(src, h)
+		if err != nil {
+			return err
+		}
+		if err := installFile(h, filepath.Join(dest, rel), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// installFile copies src to dest with the given mode, creating dest's dir. Symlinks are copied as symlinks.
+func installFile(src, dest string, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(dest), 0777); err != nil {
+		return err
+	}
+	// remove first, so installing over a library that is in use does not change the running copy
+	os.Remove(dest)
+	if link, err := os.Readlink(src); err == nil {
+		if err := os.Symlink(link, dest); err != nil {
+			return err
+		}
+		log.Printf("installed %s -> %s", dest, link)
+		return nil
+	}
+	data, err := ioutil.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(dest, data, mode); err != nil {
+		return err
+	}
+	log.Printf("installed %s", dest)
+	return nil
+}
+
+// pkgConfig returns the pkg-config file of the library installed in prefix, which excludes $DESTDIR
+func pkgConfig(prefix string) string {
+	version := config.Version
+	if version == "" {
+		version = "0.0.0"
+	}
+	libs := ""
+	if config.Type == "lib" {
+		libs = "Libs: -L${libdir} -l" + *name + "\n"
+	}
+	return fmt.Sprintf(`prefix=%s
+exec_prefix=${prefix}
+libdir=${exec_prefix}/lib
+includedir=${prefix}/include
+
+Name: %s
+Description: %s, built with cm
+Version: %s
+Cflags: -I${includedir}/%s
+%s`, prefix, *name, *name, version, *name, libs)
+}
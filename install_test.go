@@ -0,0 +1,194 @@
+package main
+
+import (
+	"debug/elf"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/damienstanton/cm/pkg/cm"
+)
+
+// installTo runs cm install for the project in target with the given name and config, into DESTDIR dest and -prefix
+// /opt/<name>, returning the dir the files end up in
+func installTo(t *testing.T, target, dest, project string, c cm.Config) string {
+	defer func(n string, c cm.Config, p, s string) {
+		*name, config, *installPrefix, *std, outputDir, installRPath = n, c, p, s, "", ""
+	}(*name, config, *installPrefix, *std)
+	defer os.Setenv("DESTDIR", os.Getenv("DESTDIR"))
+	*name, config, *installPrefix, *std = project, c, "/opt/"+project, "c++17"
+	os.Setenv("DESTDIR", dest)
+	runInstall(target)
+	return filepath.Join(dest, "opt", project)
+}
+
+// runPath returns the DT_RUNPATH (or DT_RPATH) of an ELF binary
+func runPath(t *testing.T, path string) string {
+	f, err := elf.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	for _, tag := range []elf.DynTag{elf.DT_RUNPATH, elf.DT_RPATH} {
+		if values, err := f.DynString(tag); err == nil && len(values) > 0 {
+			return strings.Join(values, ":")
+		}
+	}
+	return ""
+}
+
+func TestInstallApp(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("checks ELF rpaths")
+	}
+	useCompiler(t)
+	target := tempProject(t)
+	writeFiles(t, target, map[string]string{
+		"src/main.cpp":  "#include \"greet.hpp\"\nint main() { return greet() == 42 ? 0 : 1; }\n",
+		"src/greet.hpp": "int greet();\n",
+		"greet.cpp":     "int greet() { return 42; }\n",
+		"lib/.gitkeep":  "",
+		"bin/.gitkeep":  "",
+	})
+	lib := filepath.Join(target, "lib", "libgreet.so")
+	if err := buildOptions().CompileFile(filepath.Join(target, "greet.cpp"), lib, "-shared", "-fPIC"); err != nil {
+		t.Fatal(err)
+	}
+
+	dest := tempProject(t)
+	root := installTo(t, target, dest, "app", cm.Config{Type: "app"})
+	binary := filepath.Join(root, "bin", "app")
+	if info, err := os.Stat(binary); err != nil || info.Mode().Perm() != 0755 {
+		t.Fatalf("installed binary: %v, %v", info, err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "lib", "libgreet.so")); err != nil {
+		t.Errorf("the library the binary links was not installed: %v", err)
+	}
+	if rpath := runPath(t, binary); rpath != "$ORIGIN/../lib" {
+		t.Errorf("rpath of the installed binary = %q, want $ORIGIN/../lib", rpath)
+	}
+	// the installed binary finds its library even with the project gone
+	os.RemoveAll(target)
+	if out, err := exec.Command(binary).CombinedOutput(); err != nil {
+		t.Errorf("installed binary failed: %v\n%s", err, out)
+	}
+	if _, err := os.Stat(filepath.Join(root, "lib", "pkgconfig")); !os.IsNotExist(err) {
+		t.Errorf("an app got a pkg-config file")
+	}
+}
+
+func TestInstallLibrary(t *testing.T) {
+	useCompiler(t)
+	target := tempProject(t)
+	writeFiles(t, target, map[string]string{
+		"src/greet.hpp":         "#pragma once\n#include \"detail/impl.hpp\"\nint greet();\n",
+		"src/detail/impl.hpp":   "#pragma once\n",
+		"src/greet.cpp":         "#include \"greet.hpp\"\nint greet() { return 42; }\n",
+		"tests/greet_test.cpp":  "\n",
+		"bin/.gitkeep":          "",
+		"lib/.gitkeep":          "",
+		"src/not_a_header.txt":  "",
+		"tests/not_public.hpp":  "",
+		"README.md":             "",
+		"src/detail/impl.cpp":   "\n",
+		"src/detail/config.hxx": "",
+	})
+	dest := tempProject(t)
+	root := installTo(t, target, dest, "greet", cm.Config{Type: "lib", Version: "1.2.3"})
+
+	files := map[string]string{}
+	filepath.Walk(dest, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, _ := filepath.Rel(root, path)
+		files[rel] = ""
+		if link, err := os.Readlink(path); err == nil {
+			files[rel] = "-> " + link
+		}
+		return nil
+	})
+	want := map[string]string{
+		"include/greet/greet.hpp":         "",
+		"include/greet/detail/impl.hpp":   "",
+		"include/greet/detail/config.hxx": "",
+		"lib/libgreet.so.1.2.3":           "",
+		"lib/libgreet.so.1":               "-> libgreet.so.1.2.3",
+		"lib/libgreet.so":                 "-> libgreet.so.1",
+		"lib/pkgconfig/greet.pc":          "",
+	}
+	if runtime.GOOS == "darwin" {
+		delete(want, "lib/libgreet.so.1")
+		delete(want, "lib/libgreet.so.1.2.3")
+		want["lib/libgreet.so"] = ""
+	}
+	for f, w := range want {
+		if got, ok := files[f]; !ok || got != w {
+			t.Errorf("%s is %q (installed: %v), want %q", f, got, ok, w)
+		}
+		delete(files, f)
+	}
+	for f := range files {
+		t.Errorf("unexpected file %s was installed", f)
+	}
+
+	pc, err := ioutil.ReadFile(filepath.Join(root, "lib", "pkgconfig", "greet.pc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantPC := `prefix=/opt/greet
+exec_prefix=${prefix}
+libdir=${exec_prefix}/lib
+includedir=${prefix}/include
+
+Name: greet
+Description: greet, built with cm
+Version: 1.2.3
+Cflags: -I${includedir}/greet
+Libs: -L${libdir} -lgreet
+`
+	if string(pc) != wantPC {
+		t.Errorf("greet.pc =\n%s\nwant\n%s", pc, wantPC)
+	}
+}
+
+func TestInstallFailedBuild(t *testing.T) {
+	useCompiler(t)
+	defer func(n string, c cm.Config, p, s string) {
+		*name, config, *installPrefix, *std, outputDir, installRPath = n, c, p, s, "", ""
+	}(*name, config, *installPrefix, *std)
+	defer os.Setenv("DESTDIR", os.Getenv("DESTDIR"))
+	defer os.Setenv("TMPDIR", os.Getenv("TMPDIR"))
+	target, tmp, dest := tempProject(t), tempProject(t), tempProject(t)
+	writeFiles(t, target, map[string]string{"src/main.cpp": "int main() { return missing(); }\n"})
+	*name, config, *installPrefix, *std = "broken", cm.Config{}, "/opt/broken", "c++17"
+	os.Setenv("DESTDIR", dest)
+	os.Setenv("TMPDIR", tmp)
+	if err := install(target); err == nil {
+		t.Fatal("install() of a project that does not compile did not fail")
+	}
+	if left, err := ioutil.ReadDir(tmp); err != nil || len(left) != 0 {
+		t.Errorf("install() left %d files in the temp dir (%v), want the staging dir removed", len(left), err)
+	}
+	if _, err := os.Stat(filepath.Join(dest, "opt")); !os.IsNotExist(err) {
+		t.Errorf("install() of a failed build installed into %s: %v", dest, err)
+	}
+}
+
+func TestPkgConfigHeaderOnly(t *testing.T) {
+	defer func(n string, c cm.Config) { *name, config = n, c }(*name, config)
+	*name, config = "json", cm.Config{Type: "header-only"}
+	got := pkgConfig("/usr/local")
+	for _, want := range []string{"prefix=/usr/local\n", "Version: 0.0.0\n", "Cflags: -I${includedir}/json\n"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("pkgConfig() does not contain %q:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "Libs:") {
+		t.Errorf("pkgConfig() of a header-only library has Libs:\n%s", got)
+	}
+}
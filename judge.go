@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// judgeFlags are the options accepted by `cm judge`, on top of the global flags
+var (
+	judgeFlags    = flag.NewFlagSet("judge", flag.ExitOnError)
+	judgeDir      = judgeFlags.String("testdata", "testdata", "dir with the .in/.out case files")
+	judgeCmp      = judgeFlags.String("cmp", "ws", "output comparator: exact, ws (whitespace-insensitive) or float")
+	judgeEps      = judgeFlags.Float64("eps", 1e-6, "absolute or relative error tolerated by the float comparator")
+	judgeTime     = judgeFlags.Duration("timelimit", 2*time.Second, "CPU and wall clock time limit per case")
+	judgeMemLimit = judgeFlags.Int("memlimit", 256, "address space limit per case in MiB")
+)
+
+// judge verdicts, as reported by online judges
+const (
+	verdictAC  = "AC"  // accepted
+	verdictWA  = "WA"  // wrong answer
+	verdictTLE = "TLE" // time limit exceeded
+	verdictMLE = "MLE" // memory limit exceeded
+	verdictRE  = "RE"  // runtime error
+	verdictNA  = "--"  // no expected output to compare against
+)
+
+// judgeCase is the outcome of running the program on one .in file
+type judgeCase struct {
+	name     string
+	verdict  string
+	duration time.Duration
+	memory   int64 // peak resident set size in bytes
+	detail   string
+}
+
+// runJudge builds the project and runs it against every case in the testdata dir, printing a verdict table
+func runJudge(target string) {
+	cmp, err := comparator(*judgeCmp, *judgeEps)
+	if err != nil {
+		log.Fatalf("%+v", err)
+	}
+	dir := *judgeDir
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(target, dir)
+	}
+	inputs, err := filepath.Glob(filepath.Join(dir, "*.in"))
+	if err != nil {
+		log.Fatalf("could not find cases: %+v", err)
+	}
+	if len(inputs) == 0 {
+		log.Fatalf("no .in files found in %s", dir)
+	}
+	sort.Strings(inputs)
+
+	log.Printf("compiling project...\n")
+	compile(target)
+	binary := target + "/bin/" + *name
+	log.Printf("judging %s against %d cases in %s...", *name, len(inputs), dir)
+
+	cases := make([]judgeCase, 0, len(inputs))
+	for _, in := range inputs {
+		cases = append(cases, judgeOne(binary, in, cmp))
+	}
+	if !printVerdicts(cases) {
+		stopTrace()
+		os.Exit(1)
+	}
+}
+
+// judgeOne runs the binary with the given .in file as stdin and judges its output against the matching .out file
+func judgeOne(binary, in string, cmp func(want, got string) bool) judgeCase {
+	c := judgeCase{name: strings.TrimSuffix(filepath.Base(in), ".in")}
+	input, err := os.Open(in)
+	if err != nil {
+		c.verdict, c.detail = verdictRE, err.Error()
+		return c
+	}
+	defer input.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *judgeTime)
+	defer cancel()
+	lim := limits{cpu: *judgeTime, mem: *judgeMemLimit, files: *fileLimit}
+	cmd, args := lim.command(binary, nil)
+	command := exec.CommandContext(ctx, cmd, args...)
+	var stdout, stderr bytes.Buffer
+	command.Stdin = input
+	command.Stdout = &stdout
+	command.Stderr = &stderr
+	start := time.Now()
+	err = command.Run()
+	c.duration = time.Since(start)
+	c.memory = peakMemory(command.ProcessState)
+
+	var exitErr *exec.ExitError
+	limit := lim.hit(command.ProcessState)
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		c.verdict = verdictTLE
+		return c
+	case strings.HasPrefix(limit, "CPU"):
+		c.verdict, c.detail = verdictTLE, "stopped by the "+limit+"\n"
+		return c
+	case strings.HasPrefix(limit, "memory"):
+		c.verdict = verdictMLE
+		return c
+	case errors.As(err, &exitErr):
+		c.verdict, c.detail = verdictRE, fmt.Sprintf("%v\n%s", err, stderr.String())
+		return c
+	case err != nil:
+		c.verdict, c.detail = verdictRE, err.Error()
+		return c
+	}
+
+	want, err := ioutil.ReadFile(strings.TrimSuffix(in, ".in") + ".out")
+	if os.IsNotExist(err) {
+		c.verdict, c.detail = verdictNA, stdout.String()
+		return c
+	}
+	if err != nil {
+		c.verdict, c.detail = verdictRE, err.Error()
+		return c
+	}
+	c.verdict = verdictAC
+	if !cmp(string(want), stdout.String()) {
+		c.verdict, c.detail = verdictWA, diffLines(string(want), stdout.String())
+	}
+	return c
+}
+
+// peakMemory returns the peak resident set size of an exited process in bytes, or 0 if it is unknown
+func peakMemory(state *os.ProcessState) int64 {
+	if state == nil {
+		return 0
+	}
+	usage, ok := state.SysUsage().(*syscall.Rusage)
+	if !ok {
+		return 0
+	}
+	// linux reports ru_maxrss in KiB, macos in bytes
+	if runtime.GOOS == "darwin" {
+		return int64(usage.Maxrss)
+	}
+	return int64(usage.Maxrss) << 10
+}
+
+// printVerdicts prints the verdict table followed by the details of every case that was not accepted. It returns true
+// if no case failed.
+func printVerdicts(cases []judgeCase) bool {
+	fmt.Println("")
+	fmt.Printf("%-24s %-8s %10s %10s\n", "case", "verdict", "time", "memory")
+	fmt.Println(strings.Repeat("═", 55))
+	accepted := 0
+	for _, c := range cases {
+		fmt.Printf("%-24s %-8s %9.3fs %8.1fMB\n", c.name, c.verdict, c.duration.Seconds(), float64(c.memory)/(1<<20))
+		if c.verdict == verdictAC || c.verdict == verdictNA {
+			accepted++
+		}
+	}
+	for _, c := range cases {
+		switch {
+		case c.verdict == verdictAC || c.detail == "":
+		case c.verdict == verdictNA:
+			fmt.Printf("\n%s has no .out file, the output was:\n%s", c.name, c.detail)
+		default:
+			fmt.Printf("\n%s %s:\n%s", c.verdict, c.name, c.detail)
+		}
+	}
+	fmt.Println("")
+	log.Printf("%d/%d cases accepted", accepted, len(cases))
+	return accepted == len(cases)
+}
+
+// comparator returns the output comparison function with the given name; eps is only used by the float comparator
+func comparator(name string, eps float64) (func(want, got string) bool, error) {
+	switch name {
+	case "exact":
+		return func(want, got string) bool { return want == got }, nil
+	case "ws":
+		return func(want, got string) bool {
+			return strings.Join(strings.Fields(want), " ") == strings.Join(strings.Fields(got), " ")
+		}, nil
+	case "float":
+		return func(want, got string) bool { return floatEqual(want, got, eps) }, nil
+	default:
+		return nil, fmt.Errorf("unknown comparator %q (want exact, ws or float)", name)
+	}
+}
+
+// floatEqual compares whitespace separated tokens, allowing numeric tokens to differ by eps, either absolutely or
+// relative to the expected value
+func floatEqual(want, got string, eps float64) bool {
+	w, g := strings.Fields(want), strings.Fields(got)
+	if len(w) != len(g) {
+		return false
+	}
+	for i := range w {
+		if w[i] == g[i] {
+			continue
+		}
+		x, errX := strconv.ParseFloat(w[i], 64)
+		y, errY := strconv.ParseFloat(g[i], 64)
+		if errX != nil || errY != nil {
+			return false
+		}
+		// no tolerance makes up for an infinity or a NaN, which are only equal to their own kind
+		if math.IsNaN(x) || math.IsNaN(y) || math.IsInf(x, 0) || math.IsInf(y, 0) {
+			if x != y && !(math.IsNaN(x) && math.IsNaN(y)) {
+				return false
+			}
+			continue
+		}
+		diff := math.Abs(x - y)
+		if diff > eps && diff > eps*math.Abs(x) {
+			return false
+		}
+	}
+	return true
+}
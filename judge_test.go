@@ -0,0 +1,143 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFloatEqual(t *testing.T) {
+	tests := []struct {
+		want, got string
+		eps       float64
+		equal     bool
+	}{
+		{"3.14159", "3.14159", 1e-6, true},
+		{"3.141592", "3.141593", 1e-6, true},
+		{"3.1415", "3.1416", 1e-6, false},
+		{"3.1415", "3.1416", 1e-3, true},
+		{"0", "0.0000001", 1e-6, true},
+		{"1e9", "1000000500", 1e-6, true},
+		{"1e9", "1000005000", 1e-6, false},
+		{"1 2.5\n3", "1.0000001  2.5000001 3", 1e-6, true},
+		{"1 2", "1 2 3", 1e-6, false},
+		{"yes 1.5", "yes 1.5000001", 1e-6, true},
+		{"yes 1.5", "no 1.5", 1e-6, false},
+		{"1.5", "abc", 1e-6, false},
+		{"inf", "inf", 1e-6, true},
+		{"inf", "1e308", 1e-6, false},
+		{"-inf", "inf", 1e-6, false},
+		{"nan", "NaN", 1e-6, true},
+		{"nan", "1", 1e-6, false},
+		{"1", "nan", 1e-6, false},
+		{"", "", 1e-6, true},
+	}
+	for _, tt := range tests {
+		if got := floatEqual(tt.want, tt.got, tt.eps); got != tt.equal {
+			t.Errorf("floatEqual(%q, %q, %g) = %v, want %v", tt.want, tt.got, tt.eps, got, tt.equal)
+		}
+	}
+}
+
+func TestComparator(t *testing.T) {
+	tests := []struct {
+		name      string
+		want, got string
+		equal     bool
+	}{
+		{"exact", "1 2\n", "1 2\n", true},
+		{"exact", "1 2\n", "1  2\n", false},
+		{"exact", "1 2\n", "1 2", false},
+		{"ws", "1 2\n", "1  2", true},
+		{"ws", "1\n2\n", " 1 2 \n\n", true},
+		{"ws", "1 2\n", "1 3\n", false},
+		{"float", "0.5\n", "0.5000000001\n", true},
+		{"float", "0.5\n", "0.6\n", false},
+	}
+	for _, tt := range tests {
+		cmp, err := comparator(tt.name, 1e-6)
+		if err != nil {
+			t.Fatalf("comparator(%q): %v", tt.name, err)
+		}
+		if got := cmp(tt.want, tt.got); got != tt.equal {
+			t.Errorf("%s comparator(%q, %q) = %v, want %v", tt.name, tt.want, tt.got, got, tt.equal)
+		}
+	}
+	if _, err := comparator("fuzzy", 1e-6); err == nil {
+		t.Error("comparator(\"fuzzy\") did not fail")
+	}
+}
+
+func TestJudgeOne(t *testing.T) {
+	defer func(limit time.Duration) { *judgeTime = limit }(*judgeTime)
+	*judgeTime = 500 * time.Millisecond
+	dir, err := ioutil.TempDir("", "cm-judge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// the program doubles the number it reads, and misbehaves on request
+	program := `#!/bin/sh
+read n
+case "$n" in
+loop) exec sleep 5 ;;
+fail) echo "bad input" >&2; exit 3 ;;
+alloc) echo "std::bad_alloc" >&2; kill -ABRT $$ ;;
+esac
+echo $((n * 2))
+`
+	binary := filepath.Join(dir, "program")
+	files := map[string]string{
+		"program":   program,
+		"ac.in":     "21\n",
+		"ac.out":    "42\n",
+		"wa.in":     "2\n",
+		"wa.out":    "5\n",
+		"tle.in":    "loop\n",
+		"tle.out":   "\n",
+		"re.in":     "fail\n",
+		"re.out":    "\n",
+		"mle.in":    "alloc\n",
+		"mle.out":   "\n",
+		"nodata.in": "4\n",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cmp, _ := comparator("ws", 0)
+	tests := []struct {
+		name, verdict, detail string
+	}{
+		{"ac", verdictAC, ""},
+		{"wa", verdictWA, "-5\n+4\n"},
+		{"tle", verdictTLE, ""},
+		{"re", verdictRE, "exit status 3\nbad input\n"},
+		{"mle", verdictMLE, ""},
+		{"nodata", verdictNA, "8\n"},
+		{"missing", verdictRE, "no such file or directory"},
+	}
+	for _, tt := range tests {
+		c := judgeOne(binary, filepath.Join(dir, tt.name+".in"), cmp)
+		if c.name != tt.name || c.verdict != tt.verdict || !strings.Contains(c.detail, tt.detail) {
+			t.Errorf("judgeOne(%s.in) = %s %s %q, want %s %q", tt.name, c.name, c.verdict, c.detail, tt.verdict, tt.detail)
+		}
+	}
+}
+
+func TestJudgeMemoryLimit(t *testing.T) {
+	defer func(limit int) { *judgeMemLimit = limit }(*judgeMemLimit)
+	*judgeMemLimit = 64
+	binary := allocator(t)
+	dir := tempProject(t)
+	writeFiles(t, dir, map[string]string{"big.in": "\n", "big.out": "\n"})
+	cmp, _ := comparator("ws", 0)
+	if c := judgeOne(binary, filepath.Join(dir, "big.in"), cmp); c.verdict != verdictMLE {
+		t.Errorf("judgeOne() of a program that allocates past the limit = %s %q, want %s", c.verdict, c.detail,
+			verdictMLE)
+	}
+}
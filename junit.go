@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+	"time"
+)
+
+// junitCase is a <testcase> of a JUnit XML report. catch2 reports every leaf section of a test case as a testcase of
+// its own, named "test case/section".
+type junitCase struct {
+	Name     string         `xml:"name,attr"`
+	Time     float64        `xml:"time,attr"`
+	Failures []junitFailure `xml:"failure"`
+	Errors   []junitFailure `xml:"error"`
+	Stdout   string         `xml:"system-out"`
+	Stderr   string         `xml:"system-err"`
+}
+
+// junitFailure is a <failure> or <error> of a testcase
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// parseJUnit reads the test cases from a JUnit XML report in the output of a test binary, attributing every reported
+// testcase to the longest of the listed test case names it is or starts a section of. It returns false if the output
+// holds no report, as when the binary crashed before writing it.
+func parseJUnit(out []byte, names []string) ([]caseOutcome, bool) {
+	start := bytes.Index(out, []byte("<?xml"))
+	if start < 0 {
+		start = bytes.Index(out, []byte("<testsuite"))
+	}
+	if start < 0 {
+		return nil, false
+	}
+	dec := xml.NewDecoder(bytes.NewReader(out[start:]))
+	outcomes := make([]caseOutcome, 0)
+	index := make(map[string]int)
+	found := false
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			break
+		}
+		el, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if el.Name.Local == "testsuites" || el.Name.Local == "testsuite" {
+			found = true
+			continue
+		}
+		if el.Name.Local != "testcase" {
+			continue
+		}
+		var c junitCase
+		if err := dec.DecodeElement(&c, &el); err != nil {
+			break
+		}
+		name := caseName(c.Name, names)
+		i, ok := index[name]
+		if !ok {
+			i = len(outcomes)
+			index[name] = i
+			outcomes = append(outcomes, caseOutcome{name: name, attempts: []testResult{{name: name, status: statusPass}}})
+		}
+		res := &outcomes[i].attempts[0]
+		res.duration += time.Duration(c.Time * float64(time.Second))
+		for _, f := range append(c.Failures, c.Errors...) {
+			res.status = statusFail
+			res.output = append(res.output, strings.TrimSpace(f.Text)+"\n"...)
+		}
+		if res.status != statusPass {
+			for _, o := range []string{c.Stdout, c.Stderr} {
+				if o = strings.TrimSpace(o); o != "" {
+					res.output = append(res.output, o+"\n"...)
+				}
+			}
+		}
+	}
+	return outcomes, found
+}
+
+// caseName returns the longest of the test case names that the reported name is, or starts a section of, or the
+// reported name itself
+func caseName(reported string, names []string) string {
+	best := ""
+	for _, n := range names {
+		if (reported == n || strings.HasPrefix(reported, n+"/")) && len(n) > len(best) {
+			best = n
+		}
+	}
+	if best == "" {
+		return reported
+	}
+	return best
+}
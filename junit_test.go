@@ -0,0 +1,90 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestParseJUnit(t *testing.T) {
+	report := `<?xml version="1.0" encoding="UTF-8"?>
+<testsuites>
+  <testsuite name="ju" errors="0" failures="2" tests="4" hostname="tbd" time="0.001">
+    <testcase classname="ju.global" name="adds" time="0.25"/>
+    <testcase classname="ju.global" name="fails, with comma" time="0.5">
+      <failure message="add(1, 2) == 4" type="CHECK">
+FAILED:
+  CHECK( add(1, 2) == 4 )
+      </failure>
+      <system-out>
+some output
+      </system-out>
+    </testcase>
+    <testcase classname="ju.global" name="sections/slash/one" time="0.125"/>
+    <testcase classname="ju.global" name="sections/slash/two" time="0.125">
+      <failure message="1 == 2" type="REQUIRE">REQUIRE( 1 == 2 )</failure>
+    </testcase>
+    <testcase classname="ju.global" name="unlisted" time="0"/>
+  </testsuite>
+</testsuites>
+`
+	tests := []struct {
+		name   string
+		out    string
+		names  []string
+		ok     bool
+		want   []string
+		output map[string]string
+	}{
+		{
+			name:  "report with sections",
+			out:   report,
+			names: []string{"adds", "fails, with comma", "sections", "sections/slash"},
+			ok:    true,
+			want:  []string{"adds PASS 0.25", "fails, with comma FAIL 0.5", "sections/slash FAIL 0.25", "unlisted PASS 0"},
+			output: map[string]string{
+				"adds":              "",
+				"fails, with comma": "FAILED:\n  CHECK( add(1, 2) == 4 )\nsome output\n",
+				"sections/slash":    "REQUIRE( 1 == 2 )\n",
+			},
+		},
+		{
+			name: "report after console output",
+			out:  "some output\n" + `<testsuites><testsuite name="x"><testcase name="adds" time="1"/></testsuite></testsuites>`,
+			ok:   true,
+			want: []string{"adds PASS 1"},
+		},
+		{
+			name: "no test cases",
+			out:  `<?xml version="1.0"?><testsuites><testsuite name="x" tests="0"/></testsuites>`,
+			ok:   true,
+			want: []string{},
+		},
+		{
+			name: "crash before the report",
+			out:  "some output\n",
+			ok:   false,
+			want: []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			outcomes, ok := parseJUnit([]byte(tt.out), tt.names)
+			if ok != tt.ok {
+				t.Fatalf("parseJUnit() ok = %v, want %v", ok, tt.ok)
+			}
+			got := make([]string, 0, len(outcomes))
+			for _, o := range outcomes {
+				res := o.attempts[0]
+				seconds := strconv.FormatFloat(res.duration.Seconds(), 'f', -1, 64)
+				got = append(got, o.name+" "+o.status()+" "+seconds)
+				if want, ok := tt.output[o.name]; ok && string(res.output) != want {
+					t.Errorf("output of %q = %q, want %q", o.name, res.output, want)
+				}
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("parseJUnit() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// resource limit flags, applied to the programs cm runs (never to the compiler)
+var (
+	timeLimit = flag.Duration("timelimit", 0, "CPU time limit for programs cm runs with -run, -i or in tests, e.g. 2s (0 means none)")
+	memLimit  = flag.Int("memlimit", 0, "address space limit in MiB for programs cm runs (0 means none)")
+	fileLimit = flag.Int("filelimit", 0, "open file limit for programs cm runs (0 means inherit)")
+)
+
+// limits are the rlimits a program is started with. Core dumps are disabled whenever any limit is set.
+type limits struct {
+	cpu   time.Duration
+	mem   int // MiB
+	files int
+}
+
+// flagLimits returns the limits given with -timelimit, -memlimit and -filelimit
+func flagLimits() limits {
+	return limits{cpu: *timeLimit, mem: *memLimit, files: *fileLimit}
+}
+
+// active reports whether any limit is set
+func (l limits) active() bool {
+	return l.cpu > 0 || l.mem > 0 || l.files > 0
+}
+
+// command returns the command and args that run cmd with the limits applied. The limits are set with the shell's ulimit
+// builtin, which then execs the program, so they are in place before the program's first instruction and the process
+// cm waits for is the program itself.
+func (l limits) command(cmd string, args []string) (string, []string) {
+	if !l.active() {
+		return cmd, args
+	}
+	script := []string{"ulimit -c 0"}
+	if l.cpu > 0 {
+		// the soft limit sends SIGXCPU, which tells a CPU limit apart from other kills; the hard limit is a backstop
+		secs := int(math.Ceil(l.cpu.Seconds()))
+		script = append(script, fmt.Sprintf("ulimit -S -t %d", secs), fmt.Sprintf("ulimit -H -t %d", secs+1))
+	}
+	if l.mem > 0 {
+		script = append(script, fmt.Sprintf("ulimit -v %d", l.mem<<10))
+	}
+	if l.files > 0 {
+		script = append(script, fmt.Sprintf("ulimit -n %d", l.files))
+	}
+	script = append(script, `exec "$0" "$@"`)
+	return "/bin/sh", append([]string{"-c", strings.Join(script, " && "), cmd}, args...)
+}
+
+// hit names the limit a program that exited with the given state most likely ran into, or returns "" if it does not
+// look like it hit any. The kernel does not say why an allocation failed, so a memory limit is inferred from the
+// program aborting or crashing while one was set.
+func (l limits) hit(state *os.ProcessState) string {
+	if state == nil || state.Success() {
+		return ""
+	}
+	ws, ok := state.Sys().(syscall.WaitStatus)
+	if !ok || !ws.Signaled() {
+		return ""
+	}
+	cpu := state.UserTime() + state.SystemTime()
+	switch sig := ws.Signal(); {
+	case sig == syscall.SIGXCPU:
+		return fmt.Sprintf("CPU time limit (%v)", l.cpu)
+	case sig == syscall.SIGKILL && l.cpu > 0 && cpu >= l.cpu:
+		return fmt.Sprintf("CPU time limit (%v)", l.cpu)
+	case l.mem > 0 && (sig == syscall.SIGABRT || sig == syscall.SIGSEGV || sig == syscall.SIGBUS):
+		return fmt.Sprintf("memory limit (%d MiB)", l.mem)
+	}
+	return ""
+}
+
+// reportLimit logs the limit a program that failed with err most likely hit, if any
+func reportLimit(l limits, err error) {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if limit := l.hit(exitErr.ProcessState); limit != "" {
+			log.Printf("the program was stopped by the %s", limit)
+		}
+	}
+}
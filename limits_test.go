@@ -0,0 +1,110 @@
+package main
+
+import (
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLimitsCommand(t *testing.T) {
+	tests := []struct {
+		lim  limits
+		want []string
+	}{
+		{limits{}, []string{"./prog", "arg"}},
+		{
+			limits{cpu: 1500 * time.Millisecond},
+			[]string{"/bin/sh", "-c", `ulimit -c 0 && ulimit -S -t 2 && ulimit -H -t 3 && exec "$0" "$@"`, "./prog", "arg"},
+		},
+		{
+			limits{mem: 256, files: 64},
+			[]string{"/bin/sh", "-c", `ulimit -c 0 && ulimit -v 262144 && ulimit -n 64 && exec "$0" "$@"`, "./prog", "arg"},
+		},
+	}
+	for _, tt := range tests {
+		cmd, args := tt.lim.command("./prog", []string{"arg"})
+		if got := append([]string{cmd}, args...); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("command() with %+v = %q, want %q", tt.lim, got, tt.want)
+		}
+	}
+}
+
+func TestLimitsHit(t *testing.T) {
+	tests := []struct {
+		name   string
+		lim    limits
+		script string
+		want   string
+	}{
+		{"success", limits{mem: 256}, "exit 0", ""},
+		{"exit code", limits{mem: 256}, "exit 3", ""},
+		{"abort under memory limit", limits{mem: 256}, "kill -ABRT $$", "memory limit (256 MiB)"},
+		{"segfault under memory limit", limits{mem: 256}, "kill -SEGV $$", "memory limit (256 MiB)"},
+		{"segfault without limits", limits{}, "kill -SEGV $$", ""},
+		{"segfault under CPU limit", limits{cpu: time.Second}, "kill -SEGV $$", ""},
+		{"cpu limit signal", limits{cpu: time.Second}, "kill -XCPU $$", "CPU time limit (1s)"},
+		{"kill before the cpu limit", limits{cpu: time.Minute}, "kill -KILL $$", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			command := exec.Command("/bin/sh", "-c", tt.script)
+			command.Run()
+			if got := tt.lim.hit(command.ProcessState); got != tt.want {
+				t.Errorf("hit() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCPULimit(t *testing.T) {
+	lim := limits{cpu: time.Second}
+	cmd, args := lim.command("/bin/sh", []string{"-c", "while :; do :; done"})
+	command := exec.Command(cmd, args...)
+	done := make(chan error, 1)
+	go func() { done <- command.Run() }()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		command.Process.Kill()
+		t.Fatal("the program was not stopped by its CPU time limit")
+	}
+	if got := lim.hit(command.ProcessState); !strings.HasPrefix(got, "CPU time limit") {
+		t.Errorf("hit() = %q, want the CPU time limit", got)
+	}
+}
+
+// allocator builds a program that allocates memory until an allocation fails, returning its path
+func allocator(t *testing.T) string {
+	useCompiler(t)
+	dir := tempProject(t)
+	writeFiles(t, dir, map[string]string{"alloc.cpp": `#include <vector>
+int main() {
+    std::vector<char*> blocks;
+    for (;;) {
+        char* b = new char[1 << 20];
+        b[0] = 1;
+        blocks.push_back(b);
+    }
+}
+`})
+	binary := filepath.Join(dir, "alloc")
+	if err := buildOptions().CompileFile(filepath.Join(dir, "alloc.cpp"), binary); err != nil {
+		t.Fatal(err)
+	}
+	return binary
+}
+
+func TestMemoryLimit(t *testing.T) {
+	lim := limits{mem: 64}
+	cmd, args := lim.command(allocator(t), nil)
+	command := exec.Command(cmd, args...)
+	if err := command.Run(); err == nil {
+		t.Fatal("the program was not stopped by its memory limit")
+	}
+	if got, want := lim.hit(command.ProcessState), "memory limit (64 MiB)"; got != want {
+		t.Errorf("hit() = %q, want %q", got, want)
+	}
+}
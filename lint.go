@@ -0,0 +1,311 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/damienstanton/cm/pkg/cm"
+)
+
+// lintFlags are the options accepted by `cm lint`, on top of the global flags
+var (
+	lintFlags = flag.NewFlagSet("lint", flag.ExitOnError)
+	lintTool  = lintFlags.String("tool", "clang-tidy", "analyzer to run: clang-tidy, clang-analyzer or gcc-analyzer")
+	lintJobs  = lintFlags.Int("j", runtime.NumCPU(), "number of translation units to analyze at a time")
+	lintFix   = lintFlags.Bool("fix", false, "apply the fixes clang-tidy suggests (analyzes one file at a time)")
+	lintSarif = lintFlags.String("sarif", "", "also write the findings to the given file as SARIF")
+)
+
+// finding is a single diagnostic reported by an analyzer
+type finding struct {
+	file    string
+	line    int
+	column  int
+	level   string
+	message string
+	rule    string
+}
+
+// diagnostic matches the file:line:col: level: message [rule] lines clang-tidy, clang and gcc all print
+var diagnostic = regexp.MustCompile(`(?m)^(.+?):(\d+):(\d+): (warning|error): (.*?)(?: \[([^ \]]+)\])?$`)
+
+// runLint analyzes every source file of the project with the chosen tool, using the flags compile uses, and prints the
+// deduplicated findings. It exits with a non-zero status if there are any.
+func runLint(target string) {
+	sources, err := cm.FindAll(target+"/src", cm.SourceGlobs)
+	if err != nil {
+		log.Fatalf("could not find source files: %+v", err)
+	}
+	for _, dir := range cm.CommandDirs(target) {
+		cmdSources, err := cm.FindAll(dir, cm.SourceGlobs)
+		if err != nil {
+			log.Fatalf("could not find source files: %+v", err)
+		}
+		sources = append(sources, cmdSources...)
+	}
+	flags, err := projectFlags(target)
+	if err != nil {
+		log.Fatalf("dependency error: %+v", err)
+	}
+	command, err := lintCommand(target, flags)
+	if err != nil {
+		log.Fatalf("%+v", err)
+	}
+
+	jobs := maxInt(*lintJobs, 1)
+	if *lintFix {
+		// translation units share headers, so fixing them concurrently would garble the headers
+		jobs = 1
+	}
+	log.Printf("running %s on %d file(s), %d at a time...", *lintTool, len(sources), jobs)
+	outputs, err := analyze(sources, jobs, command)
+	if err != nil {
+		log.Fatalf("%+v", err)
+	}
+
+	findings := parseFindings(target, outputs)
+	for _, f := range findings {
+		rule := ""
+		if f.rule != "" {
+			rule = " [" + f.rule + "]"
+		}
+		fmt.Printf("%s:%d:%d: %s: %s%s\n", f.file, f.line, f.column, f.level, f.message, rule)
+	}
+	if *lintSarif != "" {
+		if err := writeSarif(*lintSarif, findings); err != nil {
+			log.Fatalf("could not write SARIF: %+v", err)
+		}
+		log.Printf("wrote %s", *lintSarif)
+	}
+	if len(findings) > 0 {
+		log.Fatalf("%d finding(s)", len(findings))
+	}
+	log.Println("🎉 no findings")
+}
+
+// lintResult is the output of the lint tool for the source at index i, or the error that kept the tool from running
+type lintResult struct {
+	i   int
+	out []byte
+	err error
+}
+
+// analyze runs the lint command on every source, jobs at a time, and returns the outputs in the order of the sources.
+// It fails if the tool could not be run on any of them.
+func analyze(sources []string, jobs int, command func(source string) (string, []string)) ([][]byte, error) {
+	queue := make(chan int)
+	results := make(chan lintResult, len(sources))
+	var wg sync.WaitGroup
+	for w := 0; w < jobs; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				cmd, args := command(sources[i])
+				out, err := exec.Command(cmd, args...).CombinedOutput()
+				// analyzers exit with a non-zero status when they find something, so only a failure to start is an error
+				var exitErr *exec.ExitError
+				if err != nil && !errors.As(err, &exitErr) {
+					err = fmt.Errorf("could not run %s: %w", cmd, err)
+				} else {
+					err = nil
+				}
+				results <- lintResult{i: i, out: out, err: err}
+			}
+		}()
+	}
+	for i := range sources {
+		queue <- i
+	}
+	close(queue)
+	wg.Wait()
+	close(results)
+
+	outputs := make([][]byte, len(sources))
+	for r := range results {
+		if r.err != nil {
+			return nil, r.err
+		}
+		outputs[r.i] = r.out
+	}
+	return outputs, nil
+}
+
+// projectFlags returns the flags compile uses for the project's sources: the language flags and the include paths of
+// the project and its dependencies
+func projectFlags(target string) ([]string, error) {
+	flags := append(buildOptions().LanguageFlags(), "-I"+target+"/src")
+	if *includepath != "" {
+		flags = append(flags, "-I"+*includepath)
+	}
+	depArgs, err := buildOptions().DependencyArgs(target)
+	if err != nil {
+		return nil, err
+	}
+	return append(flags, includeFlags(depArgs)...), nil
+}
+
+// lintCommand returns a function building the command that analyzes one source file with the chosen tool
+func lintCommand(target string, flags []string) (func(source string) (string, []string), error) {
+	switch *lintTool {
+	case "clang-tidy":
+		tidyArgs := []string{"-quiet"}
+		if *lintFix {
+			tidyArgs = append(tidyArgs, "-fix")
+		}
+		// clang-tidy reads .clang-tidy itself; without one, report findings in the project's own headers too
+		if _, err := os.Stat(filepath.Join(target, ".clang-tidy")); err == nil {
+			log.Printf("using %s/.clang-tidy", target)
+		} else {
+			tidyArgs = append(tidyArgs, "-header-filter=^"+regexp.QuoteMeta(target)+"/")
+		}
+		return func(source string) (string, []string) {
+			args := append(append([]string{}, tidyArgs...), source, "--")
+			return "clang-tidy", append(args, flags...)
+		}, nil
+	case "clang-analyzer":
+		analyzer := *compiler
+		if !strings.Contains(filepath.Base(analyzer), "clang") {
+			analyzer = "clang++"
+		}
+		return func(source string) (string, []string) {
+			args := append(append([]string{}, flags...), "--analyze", "--analyzer-output", "text", "-o", os.DevNull)
+			return analyzer, append(args, source)
+		}, nil
+	case "gcc-analyzer":
+		analyzer := *compiler
+		if strings.Contains(filepath.Base(analyzer), "clang") {
+			analyzer = "g++"
+		}
+		return func(source string) (string, []string) {
+			args := append(append([]string{}, flags...), "-fanalyzer", "-fdiagnostics-plain-output", "-c", "-o", os.DevNull)
+			return analyzer, append(args, source)
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown lint tool %q (want clang-tidy, clang-analyzer or gcc-analyzer)", *lintTool)
+}
+
+// parseFindings collects the findings from the analyzer outputs, with paths relative to the project. A header is
+// analyzed with every file that includes it, so findings are deduplicated and then sorted by location.
+func parseFindings(target string, outputs [][]byte) []finding {
+	seen := make(map[finding]bool)
+	findings := make([]finding, 0)
+	for _, out := range outputs {
+		for _, m := range diagnostic.FindAllStringSubmatch(string(out), -1) {
+			f := finding{file: m[1], level: m[4], message: m[5], rule: m[6]}
+			f.line, _ = strconv.Atoi(m[2])
+			f.column, _ = strconv.Atoi(m[3])
+			if rel, err := filepath.Rel(target, f.file); err == nil && !strings.HasPrefix(rel, "..") {
+				f.file = rel
+			}
+			if !seen[f] {
+				seen[f] = true
+				findings = append(findings, f)
+			}
+		}
+	}
+	sort.Slice(findings, func(i, j int) bool {
+		a, b := findings[i], findings[j]
+		if a.file != b.file {
+			return a.file < b.file
+		}
+		if a.line != b.line {
+			return a.line < b.line
+		}
+		return a.column < b.column
+	})
+	return findings
+}
+
+// sarifLog is the subset of SARIF 2.1.0 cm writes: one run of one tool
+type sarifLog struct {
+	Version string     `json:"version"`
+	Schema  string     `json:"$schema"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name  string      `json:"name"`
+	Rules []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID string `json:"id"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId,omitempty"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation struct {
+		ArtifactLocation struct {
+			URI string `json:"uri"`
+		} `json:"artifactLocation"`
+		Region struct {
+			StartLine   int `json:"startLine"`
+			StartColumn int `json:"startColumn"`
+		} `json:"region"`
+	} `json:"physicalLocation"`
+}
+
+// writeSarif writes the findings to path as a SARIF log, which code scanning UIs can display
+func writeSarif(path string, findings []finding) error {
+	run := sarifRun{Tool: sarifTool{Driver: sarifDriver{Name: *lintTool, Rules: make([]sarifRule, 0)}}}
+	run.Results = make([]sarifResult, 0, len(findings))
+	rules := make(map[string]bool)
+	for _, f := range findings {
+		if f.rule != "" && !rules[f.rule] {
+			rules[f.rule] = true
+			run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, sarifRule{ID: f.rule})
+		}
+		var loc sarifLocation
+		loc.PhysicalLocation.ArtifactLocation.URI = filepath.ToSlash(f.file)
+		loc.PhysicalLocation.Region.StartLine = f.line
+		loc.PhysicalLocation.Region.StartColumn = f.column
+		run.Results = append(run.Results, sarifResult{
+			RuleID:    f.rule,
+			Level:     f.level,
+			Message:   sarifMessage{Text: f.message},
+			Locations: []sarifLocation{loc},
+		})
+	}
+	data, err := json.MarshalIndent(sarifLog{
+		Version: "2.1.0",
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Runs:    []sarifRun{run},
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(data, '\n'), 0644)
+}
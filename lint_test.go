@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseFindings(t *testing.T) {
+	outputs := [][]byte{
+		[]byte(`/p/src/b.cpp:10:3: warning: leak of 'p' [CWE-401] [-Wanalyzer-malloc-leak]
+/p/src/b.cpp:9:1: note: allocated here
+/p/src/util.hpp:4:12: warning: use nullptr [modernize-use-nullptr]
+    4 |     int* p = 0;
+      |              ^
+`),
+		[]byte(`/p/src/a.cpp:7:5: error: no member named 'foo' in 'S' [clang-diagnostic-error]
+/p/src/util.hpp:4:12: warning: use nullptr [modernize-use-nullptr]
+/usr/include/x.h:1:1: warning: outside the project
+/p/src/a.cpp:2:1: warning: no rule here
+`),
+		[]byte("2 warnings generated.\n"),
+	}
+	want := []finding{
+		{file: "/usr/include/x.h", line: 1, column: 1, level: "warning", message: "outside the project"},
+		{file: "src/a.cpp", line: 2, column: 1, level: "warning", message: "no rule here"},
+		{
+			file: "src/a.cpp", line: 7, column: 5, level: "error",
+			message: "no member named 'foo' in 'S'", rule: "clang-diagnostic-error",
+		},
+		{
+			file: "src/b.cpp", line: 10, column: 3, level: "warning",
+			message: "leak of 'p' [CWE-401]", rule: "-Wanalyzer-malloc-leak",
+		},
+		{
+			file: "src/util.hpp", line: 4, column: 12, level: "warning",
+			message: "use nullptr", rule: "modernize-use-nullptr",
+		},
+	}
+	if got := parseFindings("/p", outputs); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseFindings() =\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestLintCommand(t *testing.T) {
+	defer func(tool, c string, fix bool) { *lintTool, *compiler, *lintFix = tool, c, fix }(
+		*lintTool, *compiler, *lintFix)
+	target := tempProject(t)
+	flags := []string{"-std=c++17", "-I/p/src"}
+	tests := []struct {
+		tool, compiler string
+		fix, config    bool
+		want           string
+	}{
+		{"clang-tidy", "g++", false, false,
+			"clang-tidy -quiet -header-filter=^" + target + "/ a.cpp -- -std=c++17 -I/p/src"},
+		{"clang-tidy", "g++", true, true, "clang-tidy -quiet -fix a.cpp -- -std=c++17 -I/p/src"},
+		{"clang-analyzer", "g++", false, false,
+			"clang++ -std=c++17 -I/p/src --analyze --analyzer-output text -o /dev/null a.cpp"},
+		{"clang-analyzer", "/opt/llvm/bin/clang++-12", false, false,
+			"/opt/llvm/bin/clang++-12 -std=c++17 -I/p/src --analyze --analyzer-output text -o /dev/null a.cpp"},
+		{"gcc-analyzer", "clang++", false, false,
+			"g++ -std=c++17 -I/p/src -fanalyzer -fdiagnostics-plain-output -c -o /dev/null a.cpp"},
+	}
+	for _, tt := range tests {
+		*lintTool, *compiler, *lintFix = tt.tool, tt.compiler, tt.fix
+		if tt.config {
+			writeFiles(t, target, map[string]string{".clang-tidy": "Checks: '*'\n"})
+		}
+		command, err := lintCommand(target, flags)
+		if err != nil {
+			t.Fatal(err)
+		}
+		name, args := command("a.cpp")
+		if got := strings.Join(append([]string{name}, args...), " "); got != tt.want {
+			t.Errorf("lintCommand() with %s and %s = %q, want %q", tt.tool, tt.compiler, got, tt.want)
+		}
+	}
+	*lintTool = "cppcheck"
+	if _, err := lintCommand(target, flags); err == nil {
+		t.Error("lintCommand() with an unknown tool succeeded")
+	}
+}
+
+func TestWriteSarif(t *testing.T) {
+	defer func(tool string) { *lintTool = tool }(*lintTool)
+	*lintTool = "clang-tidy"
+	path := filepath.Join(tempProject(t), "lint.sarif")
+	findings := []finding{
+		{file: "src/a.cpp", line: 7, column: 5, level: "error", message: "no member", rule: "clang-diagnostic-error"},
+		{file: "src/a.cpp", line: 9, column: 1, level: "warning", message: "no rule here"},
+		{file: "src/b.cpp", line: 1, column: 2, level: "warning", message: "again", rule: "clang-diagnostic-error"},
+	}
+	if err := writeSarif(path, findings); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var log sarifLog
+	if err := json.Unmarshal(data, &log); err != nil {
+		t.Fatal(err)
+	}
+	if log.Version != "2.1.0" || len(log.Runs) != 1 {
+		t.Fatalf("SARIF version %q with %d runs, want 2.1.0 with 1 run", log.Version, len(log.Runs))
+	}
+	run := log.Runs[0]
+	if run.Tool.Driver.Name != "clang-tidy" ||
+		!reflect.DeepEqual(run.Tool.Driver.Rules, []sarifRule{{ID: "clang-diagnostic-error"}}) {
+		t.Errorf("driver = %+v, want clang-tidy with each rule once", run.Tool.Driver)
+	}
+	if len(run.Results) != len(findings) {
+		t.Fatalf("%d results, want %d", len(run.Results), len(findings))
+	}
+	for i, r := range run.Results {
+		f, loc := findings[i], r.Locations[0].PhysicalLocation
+		if r.RuleID != f.rule || r.Level != f.level || r.Message.Text != f.message ||
+			loc.ArtifactLocation.URI != f.file || loc.Region.StartLine != f.line || loc.Region.StartColumn != f.column {
+			t.Errorf("result %d = %+v, want %+v", i, r, f)
+		}
+	}
+	if strings.Contains(string(data), `"ruleId": ""`) {
+		t.Error("a finding without a rule has an empty ruleId")
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	sources := []string{"a.cpp", "b.cpp", "c.cpp", "d.cpp"}
+	// the tool finds something in every file, which makes it exit with a non-zero status
+	echo := func(source string) (string, []string) {
+		return "/bin/sh", []string{"-c", "echo " + source + "; exit 1"}
+	}
+	outputs, err := analyze(sources, 3, echo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, s := range sources {
+		if string(outputs[i]) != s+"\n" {
+			t.Errorf("output for %s = %q", s, outputs[i])
+		}
+	}
+
+	missing := func(source string) (string, []string) {
+		if source == "c.cpp" {
+			return "/nonexistent/clang-tidy", nil
+		}
+		return echo(source)
+	}
+	if _, err := analyze(sources, 2, missing); err == nil || !strings.Contains(err.Error(), "/nonexistent/clang-tidy") {
+		t.Errorf("analyze() with a tool that cannot run = %v, want an error naming it", err)
+	}
+}
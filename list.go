@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/damienstanton/cm/pkg/cm"
+)
+
+// listFlags are the options accepted by `cm list`, on top of the global flags
+var (
+	listFlags    = flag.NewFlagSet("list", flag.ExitOnError)
+	listTemplate = listFlags.String("f", "", "print the listing with this Go template instead of as JSON, e.g. '{{join .Sources \"\\n\"}}'")
+)
+
+// runList prints what cm finds in the project and would build from it, as JSON or through the -f template, so
+// scripts need not reimplement cm's discovery
+func runList(target string) {
+	fw, err := selectFramework()
+	if err != nil {
+		log.Fatalf("test framework error: %+v", err)
+	}
+	fwArgs, harness, err := fw.setup(target)
+	if err != nil {
+		log.Fatalf("could not set up %s: %+v", fw.name(), err)
+	}
+	opts := buildOptions()
+	if !*debug {
+		opts.Log = log.New(ioutil.Discard, "", 0)
+	}
+	l, err := project(target).List(opts, cm.TestOptions{Args: fwArgs, Harness: harness})
+	if err != nil {
+		log.Fatalf("%+v", err)
+	}
+	if *listTemplate == "" {
+		out, err := json.MarshalIndent(l, "", "  ")
+		if err != nil {
+			log.Fatalf("could not encode listing: %+v", err)
+		}
+		fmt.Println(string(out))
+		return
+	}
+	tmpl, err := template.New("list").Funcs(template.FuncMap{"join": strings.Join}).Parse(*listTemplate)
+	if err != nil {
+		log.Fatalf("bad template: %+v", err)
+	}
+	if err := tmpl.Execute(os.Stdout, l); err != nil {
+		log.Fatalf("template error: %+v", err)
+	}
+	fmt.Println()
+}
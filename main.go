@@ -9,7 +9,7 @@ import (
 	"path/filepath"
 	"strings"
 
-	_ "github.com/damienstanton/cm/statik"
+	"github.com/damienstanton/cm/pkg/cm"
 )
 
 var (
@@ -23,16 +23,67 @@ var (
 	testMode    = flag.Bool("test", false, "run tests using Catch2")
 	initF       = flag.Bool("init", false, "scaffold & .gitkeep the required dirs")
 	run         = flag.Bool("run", false, "execute the successfully compiled binary, like go run")
+	traceFile   = flag.String("trace", "", "record the run as Chrome trace events in this file, for chrome://tracing or Perfetto")
 )
 
 func main() {
 	log.SetPrefix("╠ ")
-	flag.Parse()
+	cmd, args := "", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd, args = args[0], args[1:]
+	}
+	fs := flag.CommandLine
+	switch cmd {
+	case "":
+	case "test":
+		fs = testFlags
+		*testMode = true
+	case "judge":
+		fs = judgeFlags
+	case "stress":
+		fs = stressFlags
+	case "run":
+		fs = runFlags
+		*run = true
+	case "build":
+		fs = buildFlags
+	case "init":
+		fs = initFlags
+	case "new":
+		fs = newFlags
+	case "get":
+		fs = getFlags
+	case "install":
+		fs = installFlags
+	case "package":
+		fs = packageFlags
+	case "lint":
+		fs = lintFlags
+	case "fmt":
+		fs = fmtFlags
+	case "bindgen":
+		fs = bindgenFlags
+	case "list":
+		fs = listFlags
+	case "graph":
+		fs = graphFlags
+	default:
+		log.Fatalf("unknown command %q (see cm -help)", cmd)
+	}
+	parseCommand(fs, args)
+	startTrace(cmd)
+	defer stopTrace()
 	target, err := os.Getwd()
 	if err != nil {
 		log.Fatal("could not determine current directory (are you in a symlink?)")
 	}
 
+	if config, err = cm.LoadConfig(target); err != nil {
+		log.Fatalf("config error: %+v", err)
+	}
+	if *name == "" {
+		*name = config.Name
+	}
 	if *name == "" {
 		path, err := filepath.Abs(target)
 		if err != nil {
@@ -42,15 +93,62 @@ func main() {
 		*name = split[len(split)-1]
 	}
 
-	printBanner()
+	if !*dryRun && cmd != "list" && cmd != "graph" {
+		// build plans, listings and graphs go to stdout on their own, so they can be diffed and parsed
+		printBanner()
+	}
 	if *initF {
 		err := mkScaffoldDirs()
 		if err != nil {
 			log.Fatalf("dir write error: %v", err)
 		}
 		log.Printf("init completed successfully for %s\n", target)
+		stopTrace()
 		os.Exit(0)
 	}
+	switch cmd {
+	case "init":
+		runInit(target)
+		return
+	case "new":
+		runNew(target, fs.Args())
+		return
+	case "get":
+		runGet(target, fs.Args())
+		return
+	case "install":
+		runInstall(target)
+		return
+	case "package":
+		runPackage(target)
+		return
+	case "lint":
+		runLint(target)
+		return
+	case "fmt":
+		runFmt(target)
+		return
+	case "bindgen":
+		runBindgen(target, fs.Args())
+		return
+	case "list":
+		runList(target)
+		return
+	case "graph":
+		runGraph(target)
+		return
+	case "judge":
+		runJudge(target)
+		return
+	case "stress":
+		runStress(target)
+		return
+	case "run", "build":
+		if fs.NArg() > 0 {
+			runSingle(fs.Arg(0), fs.Args()[1:])
+			return
+		}
+	}
 	if *testMode {
 		runTests(target)
 
@@ -62,26 +160,41 @@ func main() {
 
 // runCompile executes the given compiler config
 func runCompile(target string, args ...string) {
+	if *dryRun {
+		opts := buildOptions()
+		opts.Extra = args
+		printPlan(project(target).PlanBuild(opts))
+		return
+	}
 	binary := target + "/bin/" + *name
 	log.Printf("binary name: \"%s\"", *name)
 	log.Printf("binary output path: \"%s\"", binary)
 	log.Printf("maximum optimization? %v", *optimize)
+	if config.Type == "header-only" {
+		log.Println("header-only project, there is nothing to compile (try cm test)")
+		return
+	}
 	log.Printf("compiling project...\n")
-	compile(includepath, target, args...)
+	compile(target, args...)
 
 	switch {
 	case *interactive:
 		log.Printf("running %s in interactive mode...", *name)
 		fmt.Println("")
-		err := wrapInteractive(binary, []string{})
+		lim := flagLimits()
+		err := wrapInteractive(lim.command(binary, []string{}))
 		if err != nil {
+			reportLimit(lim, err)
 			log.Fatalf("your program compiled but crashed at runtime: %+v\n", err)
 		}
 
 	case *run:
 		log.Printf("running %s...", *name)
-		out, err := wrap(binary, []string{})
+		lim := flagLimits()
+		out, err := wrap(lim.command(binary, []string{}))
 		if err != nil {
+			fmt.Println(string(out))
+			reportLimit(lim, err)
 			log.Fatalf("your program compiled but crashed at runtime: %+v\n", err)
 		}
 		fmt.Println("running:", *name)
@@ -92,27 +205,77 @@ func runCompile(target string, args ...string) {
 	}
 }
 
-// runCompile executes the given compiler config (like runCompile), but with extra operations around unit tests
+// runTests executes the given compiler config (like runCompile), but with extra operations around unit tests
 func runTests(target string, args ...string) {
-	catchFile := "/catch.hpp"
-	hostFile := "/test_main.cpp"
+	if *testHistory {
+		reportHistory(target)
+		return
+	}
+	fw, err := selectFramework()
+	if err != nil {
+		log.Fatalf("test framework error: %+v", err)
+	}
 
 	log.Println("entering test mode...")
-	copyTestFramework(catchFile, hostFile, target)
-	log.Printf("compiling catch2 and tests (this may take a while)...\n")
-	compile(includepath, target, args...)
+	fwArgs, harness, err := fw.setup(target)
+	if err != nil {
+		log.Fatalf("could not set up %s: %+v", fw.name(), err)
+	}
+	t := cm.TestOptions{Files: testFileGlobs(), Args: append(args, fwArgs...), Harness: harness}
+	build := true
+	if *testAffected {
+		build, err = selectAffectedTests(target, fwArgs, harness)
+		if err != nil {
+			log.Fatalf("could not determine affected tests: %+v", err)
+		}
+		if !build {
+			log.Printf("no test files are affected by changes since %s", *testSince)
+		}
+		t.Files = testFileGlobs()
+	}
+	if *dryRun {
+		if !build {
+			return
+		}
+		printPlan(project(target).PlanTests(buildOptions(), t))
+		return
+	}
 
 	testBinary := target + "/tests/" + *name
-	log.Printf("running %s tests using catch %s", testBinary, catchVersion)
-	out, _ := wrap(testBinary, []string{}) // ignore this error as it just indicates test failures
-	log.Println(string(out))
+	passed := true
+	if build {
+		log.Printf("compiling %s and tests (this may take a while)...\n", fw.name())
+		compileTests(target, t)
+
+		log.Printf("running %s tests using %s", testBinary, fw.name())
+		if isolatedTests() {
+			passed = runIsolatedTests(fw, target, testBinary)
+		} else {
+			passed = runTestBinary(fw, target, testBinary)
+		}
+	}
 
 	log.Println("cleaning up test framework...")
-	err := os.Remove(target + "/tests/" + *name)
-	err = os.Remove(target + "/tests/" + hostFile)
-	err = os.Remove(target + "/tests/" + catchFile)
+	if build {
+		err = os.Remove(testBinary)
+	}
 	if err != nil {
 		log.Fatalf("cleanup error: %+v", err)
 	}
 	log.Println("exited test mode")
+	if !passed {
+		stopTrace()
+		os.Exit(1)
+	}
+}
+
+// parseCommand parses the arguments of a subcommand. Global flags are shared with the subcommand's flag set unless it
+// declares a flag of the same name, so e.g. `cm test -compiler g++` behaves like `cm -test -compiler g++`.
+func parseCommand(fs *flag.FlagSet, args []string) {
+	flag.VisitAll(func(f *flag.Flag) {
+		if fs.Lookup(f.Name) == nil {
+			fs.Var(f.Value, f.Name, f.Usage)
+		}
+	})
+	fs.Parse(args)
 }
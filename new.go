@@ -0,0 +1,173 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"github.com/damienstanton/cm/pkg/cm"
+)
+
+// newFlags is the flag set of `cm new`, which only takes the global flags
+var newFlags = flag.NewFlagSet("new", flag.ExitOnError)
+
+// runNew generates code for `cm new class|test|cmd <name>`
+func runNew(target string, args []string) {
+	if len(args) != 2 {
+		log.Fatalf("usage: cm new class|test|cmd <name>")
+	}
+	kind, name := args[0], args[1]
+	files := map[string]string{}
+	switch kind {
+	case "class":
+		file := snakeCase(name)
+		files["src/"+file+".hpp"] = classHeader(name)
+		files["src/"+file+".cpp"] = classSource(name)
+	case "test":
+		fw, err := selectFramework()
+		if err != nil {
+			log.Fatalf("test framework error: %+v", err)
+		}
+		files["tests/"+snakeCase(name)+"_test.cpp"] = testSource(target, name, fw)
+	case "cmd":
+		if err := checkCommandName(name); err != nil {
+			log.Fatalf("%+v", err)
+		}
+		files["cmd/"+name+"/main.cpp"] = cmdSource(name)
+	default:
+		log.Fatalf("unknown kind %q (want class, test or cmd)", kind)
+	}
+
+	for path := range files {
+		dest := filepath.Join(target, filepath.FromSlash(path))
+		if _, err := os.Stat(dest); err == nil {
+			log.Fatalf("%s already exists", path)
+		}
+	}
+	for path, content := range files {
+		dest := filepath.Join(target, filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(dest), 0777); err != nil {
+			log.Fatalf("dir write error: %v", err)
+		}
+		if err := ioutil.WriteFile(dest, []byte(content), 0664); err != nil {
+			log.Fatalf("file write error: %v", err)
+		}
+		log.Printf("created %s", path)
+	}
+}
+
+// snakeCase turns a C++ type name such as HttpClient into the file name http_client
+func snakeCase(name string) string {
+	var b strings.Builder
+	runes := []rune(name)
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			// start a new word at an upper case letter, unless it continues an acronym
+			if i > 0 && (unicode.IsLower(runes[i-1]) || (i+1 < len(runes) && unicode.IsLower(runes[i+1]))) {
+				b.WriteRune('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return strings.Trim(nonIdent.ReplaceAllString(b.String(), "_"), "_")
+}
+
+// guarded wraps the body of a header in #pragma once or, if cm.json asks for them, include guards
+func guarded(file, body string) string {
+	if !config.IncludeGuards {
+		return "#pragma once\n\n" + body
+	}
+	guard := strings.ToUpper(identifier(*name) + "_" + file + "_HPP")
+	return fmt.Sprintf("#ifndef %s\n#define %s\n\n%s\n#endif  // %s\n", guard, guard, body, guard)
+}
+
+// namespaced wraps code in the namespace from cm.json, if there is one
+func namespaced(code string) string {
+	if config.Namespace == "" {
+		return code
+	}
+	return fmt.Sprintf("namespace %s {\n\n%s\n}  // namespace %s\n", config.Namespace, code, config.Namespace)
+}
+
+// classHeader returns the header declaring a class
+func classHeader(class string) string {
+	return guarded(snakeCase(class), namespaced(fmt.Sprintf(`class %s {
+public:
+    %s();
+    ~%s();
+};
+`, class, class, class)))
+}
+
+// classSource returns the source file defining a class's members
+func classSource(class string) string {
+	return fmt.Sprintf("#include \"%s.hpp\"\n\n", snakeCase(class)) + namespaced(fmt.Sprintf(`%s::%s() = default;
+
+%s::~%s() = default;
+`, class, class, class, class))
+}
+
+// testSource returns a test file for the named class or module. It includes the matching header from src/ (which is on
+// the include path of test builds) if there is one.
+func testSource(target, name string, fw testFramework) string {
+	file := snakeCase(name)
+	include := fmt.Sprintf("// #include \"%s.hpp\" (no such header in src/ yet)", file)
+	if header := findHeader(target+"/src", file); header != "" {
+		include = fmt.Sprintf("#include \"%s\"", header)
+	} else {
+		log.Printf("no header for %s found in src/, leaving the include commented out", name)
+	}
+	qualified := name
+	if config.Namespace != "" {
+		qualified = config.Namespace + "::" + name
+	}
+	return fmt.Sprintf(`%s
+%s
+
+%s {
+    // %s
+    REQUIRE(true);
+}
+`, fw.header(), include, fw.testCase(name+" works", file), qualified)
+}
+
+// findHeader returns the path relative to src of the header with the given base name, or "" if there is none
+func findHeader(src, base string) string {
+	for _, ext := range cm.HeaderExts {
+		found, err := cm.Find(src, base+ext)
+		if err == nil && len(found) > 0 {
+			rel, err := filepath.Rel(src, found[0])
+			if err == nil {
+				return filepath.ToSlash(rel)
+			}
+		}
+	}
+	return ""
+}
+
+// checkCommandName fails for a command name that is not a plain identifier, such as ../tool, which would put the
+// command outside cmd/
+func checkCommandName(name string) error {
+	if name == "" || identifier(name) != name {
+		return fmt.Errorf("invalid command name %q (want letters, digits and underscores, not starting with a digit)",
+			name)
+	}
+	return nil
+}
+
+// cmdSource returns the entry point of a new binary
+func cmdSource(name string) string {
+	return fmt.Sprintf(`#include <iostream>
+
+auto main(int argc, char* argv[]) -> int {
+    std::cout << "Hello from %s!" << std::endl;
+    return 0;
+}
+`, name)
+}
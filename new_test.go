@@ -0,0 +1,109 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/damienstanton/cm/pkg/cm"
+)
+
+func TestSnakeCase(t *testing.T) {
+	tests := map[string]string{
+		"HttpClient":  "http_client",
+		"HTTPClient":  "http_client",
+		"parseURL":    "parse_url",
+		"Greeting":    "greeting",
+		"already_ok":  "already_ok",
+		"IOBuffer2D":  "io_buffer2d",
+		"my-widget":   "my_widget",
+		"A":           "a",
+		"ABC":         "abc",
+		"XMLHttpPost": "xml_http_post",
+	}
+	for name, want := range tests {
+		if got := snakeCase(name); got != want {
+			t.Errorf("snakeCase(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestClassHeader(t *testing.T) {
+	defer func(c cm.Config, n string) { config, *name = c, n }(config, *name)
+	*name = "my-app"
+	config = cm.Config{}
+	want := "#pragma once\n\nclass HttpClient {\npublic:\n    HttpClient();\n    ~HttpClient();\n};\n"
+	if got := classHeader("HttpClient"); got != want {
+		t.Errorf("classHeader() =\n%s\nwant\n%s", got, want)
+	}
+	config = cm.Config{Namespace: "net", IncludeGuards: true}
+	want = "#ifndef MY_APP_HTTP_CLIENT_HPP\n#define MY_APP_HTTP_CLIENT_HPP\n\nnamespace net {\n\n" +
+		"class HttpClient {\npublic:\n    HttpClient();\n    ~HttpClient();\n};\n\n}  // namespace net\n\n" +
+		"#endif  // MY_APP_HTTP_CLIENT_HPP\n"
+	if got := classHeader("HttpClient"); got != want {
+		t.Errorf("classHeader() with a namespace and include guards =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestTestSource(t *testing.T) {
+	defer func(c cm.Config) { config = c }(config)
+	target, err := ioutil.TempDir("", "cm-new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(target)
+	if err := os.MkdirAll(filepath.Join(target, "src", "net"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(target, "src", "net", "http_client.h"), nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		class     string
+		fw        testFramework
+		namespace string
+		want      []string
+	}{
+		{"HttpClient", catch2{}, "", []string{
+			`#include "catch.hpp"`, `#include "net/http_client.h"`, `TEST_CASE("HttpClient works", "[http_client]")`,
+			"// HttpClient\n",
+		}},
+		{"Parser", doctest{}, "app", []string{
+			`#include "doctest.h"`, `// #include "parser.hpp" (no such header in src/ yet)`,
+			`TEST_CASE("Parser works" * doctest::test_suite("parser"))`, "// app::Parser\n",
+		}},
+		{"Parser", catch2v3{}, "", []string{"#include <catch2/catch_test_macros.hpp>"}},
+	}
+	for _, tt := range tests {
+		config = cm.Config{Namespace: tt.namespace}
+		got := testSource(target, tt.class, tt.fw)
+		for _, w := range tt.want {
+			if !strings.Contains(got, w) {
+				t.Errorf("%s test source for %s does not contain %q:\n%s", tt.fw.name(), tt.class, w, got)
+			}
+		}
+	}
+}
+
+func TestCheckCommandName(t *testing.T) {
+	tests := map[string]bool{
+		"tool":       true,
+		"gen_data":   true,
+		"_private":   true,
+		"Tool2":      true,
+		"":           false,
+		"../tool":    false,
+		"nested/cmd": false,
+		"my-tool":    false,
+		"2fast":      false,
+		".":          false,
+	}
+	for name, valid := range tests {
+		if err := checkCommandName(name); (err == nil) != valid {
+			t.Errorf("checkCommandName(%q) = %v, want valid: %v", name, err, valid)
+		}
+	}
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// packageFlags are the options accepted by `cm package`, on top of the global flags
+var (
+	packageFlags   = flag.NewFlagSet("package", flag.ExitOnError)
+	packageFormat  = packageFlags.String("format", "tar", "package format: tar, deb or all")
+	packageVersion = packageFlags.String("version", "", "version to package (default: from cm.json, else git describe)")
+)
+
+// distDir is where cm package writes packages, relative to the project root
+const distDir = "dist"
+
+// manifestFile lists the checksums of every file in a package, in the format of sha256sum
+const manifestFile = "MANIFEST"
+
+// runPackage builds an optimized release and packages it like cm install lays it out, to run from anywhere
+func runPackage(target string) {
+	formats := map[string]bool{}
+	switch *packageFormat {
+	case "tar", "deb":
+		formats[*packageFormat] = true
+	case "all":
+		formats["tar"], formats["deb"] = true, true
+	default:
+		log.Fatalf("unknown package format %q (want tar, deb or all)", *packageFormat)
+	}
+	version := releaseVersion(target)
+	*optimize = true
+	if err := pack(target, version, formats); err != nil {
+		log.Fatalf("package error: %+v", err)
+	}
+}
+
+// pack builds the release and writes the packages of the given formats to dist/, removing its temporary dirs
+func pack(target, version string, formats map[string]bool) error {
+	stage, err := stageBuild(target)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(stage)
+	work, err := ioutil.TempDir("", "cm-package")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(work)
+	if err := os.MkdirAll(filepath.Join(target, distDir), 0777); err != nil {
+		return err
+	}
+
+	if formats["tar"] {
+		base := fmt.Sprintf("%s-%s-%s-%s", *name, version, runtime.GOOS, runtime.GOARCH)
+		tree := filepath.Join(work, "tar", base)
+		// pkg-config resolves ${pcfiledir} to lib/pkgconfig, wherever the tarball is unpacked
+		if err := installTree(target, stage, tree, "${pcfiledir}/../.."); err != nil {
+			return err
+		}
+		if err := writeManifest(tree); err != nil {
+			return err
+		}
+		out := filepath.Join(target, distDir, base+".tar.gz")
+		if err := writeTarball(out, filepath.Dir(tree), base); err != nil {
+			return err
+		}
+		log.Printf("🎉 packaged %s", out)
+	}
+	if formats["deb"] {
+		out, err := writeDeb(target, stage, work, version)
+		if err != nil {
+			return err
+		}
+		log.Printf("🎉 packaged %s", out)
+	}
+	return nil
+}
+
+// releaseVersion returns -version, the version in cm.json or what git describe says, without a leading v
+func releaseVersion(target string) string {
+	version := *packageVersion
+	if version == "" {
+		version = config.Version
+	}
+	if version == "" {
+		version, _ = git(target, "describe", "--tags", "--always", "--dirty")
+	}
+	if version == "" {
+		version = "0.0.0"
+	}
+	return strings.TrimPrefix(version, "v")
+}
+
+// writeManifest writes the checksums of every file under dir, but not of symlinks, to the manifest in dir
+func writeManifest(dir string) error {
+	var b strings.Builder
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&b, "%x  %s\n", sha256.Sum256(data), filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, manifestFile), []byte(b.String()), 0644)
+}
+
+// writeTarball writes the tree under dir/base to a gzipped tarball at out, with every path starting with base/
+func writeTarball(out, dir, base string) error {
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := tarTree(f, dir, base); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// tarTree writes a gzipped tar of the tree under dir/base to w, with sorted entries owned by root
+func tarTree(w io.Writer, dir, base string) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	paths := make([]string, 0)
+	err := filepath.Walk(filepath.Join(dir, base), func(path string, info os.FileInfo, err error) error {
+		if err == nil {
+			paths = append(paths, path)
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		info, err := os.Lstat(path)
+		if err != nil {
+			return err
+		}
+		link, _ := os.Readlink(path)
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+		hdr.Uid, hdr.Gid, hdr.Uname, hdr.Gname = 0, 0, "root", "root"
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			if _, err := tw.Write(data); err != nil {
+				return err
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// debArchs maps GOARCH to Debian architecture names
+var debArchs = map[string]string{
+	"amd64":   "amd64",
+	"386":     "i386",
+	"arm64":   "arm64",
+	"arm":     "armhf",
+	"ppc64le": "ppc64el",
+	"s390x":   "s390x",
+	"riscv64": "riscv64",
+}
+
+// nonDebName matches the runs of characters that may not appear in a Debian package name
+var nonDebName = regexp.MustCompile(`[^A-Za-z0-9.+-]+`)
+
+// writeDeb writes a .deb of the staged build installing into /opt/<name> and returns its path, without dpkg-deb
+func writeDeb(target, stage, work, version string) (string, error) {
+	arch, ok := debArchs[runtime.GOARCH]
+	if runtime.GOOS != "linux" || !ok {
+		return "", fmt.Errorf("cannot build a .deb on %s/%s", runtime.GOOS, runtime.GOARCH)
+	}
+	pkg := strings.ToLower(nonDebName.ReplaceAllString(*name, "-"))
+	prefix := "/opt/" + pkg
+	// Debian versions must start with a digit, which a commit hash from git describe may not
+	if version[0] < '0' || version[0] > '9' {
+		version = "0~" + version
+	}
+	data := filepath.Join(work, "deb")
+	tree := filepath.Join(data, "."+prefix)
+	if err := installTree(target, stage, tree, prefix); err != nil {
+		return "", err
+	}
+	if err := writeManifest(tree); err != nil {
+		return "", err
+	}
+	binaries, _ := filepath.Glob(filepath.Join(tree, "bin", "*"))
+	for _, b := range binaries {
+		link := filepath.Join(data, "usr", "bin", filepath.Base(b))
+		if err := os.MkdirAll(filepath.Dir(link), 0755); err != nil {
+			return "", err
+		}
+		if err := os.Symlink(prefix+"/bin/"+filepath.Base(b), link); err != nil {
+			return "", err
+		}
+	}
+
+	var dataTar, controlTar bytes.Buffer
+	if err := tarTree(&dataTar, data, "."); err != nil {
+		return "", err
+	}
+	size := int64(0)
+	filepath.Walk(data, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return err
+	})
+	control := filepath.Join(work, "control")
+	if err := os.MkdirAll(control, 0755); err != nil {
+		return "", err
+	}
+	fields := fmt.Sprintf(
+		"Package: %s\nVersion: %s\nArchitecture: %s\nMaintainer: %s\nInstalled-Size: %d\nDescription: %s, built with cm\n",
+		pkg, version, arch, maintainer(target), size/1024+1, *name,
+	)
+	if err := ioutil.WriteFile(filepath.Join(control, "control"), []byte(fields), 0644); err != nil {
+		return "", err
+	}
+	if err := tarTree(&controlTar, control, "."); err != nil {
+		return "", err
+	}
+
+	out := filepath.Join(target, distDir, fmt.Sprintf("%s_%s_%s.deb", pkg, version, arch))
+	deb := arArchive(time.Now().Unix(), []arMember{
+		{"debian-binary", []byte("2.0\n")},
+		{"control.tar.gz", controlTar.Bytes()},
+		{"data.tar.gz", dataTar.Bytes()},
+	})
+	return out, ioutil.WriteFile(out, deb, 0644)
+}
+
+// arMember is a file in an ar archive
+type arMember struct {
+	name string
+	data []byte
+}
+
+// arArchive returns an ar archive of the members in order, all modified at mtime, as .deb files are made of
+func arArchive(mtime int64, members []arMember) []byte {
+	var b bytes.Buffer
+	b.WriteString("!<arch>\n")
+	for _, m := range members {
+		fmt.Fprintf(&b, "%-16s%-12d%-6d%-6d%-8s%-10d`\n", m.name, mtime, 0, 0, "100644", len(m.data))
+		b.Write(m.data)
+		// members start at even offsets
+		if len(m.data)%2 == 1 {
+			b.WriteByte('\n')
+		}
+	}
+	return b.Bytes()
+}
+
+// maintainer returns the Maintainer field of a .deb, taken from the git user
+func maintainer(target string) string {
+	user, _ := git(target, "config", "user.name")
+	email, _ := git(target, "config", "user.email")
+	if user == "" {
+		user = "unknown"
+	}
+	if email == "" {
+		return user
+	}
+	return fmt.Sprintf("%s <%s>", user, email)
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/damienstanton/cm/pkg/cm"
+)
+
+// readTarball returns the headers of the entries in a gzipped tarball, in order
+func readTarball(t *testing.T, data []byte) []*tar.Header {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gz)
+	headers := make([]*tar.Header, 0)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return headers
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		headers = append(headers, hdr)
+	}
+}
+
+// readControl returns the control file out of the gzipped control tarball of a .deb
+func readControl(t *testing.T, data []byte) string {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			t.Fatalf("no control file in control.tar.gz: %v", err)
+		}
+		if hdr.Name == "control" {
+			fields, err := ioutil.ReadAll(tr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return string(fields)
+		}
+	}
+}
+
+// readAr splits an ar archive into its members' names and contents, failing on anything out of place
+func readAr(t *testing.T, data []byte) ([]string, [][]byte) {
+	if !bytes.HasPrefix(data, []byte("!<arch>\n")) {
+		t.Fatalf("archive starts with %q", data[:8])
+	}
+	names, contents := make([]string, 0), make([][]byte, 0)
+	for off := 8; off < len(data); {
+		if off%2 != 0 {
+			t.Fatalf("member at odd offset %d", off)
+		}
+		if len(data) < off+60 {
+			t.Fatalf("truncated header at %d", off)
+		}
+		hdr := string(data[off : off+60])
+		if !strings.HasSuffix(hdr, "`\n") || hdr[40:48] != "100644  " {
+			t.Fatalf("malformed header %q", hdr)
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(hdr[48:58]))
+		if err != nil {
+			t.Fatalf("bad size in header %q", hdr)
+		}
+		off += 60
+		names = append(names, strings.TrimSpace(hdr[:16]))
+		contents = append(contents, data[off:off+size])
+		off += size
+		if size%2 == 1 {
+			if data[off] != '\n' {
+				t.Fatalf("odd-sized member %s padded with %q", names[len(names)-1], data[off])
+			}
+			off++
+		}
+	}
+	return names, contents
+}
+
+func TestTarTree(t *testing.T) {
+	dir := tempProject(t)
+	writeFiles(t, dir, map[string]string{
+		"app-1.0/bin/app":           "binary",
+		"app-1.0/lib/libz.so.1.2":   "library",
+		"app-1.0/MANIFEST":          "sums",
+		"app-1.0/include/app/b.hpp": "",
+		"app-1.0/include/app/a.hpp": "",
+	})
+	if err := os.Symlink("libz.so.1.2", filepath.Join(dir, "app-1.0", "lib", "libz.so.1")); err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	if err := tarTree(&b, dir, "app-1.0"); err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, 0)
+	for _, hdr := range readTarball(t, b.Bytes()) {
+		names = append(names, hdr.Name)
+		if hdr.Uid != 0 || hdr.Gid != 0 || hdr.Uname != "root" || hdr.Gname != "root" {
+			t.Errorf("%s is owned by %d:%d (%s:%s), want root", hdr.Name, hdr.Uid, hdr.Gid, hdr.Uname, hdr.Gname)
+		}
+		if hdr.Name == "app-1.0/lib/libz.so.1" && (hdr.Typeflag != tar.TypeSymlink || hdr.Linkname != "libz.so.1.2") {
+			t.Errorf("libz.so.1 is type %c linking to %q, want a symlink to libz.so.1.2", hdr.Typeflag, hdr.Linkname)
+		}
+	}
+	want := []string{
+		"app-1.0/",
+		"app-1.0/MANIFEST",
+		"app-1.0/bin/",
+		"app-1.0/bin/app",
+		"app-1.0/include/",
+		"app-1.0/include/app/",
+		"app-1.0/include/app/a.hpp",
+		"app-1.0/include/app/b.hpp",
+		"app-1.0/lib/",
+		"app-1.0/lib/libz.so.1",
+		"app-1.0/lib/libz.so.1.2",
+	}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("tarTree() entries =\n%q\nwant\n%q", names, want)
+	}
+}
+
+func TestReleaseVersion(t *testing.T) {
+	defer func(v string, c cm.Config) { *packageVersion, config = v, c }(*packageVersion, config)
+	target := tempProject(t)
+	tests := []struct {
+		flag, config string
+		want         string
+	}{
+		{"2.0.0", "1.0.0", "2.0.0"},
+		{"v2.0.0", "", "2.0.0"},
+		{"", "1.0.0", "1.0.0"},
+		{"", "v1.0.0-rc1", "1.0.0-rc1"},
+		{"", "", "0.0.0"},
+	}
+	for _, tt := range tests {
+		*packageVersion, config = tt.flag, cm.Config{Version: tt.config}
+		if got := releaseVersion(target); got != tt.want {
+			t.Errorf("releaseVersion() with -version %q and %q in cm.json = %q, want %q", tt.flag, tt.config, got,
+				tt.want)
+		}
+	}
+}
+
+func TestPackCleansUp(t *testing.T) {
+	defer func(n string, c cm.Config, s string) { *name, config, *std, outputDir, installRPath = n, c, s, "", "" }(
+		*name, config, *std)
+	defer os.Setenv("TMPDIR", os.Getenv("TMPDIR"))
+	target, tmp := tempProject(t), tempProject(t)
+	writeFiles(t, target, map[string]string{"src/json.hpp": "#pragma once\n", "dist": "not a dir"})
+	*name, config, *std = "json", cm.Config{Type: "header-only"}, "c++17"
+	os.Setenv("TMPDIR", tmp)
+	if err := pack(target, "1.0.0", map[string]bool{"tar": true}); err == nil {
+		t.Fatal("pack() into a dist/ that is a file did not fail")
+	}
+	if left, err := ioutil.ReadDir(tmp); err != nil || len(left) != 0 {
+		t.Errorf("pack() left %d files in the temp dir (%v), want its staging and work dirs removed", len(left), err)
+	}
+}
+
+func TestArArchive(t *testing.T) {
+	members := []arMember{
+		{"debian-binary", []byte("2.0\n")},
+		{"odd", []byte("abc")},
+		{"empty", nil},
+		{"after-odd", []byte("x")},
+	}
+	data := arArchive(1600000000, members)
+	names, contents := readAr(t, data)
+	if want := []string{"debian-binary", "odd", "empty", "after-odd"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("members = %q, want %q", names, want)
+	}
+	for i, m := range members {
+		if !bytes.Equal(contents[i], m.data) {
+			t.Errorf("member %s = %q, want %q", m.name, contents[i], m.data)
+		}
+	}
+	if want := "debian-binary   1600000000  0     0     100644  4         `\n"; string(data[8:68]) != want {
+		t.Errorf("first header = %q, want %q", data[8:68], want)
+	}
+	if len(data)%2 != 0 {
+		t.Errorf("archive is %d bytes, want it padded to an even length", len(data))
+	}
+}
+
+func TestWriteDeb(t *testing.T) {
+	if runtime.GOOS != "linux" || debArchs[runtime.GOARCH] == "" {
+		t.Skip("builds a .deb")
+	}
+	defer func(n string, c cm.Config, s string) { *name, config, *std = n, c, s }(*name, config, *std)
+	*name, config, *std = "My_Json", cm.Config{Type: "header-only"}, "c++17"
+	target := tempProject(t)
+	writeFiles(t, target, map[string]string{
+		"src/json.hpp": "#pragma once\n",
+		"dist/.keep":   "",
+	})
+	work := tempProject(t)
+	tests := []struct {
+		version, want string
+	}{
+		{"1.2.0", "1.2.0"},
+		{"abc1234-dirty", "0~abc1234-dirty"},
+	}
+	for _, tt := range tests {
+		os.RemoveAll(filepath.Join(work, "deb"))
+		out, err := writeDeb(target, tempProject(t), work, tt.version)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "my-json_" + tt.want + "_" + debArchs[runtime.GOARCH] + ".deb"; filepath.Base(out) != want {
+			t.Errorf("writeDeb() with version %q wrote %s, want %s", tt.version, filepath.Base(out), want)
+		}
+		data, err := ioutil.ReadFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names, contents := readAr(t, data)
+		if want := []string{"debian-binary", "control.tar.gz", "data.tar.gz"}; !reflect.DeepEqual(names, want) {
+			t.Fatalf("members = %q, want %q", names, want)
+		}
+		if string(contents[0]) != "2.0\n" {
+			t.Errorf("debian-binary = %q", contents[0])
+		}
+		fields := readControl(t, contents[1])
+		if !strings.Contains(fields, "Package: my-json\nVersion: "+tt.want+"\n") {
+			t.Errorf("control of version %q =\n%s", tt.version, fields)
+		}
+		hasPC := false
+		for _, hdr := range readTarball(t, contents[2]) {
+			if hdr.Name == "opt/my-json/lib/pkgconfig/My_Json.pc" {
+				hasPC = true
+			}
+		}
+		if !hasPC {
+			t.Errorf("data.tar.gz does not install the pkg-config file into /opt/my-json")
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package cm
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// ConfigFile is the name of the optional per-project configuration file, read from the project root
+const ConfigFile = "cm.json"
+
+// Config is the JSON structure of cm.json
+type Config struct {
+	// Name is the name of the output binary, used unless -o is given (default: the project dir's name)
+	Name string `json:"name,omitempty"`
+	// Type is app (default), lib or header-only, and decides what a build produces: bin/<name>, bin/lib<name>.so or
+	// nothing at all
+	Type string `json:"type,omitempty"`
+	// Version is the project's version, used for the soname of libraries and by cm install
+	Version string `json:"version,omitempty"`
+	// Namespace wraps the code generated by `cm new`, if set
+	Namespace string `json:"namespace,omitempty"`
+	// IncludeGuards makes `cm new` write #ifndef include guards instead of #pragma once
+	IncludeGuards bool `json:"include_guards,omitempty"`
+	// GoBuildMode is how Go packages in lib/ are built: c-shared (default) or c-archive
+	GoBuildMode string `json:"go_buildmode,omitempty"`
+	// FormatDirs are formatted by cm fmt, on top of src/, include/, tests/ and cmd/
+	FormatDirs []string   `json:"format_dirs,omitempty"`
+	Test       TestConfig `json:"test"`
+}
+
+// TestConfig selects the test framework used by `cm test` and tells cm where to find it
+type TestConfig struct {
+	// Framework is one of catch2 (the embedded v2, default), catch2-v3, doctest or custom
+	Framework string `json:"framework"`
+	// Include and Lib are extra header and library dirs for frameworks that are not embedded in cm
+	Include string `json:"include"`
+	Lib     string `json:"lib"`
+	// Libs are the libraries to link the test binary against, e.g. ["Catch2Main", "Catch2"]
+	Libs []string `json:"libs"`
+	// Main is a source file providing the test main function, for custom frameworks
+	Main string `json:"main"`
+	// Adapter picks how a custom framework's test binary is driven and its output parsed: catch2, catch2-v3 or doctest
+	Adapter string `json:"adapter"`
+}
+
+// LoadConfig reads cm.json from the given dir. A missing file is not an error, and gives the zero Config.
+func LoadConfig(dir string) (Config, error) {
+	var c Config
+	b, err := ioutil.ReadFile(filepath.Join(dir, ConfigFile))
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return c, err
+	}
+	if err := json.Unmarshal(b, &c); err != nil {
+		return c, fmt.Errorf("invalid %s: %w", ConfigFile, err)
+	}
+	return c, nil
+}
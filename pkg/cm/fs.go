@@ -0,0 +1,178 @@
+package cm
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// SourceGlobs matches the C++ translation units cm compiles
+var SourceGlobs = []string{
+	"*.cpp",
+	"*.cxx",
+	"*.cc",
+}
+
+// HeaderExts are the extensions cm treats as C++ headers
+var HeaderExts = []string{".hpp", ".h", ".hh", ".hxx"}
+
+// StateDir returns the project's .cm dir, where cm keeps state between runs, creating it if needed
+func StateDir(dir string) (string, error) {
+	state := filepath.Join(dir, ".cm")
+	return state, os.MkdirAll(state, 0777)
+}
+
+// FindAll takes a given list of file globs and a target dir and returns all the files below the dir matching them
+func FindAll(target string, globs []string) ([]string, error) {
+	res := make([]string, 0)
+	for _, g := range globs {
+		found, err := Find(target, g)
+		if err != nil {
+			return res, fmt.Errorf("could not locate source files: %+w", err)
+		}
+		res = append(res, found...)
+	}
+	return res, nil
+}
+
+// Find returns all files below the given path whose base name matches the glob
+func Find(target, glob string) ([]string, error) {
+	res := make([]string, 0)
+	err := filepath.Walk(target, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		if matched, err := filepath.Match(glob, filepath.Base(path)); err != nil {
+			return err
+		} else if matched {
+			res = append(res, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// mainFunc matches the definition of main, including the `signed main()` of competitive programming
+var mainFunc = regexp.MustCompile(`(?m)^[ \t]*(?:int|auto|signed)[ \t]+main[ \t]*\(`)
+
+// AnyDefinesMain returns true if any of the given source files defines a main function
+func AnyDefinesMain(paths []string) (bool, error) {
+	for _, p := range paths {
+		isMain, err := DefinesMain(p)
+		if err != nil || isMain {
+			return isMain, err
+		}
+	}
+	return false, nil
+}
+
+// DefinesMain returns true if the given source file defines a main function
+func DefinesMain(path string) (bool, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+	return mainFunc.Match(b), nil
+}
+
+// CommandDirs returns the dirs in the project's cmd/, each of which holds the sources of an extra binary
+func CommandDirs(dir string) []string {
+	entries, err := ioutil.ReadDir(filepath.Join(dir, "cmd"))
+	if err != nil {
+		return nil
+	}
+	dirs := make([]string, 0)
+	for _, e := range entries {
+		if e.IsDir() {
+			dirs = append(dirs, filepath.Join(dir, "cmd", e.Name()))
+		}
+	}
+	return dirs
+}
+
+// linkLibs returns the sorted names of the files in the given path, among which are the shared objects to link
+func linkLibs(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return []string{}, err
+	}
+	defer f.Close()
+
+	r, err := f.Readdirnames(-1)
+	if err != nil {
+		return []string{}, err
+	}
+	sort.Strings(r)
+	return r, err
+}
+
+// plannedLibs returns the sorted names of the files in libPath and of the planned outputs a build writes there
+func plannedLibs(libPath string, planned []string) ([]string, error) {
+	libs := make([]string, 0)
+	if isDir(libPath) {
+		var err error
+		if libs, err = linkLibs(libPath); err != nil {
+			return nil, err
+		}
+	}
+	for _, out := range planned {
+		name := filepath.Base(out)
+		if filepath.Dir(out) != libPath {
+			continue
+		}
+		i := sort.SearchStrings(libs, name)
+		if i < len(libs) && libs[i] == name {
+			continue
+		}
+		libs = append(libs, "")
+		copy(libs[i+1:], libs[i:])
+		libs[i] = name
+	}
+	return libs, nil
+}
+
+// SelectFiles keeps keep and the sources whose base names match one of the globs, or all of them without globs
+func SelectFiles(sources, globs []string, keep string) ([]string, error) {
+	if len(globs) == 0 {
+		return sources, nil
+	}
+	res := make([]string, 0)
+	selected := 0
+	for _, s := range sources {
+		base := filepath.Base(s)
+		if base == keep {
+			res = append(res, s)
+			continue
+		}
+		for _, sel := range globs {
+			sel = filepath.Base(strings.TrimSpace(sel))
+			if matched, err := filepath.Match(sel, base); err != nil {
+				return nil, fmt.Errorf("bad file pattern %q: %w", sel, err)
+			} else if matched {
+				res = append(res, s)
+				selected++
+				break
+			}
+		}
+	}
+	if selected == 0 {
+		return nil, fmt.Errorf("no test files match %q", strings.Join(globs, ","))
+	}
+	return res, nil
+}
+
+// isDir reports whether path is an existing dir
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
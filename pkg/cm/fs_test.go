@@ -0,0 +1,106 @@
+package cm
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// tempProject returns a new empty dir that is removed when the test ends
+func tempProject(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "cm-project")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+// writeFiles writes the files, keyed by slash separated path, into dir
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for p, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(p))
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestLibrarySources(t *testing.T) {
+	dir := tempProject(t)
+	writeFiles(t, dir, map[string]string{
+		"main.cpp":          "#include \"greeting.hpp\"\n\nint main(int argc, char** argv) {}\n",
+		"solve.cc":          "signed main() {\n}\n",
+		"trailing.cxx":      "auto main() -> int { return 0; }\n",
+		"spaced.cpp":        "  int  main ( ) {}\n",
+		"greeting.cpp":      "// int main() is in main.cpp\nstd::string greeting() { return \"hi\"; }\n",
+		"loop.cpp":          "int main_loop() { return 0; }\nint domain(int x) { return x; }\n",
+		"util/strings.cxx":  "#include <string>\n",
+		"greeting.hpp":      "int main();\n",
+		"util/strings.hpp":  "#pragma once\n",
+		"not_source.cpp.in": "int main() {}\n",
+	})
+	got, err := BuildOptions{}.LibrarySources(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range got {
+		got[i], _ = filepath.Rel(dir, got[i])
+	}
+	want := []string{"greeting.cpp", "loop.cpp", "util/strings.cxx"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("LibrarySources() = %q, want %q", got, want)
+	}
+
+	if _, err := (BuildOptions{}).LibrarySources("/nonexistent/src"); err == nil {
+		t.Error("LibrarySources() of a missing dir did not fail")
+	}
+}
+
+func TestSelectFiles(t *testing.T) {
+	sources := []string{"/p/tests/test_main.cpp", "/p/tests/greeting_test.cpp", "/p/tests/greeting_fr_test.cpp",
+		"/p/tests/math_test.cpp"}
+	tests := []struct {
+		globs   []string
+		want    []string
+		wantErr bool
+	}{
+		{nil, sources, false},
+		{[]string{"math_test.cpp"}, []string{"/p/tests/test_main.cpp", "/p/tests/math_test.cpp"}, false},
+		{[]string{"tests/math_test.cpp"}, []string{"/p/tests/test_main.cpp", "/p/tests/math_test.cpp"}, false},
+		{[]string{"greeting_*"}, []string{"/p/tests/test_main.cpp", "/p/tests/greeting_test.cpp",
+			"/p/tests/greeting_fr_test.cpp"}, false},
+		{[]string{"math_test.cpp", " greeting_test.cpp"}, []string{"/p/tests/test_main.cpp",
+			"/p/tests/greeting_test.cpp", "/p/tests/math_test.cpp"}, false},
+		{[]string{"test_main.cpp"}, nil, true},
+		{[]string{"missing_test.cpp"}, nil, true},
+		{[]string{"["}, nil, true},
+	}
+	for _, tt := range tests {
+		got, err := SelectFiles(sources, tt.globs, "test_main.cpp")
+		if (err != nil) != tt.wantErr {
+			t.Errorf("SelectFiles(%q) error = %v, want error %v", tt.globs, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("SelectFiles(%q) = %q, want %q", tt.globs, got, tt.want)
+		}
+	}
+}
+
+func TestCommandDirs(t *testing.T) {
+	target := tempProject(t)
+	if got := CommandDirs(target); got != nil {
+		t.Errorf("CommandDirs() without cmd/ = %q", got)
+	}
+	writeFiles(t, target, map[string]string{"cmd/b/main.cpp": "", "cmd/a/main.cpp": "", "cmd/README": ""})
+	want := []string{filepath.Join(target, "cmd", "a"), filepath.Join(target, "cmd", "b")}
+	if got := CommandDirs(target); !reflect.DeepEqual(got, want) {
+		t.Errorf("CommandDirs() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,168 @@
+package cm
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// goLibsFile keeps the hash of the sources every Go library in lib/ was last built from, in the .cm dir
+const goLibsFile = "golibs.json"
+
+// cgoImport matches the import of the C pseudo-package, which marks a Go file that exports functions to C
+var cgoImport = regexp.MustCompile(`(?m)^\s*import\s+"C"`)
+
+// goLib is a Go package in lib/ that is built into a library C++ code can link against
+type goLib struct {
+	dir     string
+	sources []string
+	// output is the library built from the package, in lib/; cgo writes the exported header next to it
+	output string
+}
+
+// findGoLibs returns the cgo packages in lib/, each with the library of the given extension it builds there
+func findGoLibs(libPath, ext string) ([]goLib, error) {
+	libs := make([]goLib, 0)
+	if !isDir(libPath) {
+		return libs, nil
+	}
+	err := filepath.Walk(libPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.IsDir() {
+			return err
+		}
+		sources, _ := filepath.Glob(filepath.Join(path, "*.go"))
+		cgo := ""
+		kept := make([]string, 0, len(sources))
+		for _, s := range sources {
+			if strings.HasSuffix(s, "_test.go") {
+				continue
+			}
+			kept = append(kept, s)
+			data, err := ioutil.ReadFile(s)
+			if err != nil {
+				return err
+			}
+			if cgo == "" && cgoImport.Match(data) {
+				cgo = s
+			}
+		}
+		if cgo == "" {
+			return nil
+		}
+		// subdirs build a library named after them, and lib/ itself one named after its cgo file (hello_cgo.go: libhello)
+		name := filepath.Base(path)
+		if path == libPath {
+			name = strings.TrimSuffix(strings.TrimSuffix(filepath.Base(cgo), ".go"), "_cgo")
+		}
+		libs = append(libs, goLib{dir: path, sources: kept, output: filepath.Join(libPath, "lib"+name+ext)})
+		return nil
+	})
+	return libs, err
+}
+
+// goLibSteps plans building the Go packages in lib/, marking those whose sources have not changed up to date
+func (p *Project) goLibSteps() ([]Step, error) {
+	mode, ext := p.Config.GoBuildMode, ".so"
+	switch mode {
+	case "", "c-shared":
+		mode = "c-shared"
+	case "c-archive":
+		ext = ".a"
+	default:
+		return nil, fmt.Errorf("unknown go_buildmode %q (want c-shared or c-archive)", mode)
+	}
+	libs, err := findGoLibs(filepath.Join(p.Dir, "lib"), ext)
+	if err != nil || len(libs) == 0 {
+		return nil, err
+	}
+	hashes, err := p.goLibHashes()
+	if err != nil {
+		return nil, err
+	}
+	steps := make([]Step, 0, len(libs))
+	for _, g := range libs {
+		rel, _ := filepath.Rel(p.Dir, g.output)
+		hash, err := goLibHash(g, mode)
+		if err != nil {
+			return nil, err
+		}
+		_, err = os.Stat(g.output)
+		command := []string{"go", "build", "-buildmode=" + mode, "-o", g.output}
+		for _, s := range g.sources {
+			command = append(command, filepath.Base(s))
+		}
+		steps = append(steps, Step{
+			Kind:        StepGoLib,
+			Description: "build " + rel + " from Go",
+			Dir:         g.dir,
+			Command:     command,
+			Output:      g.output,
+			Sources:     g.sources,
+			UpToDate:    err == nil && hashes[rel] == hash,
+			hash:        hash,
+		})
+	}
+	return steps, nil
+}
+
+// goLibHashes reads the hashes of the sources the Go libraries in lib/ were last built from
+func (p *Project) goLibHashes() (map[string]string, error) {
+	hashes := make(map[string]string)
+	data, err := ioutil.ReadFile(filepath.Join(p.Dir, ".cm", goLibsFile))
+	if os.IsNotExist(err) {
+		return hashes, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &hashes); err != nil {
+		return nil, fmt.Errorf("%s: %w", goLibsFile, err)
+	}
+	return hashes, nil
+}
+
+// recordGoLib stores the hash of the sources a Go library was just built from
+func (p *Project) recordGoLib(output, hash string) error {
+	hashes, err := p.goLibHashes()
+	if err != nil {
+		return err
+	}
+	dir, err := StateDir(p.Dir)
+	if err != nil {
+		return err
+	}
+	rel, _ := filepath.Rel(p.Dir, output)
+	hashes[rel] = hash
+	data, err := json.MarshalIndent(hashes, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, goLibsFile), data, 0664)
+}
+
+// goLibHash hashes everything a Go library is built from: its sources, module files and the build mode
+func goLibHash(g goLib, mode string) (string, error) {
+	files := append([]string{}, g.sources...)
+	for _, f := range []string{"go.mod", "go.sum"} {
+		if _, err := os.Stat(filepath.Join(g.dir, f)); err == nil {
+			files = append(files, filepath.Join(g.dir, f))
+		}
+	}
+	sort.Strings(files)
+	h := sha256.New()
+	fmt.Fprintln(h, mode)
+	for _, f := range files {
+		data, err := ioutil.ReadFile(f)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%s %d\n", filepath.Base(f), len(data))
+		h.Write(data)
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
@@ -0,0 +1,38 @@
+package cm
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFindGoLibs(t *testing.T) {
+	target := tempProject(t)
+	lib := filepath.Join(target, "lib")
+	writeFiles(t, target, map[string]string{
+		"lib/hello_cgo.go":        "package main\n\nimport \"C\"\n",
+		"lib/helpers.go":          "package main\n",
+		"lib/hello_test.go":       "package main\n\nimport \"C\"\n",
+		"lib/math/math.go":        "package main\n\nimport (\n\t\"fmt\"\n)\n\n// import \"C\" is not at the start\n",
+		"lib/math/export.go":      "package main\n\n  import \"C\"\n",
+		"lib/pure/pure.go":        "package pure\n\nimport \"fmt\"\n",
+		"lib/libprebuilt.so":      "",
+		"lib/nested/deep/deep.go": "package main\nimport \"C\"\n",
+	})
+	libs, err := findGoLibs(lib, ".a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []goLib{
+		{dir: lib, sources: []string{lib + "/hello_cgo.go", lib + "/helpers.go"}, output: lib + "/libhello.a"},
+		{dir: lib + "/math", sources: []string{lib + "/math/export.go", lib + "/math/math.go"}, output: lib + "/libmath.a"},
+		{dir: lib + "/nested/deep", sources: []string{lib + "/nested/deep/deep.go"}, output: lib + "/libdeep.a"},
+	}
+	if !reflect.DeepEqual(libs, want) {
+		t.Errorf("findGoLibs() =\n%+v\nwant\n%+v", libs, want)
+	}
+
+	if libs, err := findGoLibs(filepath.Join(target, "missing"), ".so"); err != nil || len(libs) != 0 {
+		t.Errorf("findGoLibs() of a missing dir = %+v, %v, want no libraries", libs, err)
+	}
+}
@@ -0,0 +1,430 @@
+package cm
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// IncludeGraph is the graph of #include edges between the files of a project, including its vendored dependencies,
+// as reported by the compiler. System headers are left out. Paths are relative to Dir.
+type IncludeGraph struct {
+	Dir   string      `json:"dir"`
+	Files []GraphFile `json:"files"`
+	Edges []Include   `json:"edges"`
+}
+
+// GraphFile is a file in the include graph
+type GraphFile struct {
+	Path string `json:"path"`
+	// Module is the part of the project the file belongs to, see ModuleOf
+	Module string `json:"module"`
+	Size   int64  `json:"size"`
+	// Source is true for translation units, and FanIn counts the translation units that include a header, directly or
+	// through other headers
+	Source bool `json:"source"`
+	FanIn  int  `json:"fan_in"`
+}
+
+// Cost is how expensive a header is to the build: its size times the number of translation units that include it
+func (f GraphFile) Cost() int64 {
+	return f.Size * int64(f.FanIn)
+}
+
+// Include is an edge of the include graph: From includes To
+type Include struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// CrossInclude is a header included from a module other than the one owning it
+type CrossInclude struct {
+	Header string `json:"header"`
+	Owner  string `json:"owner"`
+	Module string `json:"module"`
+	// IncludedBy are the files of Module that include the header
+	IncludedBy []string `json:"included_by"`
+}
+
+// includeTree matches a line of the include tree the compiler prints with -H, where the dots give the depth
+var includeTree = regexp.MustCompile(`^(\.+)[!x]? (.+)$`)
+
+// IncludeGraph asks the compiler for the include tree of every source and header in src/, include/, tests/ and cmd/,
+// and merges them into the project's include graph. Headers that cannot be found, such as a test framework that is
+// only put in place for test builds, are left out.
+func (p *Project) IncludeGraph(o BuildOptions) (*IncludeGraph, error) {
+	o = o.withDefaults()
+	depArgs, err := o.DependencyArgs(p.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("dependency error: %w", err)
+	}
+	src := filepath.Join(p.Dir, "src")
+	include := src
+	if o.IncludePath != "" {
+		include = o.IncludePath
+	}
+	flags := []string{"-std=" + o.Std, "-I" + include}
+	for _, a := range append(append([]string{}, o.Extra...), depArgs...) {
+		if strings.HasPrefix(a, "-I") || strings.HasPrefix(a, "-D") || strings.HasPrefix(a, "-isystem") {
+			flags = append(flags, a)
+		}
+	}
+	testFlags := append(append([]string{}, flags...), "-I"+filepath.Join(p.Dir, "tests"), "-I"+src)
+
+	headerGlobs := make([]string, 0, len(HeaderExts))
+	for _, ext := range HeaderExts {
+		headerGlobs = append(headerGlobs, "*"+ext)
+	}
+	type root struct {
+		path   string
+		source bool
+		flags  []string
+	}
+	roots := make([]root, 0)
+	dirs := append([]string{src, filepath.Join(p.Dir, "include"), filepath.Join(p.Dir, "tests")}, CommandDirs(p.Dir)...)
+	for _, dir := range dirs {
+		f := flags
+		if filepath.Base(dir) == "tests" {
+			f = testFlags
+		}
+		sources, err := findIn(dir, SourceGlobs)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range sources {
+			roots = append(roots, root{s, true, f})
+		}
+		headers, err := findIn(dir, headerGlobs)
+		if err != nil {
+			return nil, err
+		}
+		for _, h := range headers {
+			roots = append(roots, root{h, false, f})
+		}
+	}
+
+	// every root is scanned on its own, as the compiler skips headers already included once in a translation unit
+	trees := make([][]Include, len(roots))
+	errs := make([]error, len(roots))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < runtime.NumCPU(); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				args := append(append([]string{}, roots[i].flags...), "-M", "-MG", "-H")
+				if !roots[i].source {
+					args = append(args, "-x", "c++")
+				}
+				trees[i], errs[i] = p.includeTree(o, roots[i].path, append(args, roots[i].path))
+			}
+		}()
+	}
+	for i := range roots {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	g := &IncludeGraph{Dir: p.Dir, Files: make([]GraphFile, 0), Edges: make([]Include, 0)}
+	files := make(map[string]*GraphFile)
+	node := func(path string) *GraphFile {
+		if f, ok := files[path]; ok {
+			return f
+		}
+		f := &GraphFile{Path: path, Module: ModuleOf(path)}
+		if info, err := os.Stat(filepath.Join(p.Dir, path)); err == nil {
+			f.Size = info.Size()
+		}
+		files[path] = f
+		return f
+	}
+	edges := make(map[Include]bool)
+	for i, r := range roots {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		rel, _ := filepath.Rel(p.Dir, r.path)
+		node(rel).Source = r.source
+		seen := make(map[string]bool)
+		for _, e := range trees[i] {
+			node(e.From)
+			node(e.To)
+			edges[e] = true
+			if r.source && !seen[e.To] {
+				seen[e.To] = true
+				files[e.To].FanIn++
+			}
+		}
+	}
+	for _, f := range files {
+		g.Files = append(g.Files, *f)
+	}
+	sort.Slice(g.Files, func(i, j int) bool { return g.Files[i].Path < g.Files[j].Path })
+	for e := range edges {
+		g.Edges = append(g.Edges, e)
+	}
+	sort.Slice(g.Edges, func(i, j int) bool {
+		if g.Edges[i].From != g.Edges[j].From {
+			return g.Edges[i].From < g.Edges[j].From
+		}
+		return g.Edges[i].To < g.Edges[j].To
+	})
+	return g, nil
+}
+
+// includeTree runs the compiler on a file with the given args and returns the include edges between project files in
+// the tree it prints
+func (p *Project) includeTree(o BuildOptions, path string, args []string) ([]Include, error) {
+	if o.Debug {
+		o.Log.Printf("%s %s", o.Compiler, strings.Join(args, " "))
+	}
+	out, err := o.run(nil, p.Dir, o.Compiler, args...)
+	if err != nil {
+		return nil, fmt.Errorf("could not scan the includes of %s: %v\n%s", path, err, out)
+	}
+	rel, _ := filepath.Rel(p.Dir, path)
+	return parseIncludeTree(p.Dir, rel, out), nil
+}
+
+// parseIncludeTree returns the include edges between the files in dir from the include tree of root printed with -H.
+// Files outside dir are left out, along with their edges to files in dir.
+func parseIncludeTree(dir, root string, out []byte) []Include {
+	// stack holds the file at every depth of the tree, the root at 0, and "" for files outside the project
+	stack := []string{root}
+	edges := make([]Include, 0)
+	for _, line := range strings.Split(string(out), "\n") {
+		m := includeTree.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		depth, file := len(m[1]), m[2]
+		if depth > len(stack) {
+			continue
+		}
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(dir, file)
+		}
+		stack = stack[:depth]
+		rel, err := filepath.Rel(dir, filepath.Clean(file))
+		if err != nil || strings.HasPrefix(rel, "..") {
+			rel = ""
+		}
+		if parent := stack[depth-1]; parent != "" && rel != "" {
+			edges = append(edges, Include{From: parent, To: rel})
+		}
+		stack = append(stack, rel)
+	}
+	return edges
+}
+
+// ModuleOf returns the module a project file belongs to: a vendored dependency (third_party/<name>), a dir directly
+// below a top-level dir (such as src/net or cmd/tool), or the top-level dir for files directly in it (src, tests)
+func ModuleOf(path string) string {
+	parts := strings.Split(filepath.ToSlash(path), "/")
+	switch {
+	case len(parts) == 1:
+		return "."
+	case len(parts) == 2:
+		return parts[0]
+	default:
+		return parts[0] + "/" + parts[1]
+	}
+}
+
+// topDir returns the top-level dir of a project file, or "." for files at the root
+func topDir(path string) string {
+	return strings.Split(ModuleOf(path), "/")[0]
+}
+
+// Cycles returns the include cycles in the graph, one for every group of headers that include each other, as the
+// files along the cycle with the first repeated at the end
+func (g *IncludeGraph) Cycles() [][]string {
+	out := make(map[string][]string)
+	for _, e := range g.Edges {
+		out[e.From] = append(out[e.From], e.To)
+	}
+	cycles := make([][]string, 0)
+	for _, scc := range components(g.Files, out) {
+		in := make(map[string]bool, len(scc))
+		for _, f := range scc {
+			in[f] = true
+		}
+		if len(scc) == 1 && !contains(out[scc[0]], scc[0]) {
+			continue
+		}
+		cycles = append(cycles, cycleThrough(scc[0], out, in))
+	}
+	return cycles
+}
+
+// components returns the strongly connected components of the graph (Tarjan's algorithm), each sorted
+func components(files []GraphFile, out map[string][]string) [][]string {
+	index, low := make(map[string]int), make(map[string]int)
+	onStack := make(map[string]bool)
+	stack := make([]string, 0)
+	res := make([][]string, 0)
+	var visit func(v string)
+	visit = func(v string) {
+		index[v], low[v] = len(index), len(index)
+		stack = append(stack, v)
+		onStack[v] = true
+		for _, w := range out[v] {
+			if _, ok := index[w]; !ok {
+				visit(w)
+				if low[w] < low[v] {
+					low[v] = low[w]
+				}
+			} else if onStack[w] && index[w] < low[v] {
+				low[v] = index[w]
+			}
+		}
+		if low[v] != index[v] {
+			return
+		}
+		scc := make([]string, 0)
+		for {
+			w := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[w] = false
+			scc = append(scc, w)
+			if w == v {
+				break
+			}
+		}
+		sort.Strings(scc)
+		res = append(res, scc)
+	}
+	for _, f := range files {
+		if _, ok := index[f.Path]; !ok {
+			visit(f.Path)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i][0] < res[j][0] })
+	return res
+}
+
+// cycleThrough returns the shortest cycle from start back to itself that stays within the given files
+func cycleThrough(start string, out map[string][]string, within map[string]bool) []string {
+	prev := map[string]string{}
+	queue := []string{start}
+	for len(queue) > 0 {
+		v := queue[0]
+		queue = queue[1:]
+		for _, w := range out[v] {
+			if w == start {
+				path := []string{start}
+				for u := v; u != start; u = prev[u] {
+					path = append([]string{u}, path...)
+				}
+				return append([]string{start}, path...)
+			}
+			if _, seen := prev[w]; !seen && within[w] {
+				prev[w] = v
+				queue = append(queue, w)
+			}
+		}
+	}
+	return []string{start}
+}
+
+// Expensive returns the n headers with the highest cost, most expensive first
+func (g *IncludeGraph) Expensive(n int) []GraphFile {
+	headers := make([]GraphFile, 0)
+	for _, f := range g.Files {
+		if !f.Source && f.FanIn > 0 {
+			headers = append(headers, f)
+		}
+	}
+	sort.SliceStable(headers, func(i, j int) bool { return headers[i].Cost() > headers[j].Cost() })
+	if n >= 0 && len(headers) > n {
+		headers = headers[:n]
+	}
+	return headers
+}
+
+// CrossModule returns the headers that are included from modules other than the ones owning them, sorted by header
+// and including module. Tests including the project's headers in src/ and include/ are what tests are for, so they do
+// not count.
+func (g *IncludeGraph) CrossModule() []CrossInclude {
+	modules := make(map[string]string, len(g.Files))
+	for _, f := range g.Files {
+		modules[f.Path] = f.Module
+	}
+	byKey := make(map[[2]string]*CrossInclude)
+	res := make([]*CrossInclude, 0)
+	for _, e := range g.Edges {
+		from, owner := modules[e.From], modules[e.To]
+		if from == owner || (topDir(e.From) == "tests" && (topDir(e.To) == "src" || topDir(e.To) == "include")) {
+			continue
+		}
+		key := [2]string{e.To, from}
+		c, ok := byKey[key]
+		if !ok {
+			c = &CrossInclude{Header: e.To, Owner: owner, Module: from}
+			byKey[key] = c
+			res = append(res, c)
+		}
+		c.IncludedBy = append(c.IncludedBy, e.From)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Header != res[j].Header {
+			return res[i].Header < res[j].Header
+		}
+		return res[i].Module < res[j].Module
+	})
+	cross := make([]CrossInclude, 0, len(res))
+	for _, c := range res {
+		cross = append(cross, *c)
+	}
+	return cross
+}
+
+// DOT renders the graph for Graphviz, with a cluster for every module, translation units as ellipses, headers as
+// boxes and the edges of include cycles in red
+func (g *IncludeGraph) DOT() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "digraph %q {\n", filepath.Base(g.Dir))
+	b.WriteString("  rankdir=LR;\n  node [shape=box, fontsize=10];\n")
+	modules := make(map[string][]GraphFile)
+	names := make([]string, 0)
+	for _, f := range g.Files {
+		if _, ok := modules[f.Module]; !ok {
+			names = append(names, f.Module)
+		}
+		modules[f.Module] = append(modules[f.Module], f)
+	}
+	sort.Strings(names)
+	for i, m := range names {
+		fmt.Fprintf(&b, "  subgraph cluster_%d {\n    label=%q;\n", i, m)
+		for _, f := range modules[m] {
+			shape := ""
+			if f.Source {
+				shape = " [shape=ellipse]"
+			}
+			fmt.Fprintf(&b, "    %q%s;\n", f.Path, shape)
+		}
+		b.WriteString("  }\n")
+	}
+	inCycle := make(map[Include]bool)
+	for _, c := range g.Cycles() {
+		for i := 0; i+1 < len(c); i++ {
+			inCycle[Include{From: c[i], To: c[i+1]}] = true
+		}
+	}
+	for _, e := range g.Edges {
+		attrs := ""
+		if inCycle[e] {
+			attrs = " [color=red]"
+		}
+		fmt.Fprintf(&b, "  %q -> %q%s;\n", e.From, e.To, attrs)
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
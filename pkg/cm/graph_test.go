@@ -0,0 +1,205 @@
+package cm
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseIncludeTree(t *testing.T) {
+	out := `. /p/src/net/http.hpp
+.. /usr/include/c++/12/string
+... /p/src/util.hpp
+.. src/util.hpp
+. /p/third_party/fmt/include/fmt/core.h
+.! /p/src/pch.hpp
+.. /p/src/net/http.hpp
+Multiple include guards may be useful for:
+/p/src/util.hpp
+`
+	want := []Include{
+		{From: "src/net/http.cpp", To: "src/net/http.hpp"},
+		{From: "src/net/http.hpp", To: "src/util.hpp"},
+		{From: "src/net/http.cpp", To: "third_party/fmt/include/fmt/core.h"},
+		{From: "src/net/http.cpp", To: "src/pch.hpp"},
+		{From: "src/pch.hpp", To: "src/net/http.hpp"},
+	}
+	if got := parseIncludeTree("/p", "src/net/http.cpp", []byte(out)); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseIncludeTree() =\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestModuleOf(t *testing.T) {
+	tests := map[string]string{
+		"main.cpp":                           ".",
+		"src/main.cpp":                       "src",
+		"src/net/http.hpp":                   "src/net",
+		"src/net/detail/socket.hpp":          "src/net",
+		"cmd/tool/main.cpp":                  "cmd/tool",
+		"third_party/fmt/include/fmt/core.h": "third_party/fmt",
+	}
+	for path, want := range tests {
+		if got := ModuleOf(path); got != want {
+			t.Errorf("ModuleOf(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
+
+// graph builds an include graph of the given edges, with every file of size 1 in the module ModuleOf gives it
+func graph(edges ...Include) *IncludeGraph {
+	g := &IncludeGraph{Dir: "/p", Edges: edges}
+	seen := map[string]bool{}
+	for _, e := range edges {
+		for _, f := range []string{e.From, e.To} {
+			if !seen[f] {
+				seen[f] = true
+				g.Files = append(g.Files, GraphFile{Path: f, Module: ModuleOf(f), Size: 1})
+			}
+		}
+	}
+	return g
+}
+
+func TestCycles(t *testing.T) {
+	tests := []struct {
+		name  string
+		edges []Include
+		want  [][]string
+	}{
+		{"acyclic", []Include{{"src/a.cpp", "src/a.hpp"}, {"src/a.hpp", "src/b.hpp"}}, [][]string{}},
+		{"self include", []Include{{"src/a.hpp", "src/a.hpp"}}, [][]string{{"src/a.hpp", "src/a.hpp"}}},
+		{
+			"two headers",
+			[]Include{{"src/a.cpp", "src/a.hpp"}, {"src/a.hpp", "src/b.hpp"}, {"src/b.hpp", "src/a.hpp"}},
+			[][]string{{"src/a.hpp", "src/b.hpp", "src/a.hpp"}},
+		},
+		{
+			"shortest cycle through the first header",
+			[]Include{
+				{"src/a.hpp", "src/b.hpp"}, {"src/b.hpp", "src/c.hpp"}, {"src/c.hpp", "src/a.hpp"},
+				{"src/b.hpp", "src/a.hpp"},
+			},
+			[][]string{{"src/a.hpp", "src/b.hpp", "src/a.hpp"}},
+		},
+		{
+			"separate cycles",
+			[]Include{
+				{"src/a.hpp", "src/b.hpp"}, {"src/b.hpp", "src/a.hpp"},
+				{"src/x.hpp", "src/y.hpp"}, {"src/y.hpp", "src/z.hpp"}, {"src/z.hpp", "src/x.hpp"},
+			},
+			[][]string{{"src/a.hpp", "src/b.hpp", "src/a.hpp"}, {"src/x.hpp", "src/y.hpp", "src/z.hpp", "src/x.hpp"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := graph(tt.edges...).Cycles(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Cycles() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpensive(t *testing.T) {
+	g := &IncludeGraph{Files: []GraphFile{
+		{Path: "src/a.cpp", Size: 1000, Source: true, FanIn: 0},
+		{Path: "src/small.hpp", Size: 10, FanIn: 5},
+		{Path: "src/big.hpp", Size: 100, FanIn: 2},
+		{Path: "src/unused.hpp", Size: 1000, FanIn: 0},
+		{Path: "src/wide.hpp", Size: 20, FanIn: 10},
+	}}
+	paths := func(files []GraphFile) []string {
+		res := make([]string, 0, len(files))
+		for _, f := range files {
+			res = append(res, f.Path)
+		}
+		return res
+	}
+	want := []string{"src/big.hpp", "src/wide.hpp", "src/small.hpp"}
+	if got := paths(g.Expensive(-1)); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expensive(-1) = %q, want %q", got, want)
+	}
+	if got, want := paths(g.Expensive(1)), []string{"src/big.hpp"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expensive(1) = %q, want %q", got, want)
+	}
+}
+
+func TestCrossModule(t *testing.T) {
+	g := graph(
+		Include{"src/net/http.cpp", "src/net/http.hpp"},
+		Include{"src/main.cpp", "src/net/http.hpp"},
+		Include{"src/app.cpp", "src/net/http.hpp"},
+		Include{"tests/http_test.cpp", "src/net/http.hpp"},
+		Include{"tests/http_test.cpp", "include/app/api.hpp"},
+		Include{"tests/http_test.cpp", "tests/helpers/server.hpp"},
+		Include{"tests/http_test.cpp", "third_party/fmt/include/fmt/core.h"},
+		Include{"src/net/http.hpp", "third_party/fmt/include/fmt/core.h"},
+	)
+	want := []CrossInclude{
+		{Header: "src/net/http.hpp", Owner: "src/net", Module: "src", IncludedBy: []string{"src/main.cpp", "src/app.cpp"}},
+		{Header: "tests/helpers/server.hpp", Owner: "tests/helpers", Module: "tests",
+			IncludedBy: []string{"tests/http_test.cpp"}},
+		{
+			Header: "third_party/fmt/include/fmt/core.h", Owner: "third_party/fmt", Module: "src/net",
+			IncludedBy: []string{"src/net/http.hpp"},
+		},
+		{
+			Header: "third_party/fmt/include/fmt/core.h", Owner: "third_party/fmt", Module: "tests",
+			IncludedBy: []string{"tests/http_test.cpp"},
+		},
+	}
+	if got := g.CrossModule(); !reflect.DeepEqual(got, want) {
+		t.Errorf("CrossModule() =\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestDOT(t *testing.T) {
+	g := graph(Include{"src/a.cpp", "src/a.hpp"}, Include{"src/a.hpp", "src/b.hpp"}, Include{"src/b.hpp", "src/a.hpp"})
+	g.Files[0].Source = true
+	want := `digraph "p" {
+  rankdir=LR;
+  node [shape=box, fontsize=10];
+  subgraph cluster_0 {
+    label="src";
+    "src/a.cpp" [shape=ellipse];
+    "src/a.hpp";
+    "src/b.hpp";
+  }
+  "src/a.cpp" -> "src/a.hpp";
+  "src/a.hpp" -> "src/b.hpp" [color=red];
+  "src/b.hpp" -> "src/a.hpp" [color=red];
+}
+`
+	if got := g.DOT(); got != want {
+		t.Errorf("DOT() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestIncludeGraph(t *testing.T) {
+	o := BuildOptions{Compiler: testCompiler(t), Std: "c++17"}
+	dir := tempProject(t)
+	writeFiles(t, dir, map[string]string{
+		"src/main.cpp":        "#include \"net/http.hpp\"\n#include <vector>\nint main() {}\n",
+		"src/net/http.hpp":    "#pragma once\n#include \"util.hpp\"\n",
+		"src/util.hpp":        "#pragma once\n",
+		"tests/http_test.cpp": "#include \"util.hpp\"\n#include \"net/http.hpp\"\n",
+	})
+	g, err := (&Project{Dir: dir, Name: "app"}).IncludeGraph(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Include{
+		{"src/main.cpp", "src/net/http.hpp"},
+		{"src/net/http.hpp", "src/util.hpp"},
+		{"tests/http_test.cpp", "src/net/http.hpp"},
+		{"tests/http_test.cpp", "src/util.hpp"},
+	}
+	if !reflect.DeepEqual(g.Edges, want) {
+		t.Errorf("IncludeGraph() edges =\n%+v\nwant\n%+v", g.Edges, want)
+	}
+	fanIn := make(map[string]int)
+	for _, f := range g.Files {
+		fanIn[f.Path] = f.FanIn
+	}
+	if fanIn["src/util.hpp"] != 2 || fanIn["src/net/http.hpp"] != 2 || fanIn["src/main.cpp"] != 0 {
+		t.Errorf("IncludeGraph() fan-in = %v", fanIn)
+	}
+}
@@ -0,0 +1,227 @@
+package cm
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Listing describes a project the way cm sees it: the files it finds, the targets it builds from them and the flags,
+// libraries and dependencies they are built with
+type Listing struct {
+	Name    string `json:"name"`
+	Dir     string `json:"dir"`
+	Type    string `json:"type"`
+	Version string `json:"version,omitempty"`
+	// Sources are the translation units in src/, and Main the one among them defining main, if any
+	Sources []string `json:"sources"`
+	Main    string   `json:"main,omitempty"`
+	// Headers are the headers in src/ and include/
+	Headers []string `json:"headers"`
+	// Tests are the test sources in tests/
+	Tests []string `json:"tests"`
+	// Commands are the dirs in cmd/, each of which builds a binary
+	Commands []string `json:"commands,omitempty"`
+	// Compiler and Flags are what every target is compiled with, before its own args
+	Compiler string   `json:"compiler"`
+	Flags    []string `json:"flags"`
+	Targets  []Target `json:"targets"`
+	// Libraries are the libraries in lib/ that targets link against, including those built from Go
+	Libraries    []Library        `json:"libraries"`
+	Dependencies []DependencyNode `json:"dependencies"`
+}
+
+// Target is a binary or library a build produces, with the effective args it is compiled with
+type Target struct {
+	Name string `json:"name"`
+	// Kind is binary, library, command or tests
+	Kind        string   `json:"kind"`
+	Output      string   `json:"output"`
+	Sources     []string `json:"sources"`
+	Flags       []string `json:"flags"`
+	IncludeDirs []string `json:"include_dirs"`
+	LibDirs     []string `json:"lib_dirs"`
+	Libs        []string `json:"libs"`
+	Command     []string `json:"command"`
+}
+
+// Library is a library in lib/
+type Library struct {
+	// Name is what the library is linked as (-l<name>)
+	Name string `json:"name"`
+	Path string `json:"path"`
+	// Kind is shared or archive
+	Kind string `json:"kind"`
+	// Go is the Go package the library is built from, if any; it need not have been built yet
+	Go string `json:"go,omitempty"`
+}
+
+// DependencyNode is a dependency in cm.lock, where it is vendored and what it provides, and the dependencies in its
+// own cm.lock
+type DependencyNode struct {
+	Dependency
+	Dir string `json:"dir"`
+	// Vendored is false until cm get checks the dependency out
+	Vendored   bool     `json:"vendored"`
+	IncludeDir string   `json:"include_dir,omitempty"`
+	Libraries  []string `json:"libraries,omitempty"`
+	// Dependencies are the dependencies of the dependency
+	Dependencies []DependencyNode `json:"dependencies"`
+}
+
+// List describes the project: what cm finds in it, and the targets of the build and test plans. t gives the test
+// framework args and harness, like for a test build. Dependencies that are not vendored yet are listed, but left out of
+// the targets' args.
+func (p *Project) List(o BuildOptions, t TestOptions) (*Listing, error) {
+	o = o.withDefaults()
+	o.skipMissingDeps = true
+	l := &Listing{
+		Name:     p.Name,
+		Dir:      p.Dir,
+		Type:     p.Config.Type,
+		Version:  p.Config.Version,
+		Compiler: o.Compiler,
+		Flags:    append(o.LanguageFlags(), o.Extra...),
+	}
+	if l.Type == "" {
+		l.Type = "app"
+	}
+	var err error
+	src := filepath.Join(p.Dir, "src")
+	if l.Sources, err = findIn(src, SourceGlobs); err != nil {
+		return nil, err
+	}
+	for _, s := range l.Sources {
+		if isMain, err := DefinesMain(s); err != nil {
+			return nil, err
+		} else if isMain {
+			l.Main = s
+			break
+		}
+	}
+	headerGlobs := make([]string, 0, len(HeaderExts))
+	for _, ext := range HeaderExts {
+		headerGlobs = append(headerGlobs, "*"+ext)
+	}
+	l.Headers = make([]string, 0)
+	for _, dir := range []string{src, filepath.Join(p.Dir, "include")} {
+		headers, err := findIn(dir, headerGlobs)
+		if err != nil {
+			return nil, err
+		}
+		l.Headers = append(l.Headers, headers...)
+	}
+	if l.Tests, err = findIn(filepath.Join(p.Dir, "tests"), SourceGlobs); err != nil {
+		return nil, err
+	}
+	l.Commands = CommandDirs(p.Dir)
+
+	build, err := p.PlanBuild(o)
+	if err != nil {
+		return nil, err
+	}
+	l.Targets = make([]Target, 0)
+	for _, s := range build.Steps {
+		if s.Kind != StepCompile {
+			continue
+		}
+		kind := "binary"
+		if s.Output != build.Binary {
+			kind = "command"
+		} else if p.Config.Type == "lib" {
+			kind = "library"
+		}
+		l.Targets = append(l.Targets, target(kind, s))
+	}
+	if len(l.Tests) > 0 {
+		tests, err := p.PlanTests(o, t)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range tests.Steps {
+			if s.Kind == StepCompile {
+				l.Targets = append(l.Targets, target("tests", s))
+			}
+		}
+	}
+	if l.Libraries, err = p.libraries(build); err != nil {
+		return nil, err
+	}
+	l.Dependencies, err = dependencyGraph(p.Dir)
+	return l, err
+}
+
+// findIn returns the files below dir matching the globs, or none if dir does not exist
+func findIn(dir string, globs []string) ([]string, error) {
+	if !isDir(dir) {
+		return make([]string, 0), nil
+	}
+	return FindAll(dir, globs)
+}
+
+// target describes the target a compile step builds
+func target(kind string, s Step) Target {
+	t := Target{Name: filepath.Base(s.Output), Kind: kind, Output: s.Output, Sources: s.Sources, Flags: s.Flags,
+		IncludeDirs: s.IncludeDirs, LibDirs: s.LibDirs, Libs: s.Libs, Command: s.Command}
+	for _, f := range []*[]string{&t.Sources, &t.Flags, &t.IncludeDirs, &t.LibDirs, &t.Libs} {
+		if *f == nil {
+			*f = make([]string, 0)
+		}
+	}
+	return t
+}
+
+// libraries returns the libraries in lib/ that the build links against, including the Go libraries it builds
+func (p *Project) libraries(build *Plan) ([]Library, error) {
+	libPath := filepath.Join(p.Dir, "lib")
+	goDirs := make(map[string]string)
+	for _, s := range build.Steps {
+		if s.Kind == StepGoLib {
+			goDirs[s.Output] = s.Dir
+		}
+	}
+	names, err := plannedLibs(libPath, build.outputs(StepGoLib))
+	if err != nil {
+		return nil, fmt.Errorf("error reading shared libraries: %w", err)
+	}
+	libs := make([]Library, 0, len(names))
+	for _, n := range names {
+		ext := filepath.Ext(n)
+		if !strings.HasPrefix(n, "lib") || (ext != ".so" && ext != ".a") {
+			continue
+		}
+		lib := Library{
+			Name: strings.TrimSuffix(strings.TrimPrefix(n, "lib"), ext),
+			Path: filepath.Join(libPath, n),
+			Kind: "shared",
+			Go:   goDirs[filepath.Join(libPath, n)],
+		}
+		if ext == ".a" {
+			lib.Kind = "archive"
+		}
+		libs = append(libs, lib)
+	}
+	return libs, nil
+}
+
+// dependencyGraph returns the dependencies in the cm.lock in dir, each with the dependencies in its own cm.lock
+func dependencyGraph(dir string) ([]DependencyNode, error) {
+	l, err := ReadLock(dir)
+	if err != nil {
+		return nil, fmt.Errorf("could not read %s: %w", LockFile, err)
+	}
+	nodes := make([]DependencyNode, 0, len(l.Dependencies))
+	for _, d := range l.Dependencies {
+		dep := filepath.Join(dir, VendorDir, d.Name)
+		n := DependencyNode{Dependency: d, Dir: dep, Vendored: isDir(dep), Dependencies: make([]DependencyNode, 0)}
+		if n.Vendored {
+			n.IncludeDir = dependencyInclude(dep)
+			n.Libraries, _ = filepath.Glob(filepath.Join(dep, "bin", "lib*.so"))
+			if n.Dependencies, err = dependencyGraph(dep); err != nil {
+				return nil, fmt.Errorf("%s: %w", d.Name, err)
+			}
+		}
+		nodes = append(nodes, n)
+	}
+	return nodes, nil
+}
@@ -0,0 +1,127 @@
+package cm
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestList(t *testing.T) {
+	dir := planFixture(t)
+	writeFiles(t, dir, map[string]string{
+		"src/util.hpp":                          "",
+		"include/app/api.h":                     "",
+		"third_party/json/bin/libjson.so":       "",
+		"third_party/json/cm.lock":              `{"dependencies": [{"name": "utf8", "url": "u", "commit": "c"}]}`,
+		"third_party/json/third_party/utf8/a.h": "",
+	})
+	p := &Project{Dir: dir, Name: "app", Config: Config{Version: "1.0.0"}}
+	l, err := p.List(BuildOptions{Compiler: "g++", Extra: []string{"-g"}}, TestOptions{Args: []string{"-DTESTING"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rels := func(paths []string) []string {
+		res := make([]string, 0, len(paths))
+		for _, path := range paths {
+			res = append(res, relPath(dir, path))
+		}
+		return res
+	}
+	if l.Name != "app" || l.Type != "app" || l.Version != "1.0.0" || l.Compiler != "g++" ||
+		!reflect.DeepEqual(l.Flags, []string{"-std=c++2a", "-Wall", "-O0", "-g"}) {
+		t.Errorf("List() = %+v", l)
+	}
+	files := []struct {
+		name      string
+		got, want []string
+	}{
+		{"sources", rels(l.Sources), []string{"src/main.cpp", "src/util.cpp"}},
+		{"main", rels([]string{l.Main}), []string{"src/main.cpp"}},
+		{"headers", rels(l.Headers), []string{"src/util.hpp", "include/app/api.h"}},
+		{"tests", rels(l.Tests), []string{"tests/main_test.cpp", "tests/util_test.cpp"}},
+		{"commands", rels(l.Commands), []string{"cmd/tool"}},
+	}
+	for _, f := range files {
+		if !reflect.DeepEqual(f.got, f.want) {
+			t.Errorf("List() %s = %q, want %q", f.name, f.got, f.want)
+		}
+	}
+
+	targets := make([]string, 0, len(l.Targets))
+	for _, tg := range l.Targets {
+		targets = append(targets, tg.Kind+" "+tg.Name)
+	}
+	if want := []string{"binary app", "command tool", "tests app"}; !reflect.DeepEqual(targets, want) {
+		t.Fatalf("List() targets = %q, want %q", targets, want)
+	}
+	if tests := l.Targets[2]; !contains(tests.Flags, "-DTESTING") || !contains(tests.Libs, "json") {
+		t.Errorf("tests target = %+v, want the framework args and the dependency's library", tests)
+	}
+	want := []Library{
+		{Name: "hello", Path: dir + "/lib/libhello.so", Kind: "shared", Go: dir + "/lib"},
+		{Name: "shout", Path: dir + "/lib/libshout.so", Kind: "shared"},
+	}
+	if !reflect.DeepEqual(l.Libraries, want) {
+		t.Errorf("List() libraries = %+v, want %+v", l.Libraries, want)
+	}
+
+	if len(l.Dependencies) != 1 {
+		t.Fatalf("List() dependencies = %+v", l.Dependencies)
+	}
+	json := l.Dependencies[0]
+	if json.Name != "json" || !json.Vendored || json.IncludeDir != dir+"/third_party/json/include" ||
+		!reflect.DeepEqual(json.Libraries, []string{dir + "/third_party/json/bin/libjson.so"}) {
+		t.Errorf("json dependency = %+v", json)
+	}
+	if len(json.Dependencies) != 1 || json.Dependencies[0].Name != "utf8" || !json.Dependencies[0].Vendored ||
+		json.Dependencies[0].IncludeDir != dir+"/third_party/json/third_party/utf8" {
+		t.Errorf("dependencies of json = %+v", json.Dependencies)
+	}
+}
+
+func TestListHeaderOnly(t *testing.T) {
+	dir := tempProject(t)
+	writeFiles(t, dir, map[string]string{"include/json/json.hpp": ""})
+	l, err := (&Project{Dir: dir, Name: "json", Config: Config{Type: "header-only"}}).List(BuildOptions{}, TestOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(l.Sources) != 0 || len(l.Tests) != 0 || len(l.Targets) != 0 || len(l.Libraries) != 0 ||
+		len(l.Dependencies) != 0 || l.Main != "" || len(l.Headers) != 1 {
+		t.Errorf("List() of a header-only project = %+v", l)
+	}
+}
+
+func TestListUnvendored(t *testing.T) {
+	dir := planFixture(t)
+	writeFiles(t, dir, map[string]string{"cm.lock": `{"dependencies": [{"name": "json"}, {"name": "fmt"}]}`})
+	p := &Project{Dir: dir, Name: "app"}
+	if _, err := p.PlanBuild(BuildOptions{}); err == nil {
+		t.Fatal("PlanBuild() with a dependency that is not vendored did not fail")
+	}
+	l, err := p.List(BuildOptions{}, TestOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vendored := make([]string, 0, len(l.Dependencies))
+	for _, d := range l.Dependencies {
+		vendored = append(vendored, fmt.Sprintf("%s %v", d.Name, d.Vendored))
+	}
+	if want := []string{"json true", "fmt false"}; !reflect.DeepEqual(vendored, want) {
+		t.Errorf("List() dependencies = %q, want %q", vendored, want)
+	}
+	if len(l.Targets) != 3 {
+		t.Fatalf("List() targets = %+v", l.Targets)
+	}
+	for _, tg := range l.Targets {
+		if want := dir + "/third_party/json/include"; !contains(tg.IncludeDirs, want) {
+			t.Errorf("%s target include dirs = %q, want %s", tg.Name, tg.IncludeDirs, want)
+		}
+		for _, inc := range tg.IncludeDirs {
+			if strings.Contains(inc, "third_party/fmt") {
+				t.Errorf("%s target includes the missing dependency: %q", tg.Name, tg.IncludeDirs)
+			}
+		}
+	}
+}
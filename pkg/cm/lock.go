@@ -0,0 +1,94 @@
+package cm
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// LockFile records the exact commit of every dependency, and VendorDir is where dependencies are checked out
+const (
+	LockFile  = "cm.lock"
+	VendorDir = "third_party"
+)
+
+// Dependency is a git repository the project depends on, as recorded in cm.lock
+type Dependency struct {
+	Name string `json:"name"`
+	// URL is anything git can clone, including the path of a local (bare) repository
+	URL string `json:"url"`
+	// Version is the tag, branch or commit that was asked for; empty means the default branch
+	Version string `json:"version,omitempty"`
+	// Commit is the commit Version resolved to, which is what gets checked out
+	Commit string `json:"commit"`
+}
+
+// Lock is the JSON structure of cm.lock
+type Lock struct {
+	Dependencies []Dependency `json:"dependencies"`
+}
+
+// ReadLock reads cm.lock from the given dir. A missing file is an empty lock.
+func ReadLock(dir string) (Lock, error) {
+	var l Lock
+	data, err := ioutil.ReadFile(filepath.Join(dir, LockFile))
+	if os.IsNotExist(err) {
+		return l, nil
+	} else if err != nil {
+		return l, err
+	}
+	return l, json.Unmarshal(data, &l)
+}
+
+// WriteLock writes cm.lock to the given dir, with the dependencies sorted by name so the file diffs well
+func WriteLock(dir string, l Lock) error {
+	sort.Slice(l.Dependencies, func(i, j int) bool { return l.Dependencies[i].Name < l.Dependencies[j].Name })
+	data, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, LockFile), append(data, '\n'), 0664)
+}
+
+// DependencyArgs returns the include and library args of the vendored dependencies in the project's cm.lock
+func (o BuildOptions) DependencyArgs(dir string) ([]string, error) {
+	l, err := ReadLock(dir)
+	if err != nil {
+		return nil, fmt.Errorf("could not read %s: %w", LockFile, err)
+	}
+	args := make([]string, 0)
+	for _, d := range l.Dependencies {
+		dep := filepath.Join(dir, VendorDir, d.Name)
+		if _, err := os.Stat(dep); err != nil {
+			if o.skipMissingDeps {
+				continue
+			}
+			return nil, fmt.Errorf("%s is not in %s/, run cm get", d.Name, VendorDir)
+		}
+		args = append(args, "-I"+dependencyInclude(dep))
+
+		bin := filepath.Join(dep, "bin")
+		libs, _ := filepath.Glob(filepath.Join(bin, "lib*.so"))
+		if len(libs) > 0 {
+			args = append(args, "-L"+bin, o.rpathArg(bin))
+		}
+		for _, l := range libs {
+			args = append(args, "-l"+strings.TrimSuffix(strings.TrimPrefix(filepath.Base(l), "lib"), ".so"))
+		}
+	}
+	return args, nil
+}
+
+// dependencyInclude returns the dir a dependency's headers are included from: include/, src/ or its root
+func dependencyInclude(dep string) string {
+	for _, dir := range []string{"include", "src"} {
+		if isDir(filepath.Join(dep, dir)) {
+			return filepath.Join(dep, dir)
+		}
+	}
+	return dep
+}
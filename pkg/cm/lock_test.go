@@ -0,0 +1,71 @@
+package cm
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLock(t *testing.T) {
+	target := tempProject(t)
+	if l, err := ReadLock(target); err != nil || len(l.Dependencies) != 0 {
+		t.Errorf("ReadLock() without cm.lock = %+v, %v", l, err)
+	}
+	l := Lock{Dependencies: []Dependency{{Name: "zlib", URL: "z", Commit: "1"}, {Name: "fmt", URL: "f", Commit: "2"}}}
+	if err := WriteLock(target, l); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadLock(target)
+	want := []Dependency{{Name: "fmt", URL: "f", Commit: "2"}, {Name: "zlib", URL: "z", Commit: "1"}}
+	if err != nil || !reflect.DeepEqual(got.Dependencies, want) {
+		t.Errorf("ReadLock() = %+v, %v, want %+v", got.Dependencies, err, want)
+	}
+}
+
+func TestDependencyArgs(t *testing.T) {
+	target := tempProject(t)
+	writeFiles(t, target, map[string]string{
+		"cm.lock":                            `{"dependencies": [{"name": "fmt"}, {"name": "greet"}, {"name": "json"}]}`,
+		"third_party/fmt/include/fmt/core.h": "",
+		"third_party/fmt/src/format.cc":      "",
+		"third_party/greet/src/greet.hpp":    "",
+		"third_party/greet/bin/libgreet.so":  "",
+		"third_party/greet/bin/greet":        "",
+		"third_party/json/json.hpp":          "",
+	})
+	dir := filepath.Join(target, "third_party")
+	tests := []struct {
+		name  string
+		rpath string
+		want  []string
+	}{
+		{"build", "", []string{
+			"-I" + dir + "/fmt/include",
+			"-I" + dir + "/greet/src", "-L" + dir + "/greet/bin", "-Wl,-rpath," + dir + "/greet/bin", "-lgreet",
+			"-I" + dir + "/json",
+		}},
+		{"install", "$ORIGIN/../lib", []string{
+			"-I" + dir + "/fmt/include",
+			"-I" + dir + "/greet/src", "-L" + dir + "/greet/bin", "-Wl,-rpath,$ORIGIN/../lib", "-lgreet",
+			"-I" + dir + "/json",
+		}},
+	}
+	for _, tt := range tests {
+		got, err := BuildOptions{RPath: tt.rpath}.DependencyArgs(target)
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("DependencyArgs() for %s = %q, %v, want %q", tt.name, got, err, tt.want)
+		}
+	}
+	if got, err := (BuildOptions{}).DependencyArgs(tempProject(t)); err != nil || len(got) != 0 {
+		t.Errorf("DependencyArgs() without cm.lock = %q, %v", got, err)
+	}
+	os.RemoveAll(filepath.Join(dir, "json"))
+	if _, err := (BuildOptions{}).DependencyArgs(target); err == nil {
+		t.Error("DependencyArgs() with a dependency missing from third_party/ did not fail")
+	}
+	writeFiles(t, target, map[string]string{"cm.lock": "{"})
+	if _, err := (BuildOptions{}).DependencyArgs(target); err == nil {
+		t.Error("DependencyArgs() with a corrupt cm.lock did not fail")
+	}
+}
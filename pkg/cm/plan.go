@@ -0,0 +1,353 @@
+package cm
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// The kinds of steps a build plan is made of
+const (
+	// StepGoLib builds a Go package in lib/ into a library
+	StepGoLib = "go-lib"
+	// StepHarness writes a test framework file into tests/, which is removed again once the build is done
+	StepHarness = "harness"
+	// StepCompile compiles and links sources into a binary or library
+	StepCompile = "compile"
+	// StepSymlink links a library's soname to the library
+	StepSymlink = "symlink"
+	// StepInstallName fixes up the install name of a library on macOS
+	StepInstallName = "install-name"
+)
+
+// Step is one thing a build does
+type Step struct {
+	Kind string `json:"kind"`
+	// Description says what the step does, for people
+	Description string `json:"description"`
+	// Command is the command the step runs, if any, and Dir the dir it runs in (the working dir if empty)
+	Command []string `json:"command,omitempty"`
+	Dir     string   `json:"dir,omitempty"`
+	// Output is the file the step writes, and Target what a symlink points to
+	Output string `json:"output,omitempty"`
+	Target string `json:"target,omitempty"`
+	// Sources, Flags, IncludeDirs, LibDirs and Libs break a compile command down
+	Sources     []string `json:"sources,omitempty"`
+	Flags       []string `json:"flags,omitempty"`
+	IncludeDirs []string `json:"include_dirs,omitempty"`
+	LibDirs     []string `json:"lib_dirs,omitempty"`
+	Libs        []string `json:"libs,omitempty"`
+	// UpToDate marks a Go library that will not be rebuilt, as its sources have not changed
+	UpToDate bool `json:"up_to_date,omitempty"`
+
+	// hash is what a Go library is built from, and data the contents of a harness file
+	hash string
+	data []byte
+}
+
+// Plan is everything a build will do, in order. Planning resolves the sources, flags and libraries of every step
+// without running or writing anything.
+type Plan struct {
+	Project string `json:"project"`
+	Dir     string `json:"dir"`
+	Type    string `json:"type"`
+	// Tests is true for the plan of a test build
+	Tests bool `json:"tests"`
+	// Binary is the project's binary, library or test binary, and Commands the binaries built from cmd/
+	Binary   string   `json:"binary,omitempty"`
+	Commands []string `json:"commands,omitempty"`
+	Steps    []Step   `json:"steps"`
+}
+
+// compileStep returns the step compiling the sources into the binary, with the language flags before them and the
+// given args after them
+func (o BuildOptions) compileStep(description, binary string, sources, args []string) Step {
+	command := append([]string{o.Compiler}, o.LanguageFlags()...)
+	command = append(command, "-o"+binary)
+	command = append(command, sources...)
+	command = append(command, args...)
+	s := Step{Kind: StepCompile, Description: description, Command: command, Output: binary}
+	s.Sources = append(s.Sources, sources...)
+	for _, a := range append(o.LanguageFlags(), args...) {
+		switch {
+		case strings.HasPrefix(a, "-I"):
+			s.IncludeDirs = append(s.IncludeDirs, strings.TrimPrefix(a, "-I"))
+		case strings.HasPrefix(a, "-L"):
+			s.LibDirs = append(s.LibDirs, strings.TrimPrefix(a, "-L"))
+		case strings.HasPrefix(a, "-l"):
+			s.Libs = append(s.Libs, strings.TrimPrefix(a, "-l"))
+		case strings.HasPrefix(a, "-"):
+			s.Flags = append(s.Flags, a)
+		default:
+			s.Sources = append(s.Sources, a)
+		}
+	}
+	return s
+}
+
+// outputs returns the outputs of the plan's steps of the given kind
+func (plan *Plan) outputs(kind string) []string {
+	res := make([]string, 0)
+	for _, s := range plan.Steps {
+		if s.Kind == kind {
+			res = append(res, s.Output)
+		}
+	}
+	return res
+}
+
+// isSource reports whether path is a translation unit cm compiles
+func isSource(path string) bool {
+	for _, g := range SourceGlobs {
+		if matched, _ := filepath.Match(g, filepath.Base(path)); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// contains reports whether the paths include path
+func contains(paths []string, path string) bool {
+	for _, p := range paths {
+		if p == path {
+			return true
+		}
+	}
+	return false
+}
+
+// harnessSteps returns the steps writing the harness files, sorted by path
+func harnessSteps(harness map[string][]byte) []Step {
+	paths := make([]string, 0, len(harness))
+	for path := range harness {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	steps := make([]Step, 0, len(paths))
+	for _, path := range paths {
+		steps = append(steps, Step{
+			Kind:        StepHarness,
+			Description: "write " + filepath.Base(path) + " for the test framework",
+			Output:      path,
+			data:        harness[path],
+		})
+	}
+	return steps
+}
+
+// execute runs the steps of the plan in order, stopping at the first that fails. Harness files are removed again
+// whether the build succeeds or not.
+func (p *Project) execute(o BuildOptions, plan *Plan) error {
+	o = o.withDefaults()
+	defer func() {
+		for _, s := range plan.Steps {
+			if s.Kind == StepHarness {
+				os.Remove(s.Output)
+			}
+		}
+	}()
+	for _, s := range plan.Steps {
+		if err := p.executeStep(o, s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// executeStep runs a single step of a plan, recording it in the trace
+func (p *Project) executeStep(o BuildOptions, s Step) error {
+	rel := relPath(p.Dir, s.Output)
+	span := o.Trace.Start(s.Kind, s.Description)
+	defer span.End()
+	switch s.Kind {
+	case StepGoLib:
+		if s.UpToDate {
+			span.Arg("up_to_date", true)
+			o.Log.Printf("%s is up to date", rel)
+			return nil
+		}
+		o.Log.Printf("building %s from Go...", rel)
+		if out, err := o.run(span, s.Dir, s.Command[0], s.Command[1:]...); err != nil {
+			return fmt.Errorf("could not build %s: %v\n%s", rel, err, out)
+		}
+		return p.recordGoLib(s.Output, s.hash)
+	case StepHarness:
+		span.Arg("bytes", len(s.data))
+		return ioutil.WriteFile(s.Output, s.data, 0664)
+	case StepCompile:
+		o.Log.Printf("compiling %s...", rel)
+		var err error
+		if o.Trace != nil {
+			err = p.compileTraced(o, s)
+		} else {
+			err = o.compile(span, s.Dir, s.Command[1:])
+		}
+		if err != nil {
+			return err
+		}
+		o.Log.Println("🎉 compilation succeeded with no errors")
+	case StepSymlink:
+		os.Remove(s.Output)
+		if err := os.Symlink(s.Target, s.Output); err != nil {
+			return fmt.Errorf("could not link %s: %w", rel, err)
+		}
+	case StepInstallName:
+		if out, err := o.run(span, s.Dir, s.Command[0], s.Command[1:]...); err != nil {
+			o.Log.Printf("error with mac rpath tool: %v\n%s", err, out)
+			return nil
+		}
+		o.Log.Println("🎉 dynamic linking succeeded with no errors")
+	}
+	return nil
+}
+
+// argUse is where an arg of a compile step goes when compileTraced splits the step into compiles and a link
+type argUse int
+
+const (
+	useBoth argUse = iota
+	useCompile
+	useLink
+)
+
+// separateValueFlags are the flags that take their value as the next arg, and where the pair of args goes
+var separateValueFlags = map[string]argUse{
+	"-o":         useLink,
+	"-L":         useLink,
+	"-l":         useLink,
+	"-Xlinker":   useLink,
+	"-x":         useCompile,
+	"-I":         useBoth,
+	"-D":         useBoth,
+	"-U":         useBoth,
+	"-include":   useBoth,
+	"-imacros":   useBoth,
+	"-isystem":   useBoth,
+	"-iquote":    useBoth,
+	"-idirafter": useBoth,
+	"-Xclang":    useBoth,
+}
+
+// useOf returns where a single arg of a compile step goes when compileTraced splits the step
+func useOf(arg string) argUse {
+	switch {
+	case strings.HasPrefix(arg, "-o"), strings.HasPrefix(arg, "-L"), strings.HasPrefix(arg, "-l"),
+		strings.HasPrefix(arg, "-Wl,"), arg == "-shared", !strings.HasPrefix(arg, "-"):
+		return useLink
+	case strings.HasPrefix(arg, "-x"):
+		// the language would apply to the objects of the link too
+		return useCompile
+	}
+	return useBoth
+}
+
+// compileTraced runs a compile step as a compile per translation unit and a link, so each shows up in the trace
+func (p *Project) compileTraced(o BuildOptions, s Step) error {
+	objects, err := ioutil.TempDir("", "cm-objects")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(objects)
+	units := make([]string, 0)
+	cArgs := make([]string, 0)
+	link := make([]string, 0)
+	args := s.Command[1:]
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		if isSource(a) && contains(s.Sources, a) {
+			units = append(units, a)
+			link = append(link, filepath.Join(objects, fmt.Sprintf("%d_%s.o", len(units), filepath.Base(a))))
+			continue
+		}
+		arg := []string{a}
+		use, ok := separateValueFlags[a]
+		if ok && i+1 < len(args) {
+			i++
+			arg = append(arg, args[i])
+		} else {
+			use = useOf(a)
+		}
+		if use != useLink {
+			cArgs = append(cArgs, arg...)
+		}
+		if use != useCompile {
+			link = append(link, arg...)
+		}
+	}
+	for i, u := range units {
+		span := o.Trace.Start("compile", "compile "+relPath(p.Dir, u)).Arg("source", u)
+		obj := filepath.Join(objects, fmt.Sprintf("%d_%s.o", i+1, filepath.Base(u)))
+		err := o.compile(span, s.Dir, append(append([]string{}, cArgs...), "-c", u, "-o", obj))
+		span.End()
+		if err != nil {
+			return err
+		}
+	}
+	span := o.Trace.Start("link", "link "+relPath(p.Dir, s.Output)).Arg("objects", len(units))
+	defer span.End()
+	return o.compile(span, s.Dir, link)
+}
+
+// relPath returns path relative to dir, if it is inside it
+func relPath(dir, path string) string {
+	if rel, err := filepath.Rel(dir, path); err == nil && !strings.HasPrefix(rel, "..") {
+		return rel
+	}
+	return path
+}
+
+// String describes the plan for people, with paths relative to the project dir
+func (plan *Plan) String() string {
+	var b strings.Builder
+	kind := "build"
+	if plan.Tests {
+		kind = "test build"
+	}
+	fmt.Fprintf(&b, "%s plan for %s (%s) in %s\n", kind, plan.Project, plan.Type, plan.Dir)
+	if len(plan.Steps) == 0 {
+		b.WriteString("\nnothing to do\n")
+	}
+	rels := func(paths []string) string {
+		res := make([]string, 0, len(paths))
+		for _, path := range paths {
+			res = append(res, relPath(plan.Dir, path))
+		}
+		return strings.Join(res, " ")
+	}
+	for i, s := range plan.Steps {
+		note := ""
+		if s.UpToDate {
+			note = " (up to date)"
+		}
+		fmt.Fprintf(&b, "\n%d. %s: %s%s\n", i+1, s.Kind, s.Description, note)
+		fields := []struct {
+			name   string
+			values []string
+		}{
+			{"sources", s.Sources},
+			{"flags", s.Flags},
+			{"includes", s.IncludeDirs},
+			{"lib dirs", s.LibDirs},
+			{"libs", s.Libs},
+		}
+		for _, f := range fields {
+			if len(f.values) > 0 && (s.Kind == StepCompile || f.name == "sources") {
+				fmt.Fprintf(&b, "   %-9s %s\n", f.name+":", rels(f.values))
+			}
+		}
+		if s.Kind == StepSymlink {
+			fmt.Fprintf(&b, "   %s -> %s\n", relPath(plan.Dir, s.Output), s.Target)
+		}
+		if len(s.Command) > 0 {
+			cd := ""
+			if s.Dir != "" && s.Dir != plan.Dir {
+				cd = "cd " + relPath(plan.Dir, s.Dir) + " && "
+			}
+			fmt.Fprintf(&b, "   $ %s%s\n", cd, strings.Join(s.Command, " "))
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,296 @@
+package cm
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// planFixture returns a project with a main binary, a command in cmd/, a prebuilt library and a Go library in lib/
+// and a vendored dependency
+func planFixture(t *testing.T) string {
+	dir := tempProject(t)
+	writeFiles(t, dir, map[string]string{
+		"src/main.cpp":                     "int main() {}\n",
+		"src/util.cpp":                     "int util() { return 1; }\n",
+		"cmd/tool/tool.cpp":                "int main() {}\n",
+		"lib/libshout.so":                  "",
+		"lib/hello_cgo.go":                 "package main\n\nimport \"C\"\n",
+		"tests/util_test.cpp":              "",
+		"tests/main_test.cpp":              "",
+		"cm.lock":                          `{"dependencies": [{"name": "json"}]}`,
+		"third_party/json/include/json.hh": "",
+	})
+	return dir
+}
+
+// stepKinds returns the kinds of the plan's steps, in order
+func stepKinds(plan *Plan) []string {
+	kinds := make([]string, 0, len(plan.Steps))
+	for _, s := range plan.Steps {
+		kinds = append(kinds, s.Kind)
+	}
+	return kinds
+}
+
+func TestPlanBuild(t *testing.T) {
+	if runtime.GOOS == "darwin" {
+		t.Skip("plans install names on macOS")
+	}
+	dir := planFixture(t)
+	lib, bin, json := dir+"/lib", dir+"/bin", dir+"/third_party/json/include"
+	link := []string{"-I" + json, "-I" + dir + "/src", "-L" + lib, "-lhello", "-lshout", "-Wl,-rpath," + lib}
+	tests := []struct {
+		name     string
+		config   Config
+		o        BuildOptions
+		kinds    []string
+		binary   string
+		commands []string
+		// main is the command compiling the main binary
+		main []string
+	}{
+		{
+			"app", Config{}, BuildOptions{Compiler: "g++"},
+			[]string{StepGoLib, StepCompile, StepCompile}, bin + "/app", []string{bin + "/tool"},
+			append([]string{"g++", "-std=c++2a", "-Wall", "-O0", "-o" + bin + "/app", dir + "/src/main.cpp",
+				dir + "/src/util.cpp"}, link...),
+		},
+		{
+			"installed lib", Config{Type: "lib", Version: "2.0.1"},
+			BuildOptions{Std: "c++17", Optimize: true, OutputDir: "/stage", RPath: "$ORIGIN/../lib", Extra: []string{"-g"}},
+			[]string{StepGoLib, StepCompile, StepSymlink, StepCompile}, "/stage/libapp.so", []string{"/stage/tool"},
+			[]string{"clang++", "-std=c++17", "-Wall", "-O3", "-o/stage/libapp.so", dir + "/src/main.cpp",
+				dir + "/src/util.cpp", "-g", "-I" + json, "-I" + dir + "/src", "-L" + lib, "-lhello", "-lshout",
+				"-Wl,-rpath,$ORIGIN/../lib", "-shared", "-fPIC", "-Wl,-soname,libapp.so.2"},
+		},
+		{"header-only", Config{Type: "header-only"}, BuildOptions{}, []string{}, "", nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Project{Dir: dir, Name: "app", Config: tt.config}
+			plan, err := p.PlanBuild(tt.o)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := stepKinds(plan); !reflect.DeepEqual(got, tt.kinds) {
+				t.Fatalf("steps = %q, want %q", got, tt.kinds)
+			}
+			if plan.Binary != tt.binary || !reflect.DeepEqual(plan.Commands, tt.commands) {
+				t.Errorf("binaries = %s and %q, want %s and %q", plan.Binary, plan.Commands, tt.binary, tt.commands)
+			}
+			if len(plan.Steps) == 0 {
+				return
+			}
+			golib := plan.Steps[0]
+			if golib.Output != lib+"/libhello.so" || golib.Dir != lib || golib.UpToDate ||
+				strings.Join(golib.Command, " ") != "go build -buildmode=c-shared -o "+lib+"/libhello.so hello_cgo.go" {
+				t.Errorf("Go library step = %+v", golib)
+			}
+			if main := plan.Steps[1]; !reflect.DeepEqual(main.Command, tt.main) || main.Output != tt.binary {
+				t.Errorf("main compile step = %q writing %s, want %q", main.Command, main.Output, tt.main)
+			}
+			if tt.config.Type == "lib" {
+				if s := plan.Steps[2]; s.Output != "/stage/libapp.so.2" || s.Target != "libapp.so" {
+					t.Errorf("symlink step = %+v, want /stage/libapp.so.2 -> libapp.so", s)
+				}
+			}
+			tool := plan.Steps[len(plan.Steps)-1]
+			if want := []string{dir + "/cmd/tool/tool.cpp", dir + "/src/util.cpp"}; !reflect.DeepEqual(tool.Sources,
+				want) {
+				t.Errorf("cmd/tool sources = %q, want %q", tool.Sources, want)
+			}
+			if want := []string{"hello", "shout"}; !reflect.DeepEqual(tool.Libs, want) {
+				t.Errorf("cmd/tool libs = %q, want %q", tool.Libs, want)
+			}
+		})
+	}
+
+	for _, path := range []string{bin, dir + "/.cm", lib + "/libhello.so"} {
+		if _, err := os.Stat(path); err == nil {
+			t.Errorf("planning wrote %s", path)
+		}
+	}
+}
+
+func TestPlanBuildErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		files  map[string]string
+		config Config
+	}{
+		{"a dependency that is not vendored", map[string]string{"cm.lock": `{"dependencies": [{"name": "x"}]}`},
+			Config{}},
+		{"an unknown Go build mode", map[string]string{"lib/a_cgo.go": "import \"C\"\n"}, Config{GoBuildMode: "plugin"}},
+		{"a corrupt Go library record", map[string]string{"lib/a_cgo.go": "import \"C\"\n", ".cm/golibs.json": "{"},
+			Config{}},
+	}
+	for _, tt := range tests {
+		dir := tempProject(t)
+		writeFiles(t, dir, tt.files)
+		if _, err := (&Project{Dir: dir, Name: "app", Config: tt.config}).PlanBuild(BuildOptions{}); err == nil {
+			t.Errorf("PlanBuild() with %s did not fail", tt.name)
+		}
+	}
+}
+
+func TestPlanTests(t *testing.T) {
+	dir := planFixture(t)
+	p := &Project{Dir: dir, Name: "app"}
+	harness := map[string][]byte{dir + "/tests/test_main.cpp": []byte("main"), dir + "/tests/catch.hpp": nil}
+	tests := []struct {
+		name    string
+		t       TestOptions
+		kinds   []string
+		sources []string
+	}{
+		{
+			"every test", TestOptions{Harness: harness},
+			[]string{StepHarness, StepHarness, StepGoLib, StepCompile},
+			[]string{"tests/main_test.cpp", "tests/util_test.cpp", "tests/test_main.cpp", "src/util.cpp"},
+		},
+		{
+			"selected files", TestOptions{Harness: harness, Files: []string{"util_*"}},
+			[]string{StepHarness, StepHarness, StepGoLib, StepCompile},
+			[]string{"tests/util_test.cpp", "tests/test_main.cpp", "src/util.cpp"},
+		},
+		{
+			"external framework", TestOptions{Args: []string{"-DEXTERNAL"}},
+			[]string{StepGoLib, StepCompile},
+			[]string{"tests/main_test.cpp", "tests/util_test.cpp", "src/util.cpp"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan, err := p.PlanTests(BuildOptions{}, tt.t)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := stepKinds(plan); !reflect.DeepEqual(got, tt.kinds) {
+				t.Fatalf("steps = %q, want %q", got, tt.kinds)
+			}
+			if !plan.Tests || plan.Binary != dir+"/tests/app" {
+				t.Errorf("plan of a test build = %+v", plan)
+			}
+			if len(tt.t.Harness) > 0 {
+				if first := plan.Steps[0].Output; first != dir+"/tests/catch.hpp" {
+					t.Errorf("harness steps are not sorted by path: %s first", first)
+				}
+			}
+			compile := plan.Steps[len(plan.Steps)-1]
+			sources := make([]string, 0, len(compile.Sources))
+			for _, s := range compile.Sources {
+				sources = append(sources, relPath(dir, s))
+			}
+			if !reflect.DeepEqual(sources, tt.sources) {
+				t.Errorf("sources = %q, want %q", sources, tt.sources)
+			}
+			if want := []string{dir + "/src", dir + "/third_party/json/include", dir + "/tests"}; !reflect.DeepEqual(
+				compile.IncludeDirs, want) {
+				t.Errorf("include dirs = %q, want %q", compile.IncludeDirs, want)
+			}
+			for _, a := range tt.t.Args {
+				if !contains(compile.Flags, a) {
+					t.Errorf("flags %q do not include %s", compile.Flags, a)
+				}
+			}
+		})
+	}
+	if _, err := os.Stat(dir + "/tests/catch.hpp"); err == nil {
+		t.Error("planning wrote the harness")
+	}
+	if _, err := p.PlanTests(BuildOptions{}, TestOptions{Files: []string{"missing_test.cpp"}}); err == nil {
+		t.Error("PlanTests() selecting no test files did not fail")
+	}
+}
+
+func TestPlanJSON(t *testing.T) {
+	dir := planFixture(t)
+	plan, err := (&Project{Dir: dir, Name: "app"}).PlanBuild(BuildOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(plan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded struct {
+		Project string
+		Type    string
+		Tests   bool
+		Binary  string
+		Steps   []map[string]interface{}
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Project != "app" || decoded.Type != "app" || decoded.Tests || decoded.Binary != filepath.Join(dir,
+		"bin", "app") || len(decoded.Steps) != 3 {
+		t.Fatalf("plan JSON = %s", data)
+	}
+	compile := decoded.Steps[1]
+	for _, key := range []string{"kind", "description", "command", "output", "sources", "flags", "include_dirs",
+		"lib_dirs", "libs"} {
+		if _, ok := compile[key]; !ok {
+			t.Errorf("compile step has no %q in JSON: %v", key, compile)
+		}
+	}
+	for _, key := range []string{"up_to_date", "target", "hash", "data"} {
+		if _, ok := compile[key]; ok {
+			t.Errorf("compile step has %q in JSON: %v", key, compile)
+		}
+	}
+}
+
+func TestPlanFile(t *testing.T) {
+	plan := BuildOptions{Compiler: "g++", Std: "c++17"}.PlanFile("/p/a.cpp", "/cache/a", "/p/b.cpp", "-lm")
+	data, err := json.Marshal(plan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Plan
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	want := Plan{Project: "a", Dir: "/p", Type: "app", Binary: "/cache/a", Steps: []Step{{
+		Kind:        StepCompile,
+		Description: "compile and link a.cpp",
+		Command:     []string{"g++", "-std=c++17", "-Wall", "-O0", "-o/cache/a", "/p/a.cpp", "/p/b.cpp", "-lm"},
+		Output:      "/cache/a",
+		Sources:     []string{"/p/a.cpp", "/p/b.cpp"},
+		Flags:       []string{"-std=c++17", "-Wall", "-O0"},
+		Libs:        []string{"m"},
+	}}}
+	if !reflect.DeepEqual(decoded, want) {
+		t.Errorf("PlanFile() as JSON = %s\nwant %+v", data, want)
+	}
+}
+
+func TestPlanString(t *testing.T) {
+	dir := planFixture(t)
+	plan, err := (&Project{Dir: dir, Name: "app", Config: Config{Type: "lib", Version: "1.0"}}).PlanBuild(
+		BuildOptions{Compiler: "g++"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := plan.String()
+	for _, want := range []string{
+		"build plan for app (lib) in " + dir + "\n",
+		"\n1. go-lib: build lib/libhello.so from Go\n   sources:  lib/hello_cgo.go\n   $ cd lib && go build",
+		"\n2. compile: compile and link the shared library libapp.so\n   sources:  src/main.cpp src/util.cpp\n",
+		"   libs:     hello shout\n",
+		"\n3. symlink: link the soname libapp.so.1 to the library\n   bin/libapp.so.1 -> libapp.so\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Plan.String() does not contain %q:\n%s", want, got)
+		}
+	}
+	empty := &Plan{Project: "json", Dir: "/p", Type: "header-only", Tests: true}
+	if got, want := empty.String(), "test build plan for json (header-only) in /p\n\nnothing to do\n"; got != want {
+		t.Errorf("Plan.String() of an empty plan = %q, want %q", got, want)
+	}
+}
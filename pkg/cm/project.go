@@ -0,0 +1,348 @@
+// Package cm builds and tests C++ projects laid out the way the cm command expects: sources in src/, tests in tests/,
+// libraries in lib/, extra binaries in cmd/, dependencies in cm.lock and an optional cm.json. It is what the cm command
+// itself runs on, for tools that want to drive builds programmatically. Nothing in the package reads flags, changes
+// the working dir or exits, so different projects can be built concurrently.
+package cm
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	// registers the files embedded in cm, such as the catch2 header
+	_ "github.com/damienstanton/cm/statik"
+	"github.com/rakyll/statik/fs"
+)
+
+// Project is a cm project on disk
+type Project struct {
+	// Dir is the project root
+	Dir string
+	// Name is the name of the binary the project builds, or of the library for lib projects (lib<Name>.so)
+	Name   string
+	Config Config
+}
+
+// Open loads the project rooted at dir and its cm.json, naming it after the dir unless cm.json names it
+func Open(dir string) (*Project, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	c, err := LoadConfig(abs)
+	if err != nil {
+		return nil, err
+	}
+	name := c.Name
+	if name == "" {
+		name = filepath.Base(abs)
+	}
+	return &Project{Dir: abs, Name: name, Config: c}, nil
+}
+
+// Soname returns the soname of the library the project builds, lib<name>.so.<major>, if cm.json gives a version
+func (p *Project) Soname() string {
+	if p.Config.Version == "" || runtime.GOOS == "darwin" {
+		return ""
+	}
+	return "lib" + p.Name + ".so." + strings.SplitN(p.Config.Version, ".", 2)[0]
+}
+
+// BuildResult lists the binaries a build produced
+type BuildResult struct {
+	// Binary is the project's binary, or its library for lib projects; it is empty if there was nothing to build
+	Binary string
+	// Commands are the binaries built from the dirs in cmd/
+	Commands []string
+}
+
+// Build compiles the project's binary or library and every cmd/ binary, running exactly the steps of PlanBuild
+func (p *Project) Build(o BuildOptions) (*BuildResult, error) {
+	plan, err := p.PlanBuild(o)
+	if err != nil {
+		return &BuildResult{}, err
+	}
+	if err := p.execute(o, plan); err != nil {
+		return &BuildResult{}, err
+	}
+	return &BuildResult{Binary: plan.Binary, Commands: plan.Commands}, nil
+}
+
+// PlanBuild resolves the sources, flags and libraries of everything Build does, without running or writing anything
+func (p *Project) PlanBuild(o BuildOptions) (*Plan, error) {
+	o = o.withDefaults()
+	span := o.Trace.Start("discovery", "plan the build of "+p.Name)
+	defer span.End()
+	plan := &Plan{Project: p.Name, Dir: p.Dir, Type: p.Config.Type, Steps: make([]Step, 0)}
+	if plan.Type == "" {
+		plan.Type = "app"
+	}
+	if p.Config.Type == "header-only" {
+		o.Log.Println("header-only project, there is nothing to compile (try cm test)")
+		return plan, nil
+	}
+	src := filepath.Join(p.Dir, "src")
+	args, err := p.projectArgs(o, plan, src)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.planMain(o, plan, args); err != nil {
+		return nil, err
+	}
+	if err := p.planCommands(o, plan, args); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// binDir returns the dir project binaries are written to
+func (p *Project) binDir(o BuildOptions) string {
+	if o.OutputDir != "" {
+		return o.OutputDir
+	}
+	return filepath.Join(p.Dir, "bin")
+}
+
+// projectArgs returns the args every binary is compiled with after its sources, planning the Go libraries first
+func (p *Project) projectArgs(o BuildOptions, plan *Plan, include string) ([]string, error) {
+	depArgs, err := o.DependencyArgs(p.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("dependency error: %w", err)
+	}
+	goLibs, err := p.goLibSteps()
+	if err != nil {
+		return nil, fmt.Errorf("go library error: %w", err)
+	}
+	plan.Steps = append(plan.Steps, goLibs...)
+	args := append(append([]string{}, o.Extra...), depArgs...)
+	if o.IncludePath != "" {
+		include = o.IncludePath
+	}
+	args = append(args, "-I"+include)
+	libArgs, err := o.libArgs(filepath.Join(p.Dir, "lib"), plan.outputs(StepGoLib))
+	return append(args, libArgs...), err
+}
+
+// planMain plans compiling src/ into the project's binary or library, if it has one, and the steps after linking
+func (p *Project) planMain(o BuildOptions, plan *Plan, args []string) error {
+	src := filepath.Join(p.Dir, "src")
+	targets, err := FindAll(src, SourceGlobs)
+	if err != nil {
+		return fmt.Errorf("could not find target files: %w", err)
+	}
+	if p.Config.Type != "lib" && len(CommandDirs(p.Dir)) > 0 {
+		hasMain, err := AnyDefinesMain(targets)
+		if err != nil {
+			return fmt.Errorf("could not read source files: %w", err)
+		}
+		if !hasMain {
+			o.Log.Printf("no main in %s, only building the binaries in cmd/", src)
+			return nil
+		}
+	}
+	binDir := p.binDir(o)
+	binary := filepath.Join(binDir, p.Name)
+	description := "compile and link " + p.Name
+	if p.Config.Type == "lib" {
+		binary = filepath.Join(binDir, "lib"+p.Name+".so")
+		description = "compile and link the shared library lib" + p.Name + ".so"
+		args = append(args, "-shared", "-fPIC")
+		if soname := p.Soname(); soname != "" {
+			args = append(args, "-Wl,-soname,"+soname)
+		}
+	}
+	step := o.compileStep(description, binary, targets, args)
+	step.Dir = p.Dir
+	plan.Steps = append(plan.Steps, step)
+	plan.Binary = binary
+
+	if soname := p.Soname(); soname != "" && p.Config.Type == "lib" {
+		// binaries linked against the library look for it by its soname
+		plan.Steps = append(plan.Steps, Step{
+			Kind:        StepSymlink,
+			Description: "link the soname " + soname + " to the library",
+			Output:      filepath.Join(binDir, soname),
+			Target:      filepath.Base(binary),
+		})
+	}
+	if runtime.GOOS == "darwin" {
+		libs, _ := plannedLibs(filepath.Join(p.Dir, "lib"), plan.outputs(StepGoLib))
+		for _, l := range libs {
+			// -id "@loader_path/lib/libhello.so" bin/example
+			plan.Steps = append(plan.Steps, Step{
+				Kind:        StepInstallName,
+				Description: "set the install name of " + l,
+				Dir:         p.Dir,
+				Command:     []string{"install_name_tool", "-id", "@loader_path/lib/" + l, binary},
+				Output:      binary,
+			})
+		}
+	}
+	return nil
+}
+
+// planCommands plans building every dir in cmd/ into a binary of the same name, with the library sources
+func (p *Project) planCommands(o BuildOptions, plan *Plan, args []string) error {
+	dirs := CommandDirs(p.Dir)
+	if len(dirs) == 0 {
+		return nil
+	}
+	srcs, err := o.LibrarySources(filepath.Join(p.Dir, "src"))
+	if err != nil {
+		return fmt.Errorf("could not find project sources: %w", err)
+	}
+	for _, dir := range dirs {
+		sources, err := FindAll(dir, SourceGlobs)
+		if err != nil {
+			return fmt.Errorf("could not find target files: %w", err)
+		}
+		if len(sources) == 0 {
+			continue
+		}
+		binary := filepath.Join(p.binDir(o), filepath.Base(dir))
+		step := o.compileStep("compile and link cmd/"+filepath.Base(dir), binary, append(sources, srcs...), args)
+		plan.Steps = append(plan.Steps, step)
+		plan.Commands = append(plan.Commands, binary)
+	}
+	return nil
+}
+
+// TestOptions selects what a test build compiles and how Test runs the test binary
+type TestOptions struct {
+	// Files limits the test sources to those whose base names match one of the globs; test_main.cpp is always kept
+	Files []string
+	// Args are extra compiler args, such as the flags of a test framework that is not embedded in cm
+	Args []string
+	// Harness maps paths in tests/ to the contents of test framework files, which are written before the tests are
+	// built and removed again afterwards. Harness sources are compiled into the test binary.
+	Harness map[string][]byte
+	// RunArgs are passed to the test binary
+	RunArgs []string
+	// External skips putting cm's embedded catch2 into tests/, for tests that bring their own framework
+	External bool
+}
+
+// BuildTests compiles the tests into tests/<name> and returns its path, running exactly the steps of PlanTests
+func (p *Project) BuildTests(o BuildOptions, t TestOptions) (string, error) {
+	plan, err := p.PlanTests(o, t)
+	if err != nil {
+		return "", err
+	}
+	if err := p.execute(o, plan); err != nil {
+		return "", err
+	}
+	return plan.Binary, nil
+}
+
+// PlanTests resolves everything BuildTests does, without running or writing anything
+func (p *Project) PlanTests(o BuildOptions, t TestOptions) (*Plan, error) {
+	o = o.withDefaults()
+	span := o.Trace.Start("discovery", "plan the test build of "+p.Name)
+	defer span.End()
+	plan := &Plan{Project: p.Name, Dir: p.Dir, Type: p.Config.Type, Tests: true, Steps: harnessSteps(t.Harness)}
+	if plan.Type == "" {
+		plan.Type = "app"
+	}
+	tests, src := filepath.Join(p.Dir, "tests"), filepath.Join(p.Dir, "src")
+	targets, err := FindAll(tests, SourceGlobs)
+	if err != nil {
+		return nil, fmt.Errorf("could not find target files: %w", err)
+	}
+	for _, h := range plan.outputs(StepHarness) {
+		if isSource(h) && !contains(targets, h) {
+			targets = append(targets, h)
+		}
+	}
+	if targets, err = SelectFiles(targets, t.Files, "test_main.cpp"); err != nil {
+		return nil, fmt.Errorf("could not select test files: %w", err)
+	}
+	srcs, err := o.LibrarySources(src)
+	if err != nil {
+		return nil, fmt.Errorf("could not find project sources: %w", err)
+	}
+	o.Log.Printf("linking %d project source(s) from %s into the test binary", len(srcs), src)
+	targets = append(targets, srcs...)
+	o.Extra = append(append(append([]string{}, o.Extra...), t.Args...), "-I"+src)
+	args, err := p.projectArgs(o, plan, tests)
+	if err != nil {
+		return nil, err
+	}
+	plan.Binary = filepath.Join(tests, p.Name)
+	step := o.compileStep("compile and link the tests of "+p.Name, plan.Binary, targets, args)
+	step.Dir = p.Dir
+	plan.Steps = append(plan.Steps, step)
+	return plan, nil
+}
+
+// TestResult is the outcome of a run of the test binary
+type TestResult struct {
+	Passed   bool
+	Output   []byte
+	Duration time.Duration
+}
+
+// Test builds and runs the tests once, then removes the binary; tests of one project must not run concurrently
+func (p *Project) Test(o BuildOptions, t TestOptions) (*TestResult, error) {
+	if !t.External {
+		catch2, err := EmbeddedCatch2(filepath.Join(p.Dir, "tests"))
+		if err != nil {
+			return nil, err
+		}
+		harness := make(map[string][]byte, len(catch2)+len(t.Harness))
+		for path, data := range catch2 {
+			harness[path] = data
+		}
+		for path, data := range t.Harness {
+			harness[path] = data
+		}
+		t.Harness = harness
+	}
+	binary, err := p.BuildTests(o, t)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(binary)
+	span := o.Trace.Start("test", "run "+filepath.Base(binary))
+	defer span.End()
+	start := time.Now()
+	command := exec.Command(binary, t.RunArgs...)
+	command.Dir = p.Dir
+	out, err := command.CombinedOutput()
+	if command.ProcessState != nil {
+		span.Arg("pid", command.ProcessState.Pid()).Arg("exit_code", command.ProcessState.ExitCode())
+	}
+	res := &TestResult{Passed: err == nil, Output: out, Duration: time.Since(start)}
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return nil, fmt.Errorf("could not run %s: %w", binary, err)
+	}
+	return res, nil
+}
+
+// EmbeddedCatch2 returns cm's catch2 header and a test_main.cpp, keyed by their paths in dir, as a test Harness
+func EmbeddedCatch2(dir string) (map[string][]byte, error) {
+	filesys, err := fs.New()
+	if err != nil {
+		return nil, fmt.Errorf("could not open embedded files: %w", err)
+	}
+	harness := make(map[string][]byte, 2)
+	for _, file := range []string{"/catch.hpp", "/test_main.cpp"} {
+		f, err := filesys.Open(file)
+		if err != nil {
+			return nil, fmt.Errorf("could not find embedded %s: %w", file, err)
+		}
+		contents, err := ioutil.ReadAll(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("could not read embedded %s: %w", file, err)
+		}
+		harness[filepath.Join(dir, filepath.Base(file))] = contents
+	}
+	return harness, nil
+}
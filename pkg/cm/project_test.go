@@ -0,0 +1,157 @@
+package cm
+
+import (
+	"debug/elf"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// testCompiler returns a C++ compiler to build fixtures with, skipping the test if there is none
+func testCompiler(t *testing.T) string {
+	for _, c := range []string{"g++", "clang++", "c++"} {
+		if path, err := exec.LookPath(c); err == nil {
+			return path
+		}
+	}
+	t.Skip("no C++ compiler found")
+	return ""
+}
+
+// runPath returns the DT_RUNPATH (or DT_RPATH) of an ELF binary
+func runPath(t *testing.T, path string) string {
+	f, err := elf.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	for _, tag := range []elf.DynTag{elf.DT_RUNPATH, elf.DT_RPATH} {
+		if values, err := f.DynString(tag); err == nil && len(values) > 0 {
+			return strings.Join(values, ":")
+		}
+	}
+	return ""
+}
+
+func TestOpen(t *testing.T) {
+	dir := tempProject(t)
+	p, err := Open(dir)
+	if err != nil || p.Name != filepath.Base(dir) || p.Dir != dir {
+		t.Errorf("Open() without cm.json = %+v, %v", p, err)
+	}
+	writeFiles(t, dir, map[string]string{ConfigFile: `{"name": "greet", "type": "lib", "version": "2.1.0"}`})
+	p, err = Open(dir)
+	if err != nil || p.Name != "greet" || p.Config.Type != "lib" {
+		t.Errorf("Open() = %+v, %v", p, err)
+	}
+	if got := p.Soname(); runtime.GOOS != "darwin" && got != "libgreet.so.2" {
+		t.Errorf("Soname() = %q, want libgreet.so.2", got)
+	}
+	writeFiles(t, dir, map[string]string{ConfigFile: `{"name": `})
+	if _, err := Open(dir); err == nil {
+		t.Error("Open() with a corrupt cm.json did not fail")
+	}
+}
+
+func TestBuild(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("checks ELF rpaths")
+	}
+	o := BuildOptions{Compiler: testCompiler(t), Std: "c++17"}
+	dir := tempProject(t)
+	writeFiles(t, dir, map[string]string{
+		"src/main.cpp":      "#include \"greeting.hpp\"\nint main() { return greeting() + shout() == 42 ? 0 : 1; }\n",
+		"src/greeting.hpp":  "int greeting();\nint shout();\n",
+		"src/greeting.cpp":  "int greeting() { return 40; }\n",
+		"cmd/tool/tool.cpp": "#include \"greeting.hpp\"\nint main() { return greeting() == 40 ? 0 : 1; }\n",
+		"shout.cpp":         "int shout() { return 2; }\n",
+	})
+	lib := filepath.Join(dir, "lib")
+	if err := os.MkdirAll(lib, 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.CompileFile(filepath.Join(dir, "shout.cpp"), filepath.Join(lib, "libshout.so"), "-shared",
+		"-fPIC"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		config    Config
+		output    string
+		rpath     string
+		binary    string
+		commands  []string
+		wantRPath string
+	}{
+		{"app", Config{}, "", "", "bin/app", []string{"bin/tool"}, lib},
+		{"app into an output dir", Config{}, "out", "$ORIGIN/../lib", "out/app", []string{"out/tool"},
+			"$ORIGIN/../lib"},
+		{"lib", Config{Type: "lib", Version: "1.2.0"}, "", "", "bin/libapp.so", []string{"bin/tool"}, lib},
+		{"header-only", Config{Type: "header-only"}, "", "", "", nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.RemoveAll(filepath.Join(dir, "bin"))
+			os.MkdirAll(filepath.Join(dir, "bin"), 0777)
+			o := o
+			o.RPath = tt.rpath
+			if tt.output != "" {
+				o.OutputDir = filepath.Join(dir, tt.output)
+				os.MkdirAll(o.OutputDir, 0777)
+			}
+			p := &Project{Dir: dir, Name: "app", Config: tt.config}
+			res, err := p.Build(o)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rel := func(path string) string {
+				r, _ := filepath.Rel(dir, path)
+				return r
+			}
+			commands := make([]string, 0)
+			for _, c := range res.Commands {
+				commands = append(commands, rel(c))
+			}
+			if tt.binary == "" {
+				if res.Binary != "" || len(res.Commands) != 0 {
+					t.Errorf("Build() = %+v, want nothing built", res)
+				}
+				return
+			}
+			if rel(res.Binary) != tt.binary || !reflect.DeepEqual(commands, tt.commands) {
+				t.Errorf("Build() = %s and %q, want %s and %q", rel(res.Binary), commands, tt.binary, tt.commands)
+			}
+			for _, b := range append([]string{res.Binary}, res.Commands...) {
+				if got := runPath(t, b); got != tt.wantRPath {
+					t.Errorf("rpath of %s = %q, want %q", rel(b), got, tt.wantRPath)
+				}
+			}
+			if tt.config.Type == "lib" {
+				if link, err := os.Readlink(filepath.Join(dir, "bin", "libapp.so.1")); err != nil || link != "libapp.so" {
+					t.Errorf("soname link = %q, %v, want libapp.so", link, err)
+				}
+				return
+			}
+			if tt.rpath == "" {
+				if out, err := exec.Command(res.Binary).CombinedOutput(); err != nil {
+					t.Errorf("running %s: %v\n%s", rel(res.Binary), err, out)
+				}
+			}
+		})
+	}
+}
+
+func TestBuildCompileError(t *testing.T) {
+	o := BuildOptions{Compiler: testCompiler(t)}
+	dir := tempProject(t)
+	writeFiles(t, dir, map[string]string{"src/main.cpp": "int main() { return missing(); }\n"})
+	_, err := (&Project{Dir: dir, Name: "broken"}).Build(o)
+	if err == nil || !strings.Contains(err.Error(), "missing") {
+		t.Errorf("Build() of a broken project = %v, want the compiler output", err)
+	}
+}
@@ -0,0 +1,199 @@
+package cm
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// BuildOptions are the toolchain settings of a build; the zero value builds with clang++ in C++2a mode
+type BuildOptions struct {
+	// Compiler is the C++ compiler to run (default clang++) and Std the language standard (default c++2a)
+	Compiler string
+	Std      string
+	// Optimize compiles with -O3 instead of -O0
+	Optimize bool
+	// IncludePath is an extra include dir (default: the dir of the sources being built)
+	IncludePath string
+	// Extra are extra compiler args, passed after the sources
+	Extra []string
+	// OutputDir replaces bin/ as the dir project binaries are written to
+	OutputDir string
+	// RPath, when set, replaces the build dirs cm bakes into binaries as their rpath, so installed binaries find their
+	// libraries relative to themselves, e.g. $ORIGIN/../lib
+	RPath string
+	// Timeout is how long a single compiler run may take (default 10 minutes)
+	Timeout time.Duration
+	// Debug logs every compiler command before running it
+	Debug bool
+	// Log receives progress messages and compiler warnings (default: discarded)
+	Log *log.Logger
+	// Trace, when set, records every phase of a build. Compile steps then run one compiler per translation unit and a
+	// separate link, so each shows up on its own.
+	Trace *Trace
+	// skipMissingDeps leaves the dependencies that are not vendored yet out of the args instead of failing
+	skipMissingDeps bool
+}
+
+// withDefaults fills in the defaults of unset options
+func (o BuildOptions) withDefaults() BuildOptions {
+	if o.Compiler == "" {
+		o.Compiler = "clang++"
+	}
+	if o.Std == "" {
+		o.Std = "c++2a"
+	}
+	if o.Timeout == 0 {
+		o.Timeout = 10 * time.Minute
+	}
+	if o.Log == nil {
+		o.Log = log.New(ioutil.Discard, "", 0)
+	}
+	return o
+}
+
+// LanguageFlags are the flags every translation unit is compiled with
+func (o BuildOptions) LanguageFlags() []string {
+	o = o.withDefaults()
+	optLevel := "0"
+	if o.Optimize {
+		optLevel = "3"
+	}
+	return []string{
+		"-std=" + o.Std,
+		"-Wall",
+		"-O" + optLevel,
+	}
+}
+
+// rpathArg returns the linker arg that adds dir, or the RPath option, to the rpath
+func (o BuildOptions) rpathArg(dir string) string {
+	if o.RPath != "" {
+		dir = o.RPath
+	}
+	return "-Wl,-rpath," + dir
+}
+
+// LibArgs returns the linker args for the shared objects and archives found in libPath
+func (o BuildOptions) LibArgs(libPath string) ([]string, error) {
+	return o.libArgs(libPath, nil)
+}
+
+// libArgs returns the linker args for the libraries in libPath, including the planned ones a build writes there
+func (o BuildOptions) libArgs(libPath string, planned []string) ([]string, error) {
+	o = o.withDefaults()
+	cArgs := make([]string, 0)
+	o.Log.Printf("checking for shared objects in %s...", libPath)
+	libs, err := plannedLibs(libPath, planned)
+	if err != nil {
+		return nil, fmt.Errorf("error reading shared libraries: %w", err)
+	}
+	if len(libs) == 0 {
+		o.Log.Println("none found")
+		return cArgs, nil
+	}
+	cArgs = append(cArgs, "-L"+libPath)
+	shared, archives := false, false
+	for _, l := range libs {
+		ext := filepath.Ext(l)
+		if !strings.HasPrefix(l, "lib") || (ext != ".so" && ext != ".a") {
+			continue
+		}
+		trimmedLib := strings.TrimSuffix(strings.TrimPrefix(l, "lib"), ext)
+		kind := "shared object"
+		if ext == ".a" {
+			kind = "archive"
+		}
+		o.Log.Printf("linking %s: %s", kind, trimmedLib)
+		cArgs = append(cArgs, "-l"+trimmedLib)
+		shared = shared || ext == ".so"
+		archives = archives || ext == ".a"
+	}
+	if archives {
+		// Go c-archives need the threading library the Go runtime uses
+		cArgs = append(cArgs, "-lpthread")
+	}
+	if shared {
+		if runtime.GOOS == "darwin" {
+			o.Log.Println("compiling on macos, so rpath linking will be done after compilation")
+		} else {
+			cArgs = append(cArgs, o.rpathArg(libPath))
+		}
+	}
+	return cArgs, nil
+}
+
+// LibrarySources returns the sources in dir that can be linked into another program, skipping those defining main
+func (o BuildOptions) LibrarySources(dir string) ([]string, error) {
+	o = o.withDefaults()
+	srcs, err := FindAll(dir, SourceGlobs)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]string, 0, len(srcs))
+	for _, s := range srcs {
+		isMain, err := DefinesMain(s)
+		if err != nil {
+			return nil, err
+		}
+		if isMain {
+			o.Log.Printf("skipping %s as it defines main", s)
+			continue
+		}
+		res = append(res, s)
+	}
+	return res, nil
+}
+
+// PlanFile returns the plan of CompileFile: a single compile step, in a plan named after the source file
+func (o BuildOptions) PlanFile(source, binary string, extra ...string) *Plan {
+	o = o.withDefaults()
+	name := strings.TrimSuffix(filepath.Base(source), filepath.Ext(source))
+	step := o.compileStep("compile and link "+filepath.Base(source), binary, []string{source}, extra)
+	return &Plan{Project: name, Dir: filepath.Dir(source), Type: "app", Binary: binary, Steps: []Step{step}}
+}
+
+// CompileFile compiles a source file, and any sources in extra, into the given binary
+func (o BuildOptions) CompileFile(source, binary string, extra ...string) error {
+	cArgs := append(o.LanguageFlags(), "-o"+binary, source)
+	span := o.Trace.Start("compile", "compile "+filepath.Base(source))
+	defer span.End()
+	return o.compile(span, "", append(cArgs, extra...))
+}
+
+// compile runs the compiler with the given args in dir (the working dir if empty), recording the run in the span
+func (o BuildOptions) compile(span *Span, dir string, args []string) error {
+	o = o.withDefaults()
+	if o.Debug {
+		o.Log.Printf("%s %s", o.Compiler, strings.Join(args, " "))
+	}
+	out, err := o.run(span, dir, o.Compiler, args...)
+	if err != nil {
+		return fmt.Errorf("%s failed: %v\n%s %s\n%s", o.Compiler, err, o.Compiler, strings.Join(args, " "), out)
+	}
+	if len(out) > 0 {
+		o.Log.Print(string(out))
+	}
+	return nil
+}
+
+// run runs a command in dir, killing it after the timeout, and records it in the span
+func (o BuildOptions) run(span *Span, dir, cmd string, args ...string) ([]byte, error) {
+	o = o.withDefaults()
+	ctx, cancel := context.WithTimeout(context.Background(), o.Timeout)
+	defer cancel()
+	command := exec.CommandContext(ctx, cmd, args...)
+	command.Dir = dir
+	out, err := command.CombinedOutput()
+	span.Arg("command", strings.Join(append([]string{cmd}, args...), " "))
+	if command.ProcessState != nil {
+		span.Arg("pid", command.ProcessState.Pid()).Arg("exit_code", command.ProcessState.ExitCode())
+	}
+	return out, err
+}
@@ -0,0 +1,60 @@
+package cm
+
+import (
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func TestLanguageFlags(t *testing.T) {
+	tests := []struct {
+		o    BuildOptions
+		want []string
+	}{
+		{BuildOptions{}, []string{"-std=c++2a", "-Wall", "-O0"}},
+		{BuildOptions{Std: "c++17", Optimize: true}, []string{"-std=c++17", "-Wall", "-O3"}},
+	}
+	for _, tt := range tests {
+		if got := tt.o.LanguageFlags(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("LanguageFlags() of %+v = %q, want %q", tt.o, got, tt.want)
+		}
+	}
+}
+
+func TestLibArgs(t *testing.T) {
+	if runtime.GOOS == "darwin" {
+		t.Skip("rpaths are set after linking on macOS")
+	}
+	target := tempProject(t)
+	lib := filepath.Join(target, "lib")
+	archives := filepath.Join(target, "archives")
+	writeFiles(t, target, map[string]string{
+		"lib/libzeta.so":     "",
+		"lib/libalpha.so":    "",
+		"lib/libgo.a":        "",
+		"lib/libhello.h":     "",
+		"lib/hello_cgo.go":   "",
+		"lib/notalib.so":     "",
+		"archives/libgo.a":   "",
+		"headers/libhello.h": "",
+	})
+	tests := []struct {
+		name, dir, rpath string
+		want             []string
+	}{
+		{"shared and archives", lib, "", []string{"-L" + lib, "-lalpha", "-lgo", "-lzeta", "-lpthread",
+			"-Wl,-rpath," + lib}},
+		{"install rpath", lib, "$ORIGIN/../lib", []string{"-L" + lib, "-lalpha", "-lgo", "-lzeta", "-lpthread",
+			"-Wl,-rpath,$ORIGIN/../lib"}},
+		{"only archives", archives, "", []string{"-L" + archives, "-lgo", "-lpthread"}},
+		{"no libraries", filepath.Join(target, "headers"), "", []string{"-L" + target + "/headers"}},
+		{"missing dir", filepath.Join(target, "missing"), "", []string{}},
+	}
+	for _, tt := range tests {
+		got, err := BuildOptions{RPath: tt.rpath}.LibArgs(tt.dir)
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("LibArgs() with %s = %q, %v, want %q", tt.name, got, err, tt.want)
+		}
+	}
+}
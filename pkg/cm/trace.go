@@ -0,0 +1,124 @@
+package cm
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Trace writes Chrome trace events as spans end, so the trace of a failed run still loads; a nil *Trace records nothing
+type Trace struct {
+	mu    sync.Mutex
+	w     io.Writer
+	start time.Time
+	pid   int
+	n     int
+	err   error
+}
+
+// TraceEvent is a Chrome trace event, with times in microseconds since the trace started
+type TraceEvent struct {
+	Name string `json:"name"`
+	Cat  string `json:"cat,omitempty"`
+	// Ph is the phase: X for a complete event with a duration, M for metadata such as process names
+	Ph   string                 `json:"ph"`
+	Ts   int64                  `json:"ts"`
+	Dur  int64                  `json:"dur,omitempty"`
+	Pid  int                    `json:"pid"`
+	Tid  int                    `json:"tid"`
+	Args map[string]interface{} `json:"args,omitempty"`
+}
+
+// NewTrace starts a trace of this process, named after its command line, that writes its events to w
+func NewTrace(w io.Writer) *Trace {
+	t := &Trace{w: w, start: time.Now(), pid: os.Getpid()}
+	t.write(TraceEvent{
+		Name: "process_name",
+		Ph:   "M",
+		Pid:  t.pid,
+		Args: map[string]interface{}{"name": strings.Join(os.Args, " ")},
+	})
+	t.write(TraceEvent{Name: "thread_name", Ph: "M", Pid: t.pid, Tid: 1, Args: map[string]interface{}{"name": "main"}})
+	return t
+}
+
+// write appends an event to the trace
+func (t *Trace) write(ev TraceEvent) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.err != nil {
+		return
+	}
+	data, err := json.Marshal(ev)
+	if err != nil {
+		t.err = err
+		return
+	}
+	sep := ",\n"
+	if t.n == 0 {
+		sep = "[\n"
+	}
+	t.n++
+	_, t.err = io.WriteString(t.w, sep+string(data))
+}
+
+// Close ends the trace, returning the first error writing it
+func (t *Trace) Close() error {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.err == nil {
+		_, t.err = io.WriteString(t.w, "\n]\n")
+	}
+	return t.err
+}
+
+// Span is a phase of a traced run; a nil *Span, as a nil *Trace returns, records nothing
+type Span struct {
+	t     *Trace
+	ev    TraceEvent
+	start time.Time
+}
+
+// Start starts a span in the given category on the main thread
+func (t *Trace) Start(cat, name string) *Span {
+	if t == nil {
+		return nil
+	}
+	return &Span{t: t, ev: TraceEvent{Name: name, Cat: cat, Ph: "X", Pid: t.pid, Tid: 1}, start: time.Now()}
+}
+
+// Thread moves the span to another thread of the trace, for phases that run concurrently
+func (s *Span) Thread(tid int) *Span {
+	if s != nil {
+		s.ev.Tid = tid
+	}
+	return s
+}
+
+// Arg records an arg of the span, shown when the span is selected
+func (s *Span) Arg(key string, value interface{}) *Span {
+	if s == nil {
+		return nil
+	}
+	if s.ev.Args == nil {
+		s.ev.Args = make(map[string]interface{})
+	}
+	s.ev.Args[key] = value
+	return s
+}
+
+// End ends the span and writes it to the trace
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.ev.Ts = s.start.Sub(s.t.start).Microseconds()
+	s.ev.Dur = time.Since(s.start).Microseconds()
+	s.t.write(s.ev)
+}
@@ -0,0 +1,178 @@
+package cm
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// readTrace decodes the events of a trace in the JSON array format
+func readTrace(t *testing.T, data []byte) []TraceEvent {
+	var events []TraceEvent
+	if err := json.Unmarshal(data, &events); err != nil {
+		t.Fatalf("trace is not a JSON array: %v\n%s", err, data)
+	}
+	return events
+}
+
+func TestTrace(t *testing.T) {
+	var b bytes.Buffer
+	tr := NewTrace(&b)
+	outer := tr.Start("build", "build app")
+	inner := tr.Start("compile", "compile a.cpp").Thread(2).Arg("source", "a.cpp").Arg("exit_code", 0)
+	inner.End()
+	if !strings.HasPrefix(b.String(), "[\n") {
+		t.Fatalf("trace starts with %q", b.String())
+	}
+	// a run that dies before closing the trace leaves it without the closing bracket
+	partial := readTrace(t, append(append([]byte{}, b.Bytes()...), ']'))
+	if len(partial) != 3 {
+		t.Errorf("unclosed trace has %d events, want 3", len(partial))
+	}
+	outer.End()
+	if err := tr.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	events := readTrace(t, b.Bytes())
+	if len(events) != 4 {
+		t.Fatalf("trace has %d events, want 4: %+v", len(events), events)
+	}
+	pid := os.Getpid()
+	process, thread := events[0], events[1]
+	if process.Name != "process_name" || process.Ph != "M" || process.Pid != pid ||
+		process.Args["name"] != strings.Join(os.Args, " ") {
+		t.Errorf("first event = %+v, want the process name", process)
+	}
+	if thread.Name != "thread_name" || thread.Ph != "M" || thread.Pid != pid || thread.Tid != 1 ||
+		thread.Args["name"] != "main" {
+		t.Errorf("second event = %+v, want the name of the main thread", thread)
+	}
+	// spans are written as they end, so the inner one comes first
+	in, out := events[2], events[3]
+	if in.Name != "compile a.cpp" || in.Cat != "compile" || in.Ph != "X" || in.Pid != pid || in.Tid != 2 ||
+		in.Args["source"] != "a.cpp" || in.Args["exit_code"] != 0.0 {
+		t.Errorf("third event = %+v, want the compile span", in)
+	}
+	if out.Name != "build app" || out.Cat != "build" || out.Ph != "X" || out.Tid != 1 || out.Args != nil {
+		t.Errorf("fourth event = %+v, want the build span", out)
+	}
+	if in.Ts < out.Ts || in.Ts+in.Dur > out.Ts+out.Dur {
+		t.Errorf("compile span [%d, +%d] is not within the build span [%d, +%d]", in.Ts, in.Dur, out.Ts, out.Dur)
+	}
+}
+
+func TestNilTrace(t *testing.T) {
+	var tr *Trace
+	span := tr.Start("build", "build app").Thread(2).Arg("k", "v")
+	if span != nil {
+		t.Errorf("Start() on a nil trace = %+v, want nil", span)
+	}
+	span.End()
+	if err := tr.Close(); err != nil {
+		t.Errorf("Close() of a nil trace = %v", err)
+	}
+}
+
+// failingWriter fails every write after the first n bytes
+type failingWriter struct {
+	n int
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if len(p) > w.n {
+		return 0, errors.New("disk full")
+	}
+	w.n -= len(p)
+	return len(p), nil
+}
+
+func TestTraceWriteError(t *testing.T) {
+	w := &failingWriter{n: 10}
+	tr := NewTrace(w)
+	tr.Start("build", "build app").End()
+	if err := tr.Close(); err == nil || err.Error() != "disk full" {
+		t.Errorf("Close() after a failed write = %v, want the write error", err)
+	}
+}
+
+func TestBuildTraced(t *testing.T) {
+	o := BuildOptions{Compiler: testCompiler(t)}
+	dir := tempProject(t)
+	writeFiles(t, dir, map[string]string{
+		"src/main.cpp":     "#include \"greeting.hpp\"\nint main() { return greeting() == 42 ? 0 : 1; }\n",
+		"src/greeting.hpp": "int greeting();\n",
+		"src/greeting.cpp": "int greeting() { return 42; }\n",
+		"bin/.keep":        "",
+	})
+	var b bytes.Buffer
+	o.Trace = NewTrace(&b)
+	res, err := (&Project{Dir: dir, Name: "app"}).Build(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := o.Trace.Close(); err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, 0)
+	for _, ev := range readTrace(t, b.Bytes())[2:] {
+		names = append(names, ev.Cat+": "+ev.Name)
+		if ev.Name != "compile and link app" && (ev.Cat == "compile" || ev.Cat == "link") {
+			if code, ok := ev.Args["exit_code"]; !ok || code != 0.0 || ev.Args["command"] == nil {
+				t.Errorf("%s has exit code %v", ev.Name, ev.Args["exit_code"])
+			}
+		}
+	}
+	want := []string{
+		"discovery: plan the build of app",
+		"compile: compile src/greeting.cpp",
+		"compile: compile src/main.cpp",
+		"link: link bin/app",
+		"compile: compile and link app",
+	}
+	if strings.Join(names, "\n") != strings.Join(want, "\n") {
+		t.Errorf("trace events =\n%s\nwant\n%s", strings.Join(names, "\n"), strings.Join(want, "\n"))
+	}
+	if out, err := exec.Command(res.Binary).CombinedOutput(); err != nil {
+		t.Errorf("running the traced build: %v\n%s", err, out)
+	}
+}
+
+func TestBuildTracedFlagPairs(t *testing.T) {
+	dir := tempProject(t)
+	writeFiles(t, dir, map[string]string{
+		"src/main.cpp":      "#include <answer.hpp>\nint main() { return ANSWER == FORCED ? 0 : 1; }\n",
+		"system/answer.hpp": "#define ANSWER 42\n",
+		"forced/forced.hpp": "#define FORCED 42\n",
+		"bin/.keep":         "",
+	})
+	o := BuildOptions{
+		Compiler: testCompiler(t),
+		Extra:    []string{"-isystem", dir + "/system", "-include", dir + "/forced/forced.hpp", "-D", "UNUSED=1"},
+		Trace:    NewTrace(&bytes.Buffer{}),
+	}
+	res, err := (&Project{Dir: dir, Name: "app"}).Build(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out, err := exec.Command(res.Binary).CombinedOutput(); err != nil {
+		t.Errorf("running the traced build: %v\n%s", err, out)
+	}
+
+	// -x applies to the inputs after it, so it only works ahead of the translation units
+	o.Extra = nil
+	writeFiles(t, dir, map[string]string{"src/main.cpp": "int main() { return 0; }\n"})
+	s := Step{
+		Kind:    StepCompile,
+		Output:  dir + "/bin/app",
+		Sources: []string{dir + "/src/main.cpp"},
+		Command: []string{o.Compiler, "-x", "c++", dir + "/src/main.cpp", "-o", dir + "/bin/app", "-L", dir + "/lib"},
+	}
+	if err := (&Project{Dir: dir, Name: "app"}).compileTraced(o.withDefaults(), s); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// isolation flags for `cm test`; when any of them (or -count, -retry-failed or -last-failed) is given, every test case
+// runs in its own process
+var (
+	testJobs    = testFlags.Int("j", 0, "run test cases in isolated processes, n at a time (0 runs the test binary once)")
+	testShard   = testFlags.String("shard", "", "only run the i-th of n equal slices of the test cases, e.g. 2/4 (implies -j)")
+	testTimeout = testFlags.Duration("timeout", time.Minute, "per test case timeout when running isolated test cases")
+)
+
+// test case statuses reported by runTestCase; statusFlaky is only ever assigned across several attempts
+const (
+	statusPass    = "PASS"
+	statusFail    = "FAIL"
+	statusCrash   = "CRASH"
+	statusTimeout = "TIMEOUT"
+	statusFlaky   = "FLAKY"
+)
+
+// testResult is the outcome of running a single test case in its own process
+type testResult struct {
+	name     string
+	status   string
+	duration time.Duration
+	output   []byte
+}
+
+// isolatedTests reports whether the test run should execute each test case in a separate process
+func isolatedTests() bool {
+	return *testJobs > 0 || *testShard != "" || *testCount > 1 || *testRetries > 0 || *testLastFailed
+}
+
+// listTests asks the compiled test binary for the names of the test cases matching the current selection
+func listTests(fw testFramework, binary string) ([]string, error) {
+	args := append(fw.listArgs(), fw.specArgs(*testPattern, *testTags)...)
+	out, err := exec.Command(binary, args...).Output()
+	// catch2 exits with the number of listed test cases, so only a failure to start the binary is an error
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return nil, err
+	}
+	return fw.parseList(out), nil
+}
+
+// shardTests returns the slice of names belonging to the given "i/n" shard (1 <= i <= n). Names are sorted first and
+// dealt out round-robin so that every machine given the same binary computes the same, evenly sized shards.
+func shardTests(names []string, shard string) ([]string, error) {
+	if shard == "" {
+		return names, nil
+	}
+	parts := strings.Split(shard, "/")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("shard must have the form i/n, got %q", shard)
+	}
+	i, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("bad shard index: %w", err)
+	}
+	n, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("bad shard count: %w", err)
+	}
+	if n < 1 || i < 1 || i > n {
+		return nil, fmt.Errorf("shard index must be between 1 and %d, got %d", n, i)
+	}
+	sorted := append([]string{}, names...)
+	sort.Strings(sorted)
+	res := make([]string, 0, len(sorted)/n+1)
+	for k, name := range sorted {
+		if k%n == i-1 {
+			res = append(res, name)
+		}
+	}
+	return res, nil
+}
+
+// runTestCase runs a single test case of the binary in its own process, killing it after timeout
+func runTestCase(fw testFramework, binary, name string, timeout time.Duration) testResult {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	lim := flagLimits()
+	cmd, args := lim.command(binary, fw.caseArgs(name))
+	command := exec.CommandContext(ctx, cmd, args...)
+	var out bytes.Buffer
+	command.Stdout = &out
+	command.Stderr = &out
+	start := time.Now()
+	err := command.Run()
+	res := testResult{name: name, status: statusPass, duration: time.Since(start), output: out.Bytes()}
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+	case ctx.Err() == context.DeadlineExceeded:
+		res.status = statusTimeout
+	case errors.As(err, &exitErr):
+		res.status = statusFail
+		if ws, ok := exitErr.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+			res.status = statusCrash
+			res.output = append(res.output, fmt.Sprintf("\nkilled by signal: %v\n", ws.Signal())...)
+		}
+		if limit := lim.hit(exitErr.ProcessState); limit != "" {
+			res.output = append(res.output, fmt.Sprintf("stopped by the %s\n", limit)...)
+		}
+	default:
+		res.status = statusCrash
+		res.output = append(res.output, err.Error()...)
+	}
+	return res
+}
+
+// runIsolated runs every named test case in a separate process using a pool of jobs workers. Results are returned in
+// the order of names, regardless of the order in which the test cases finish.
+func runIsolated(fw testFramework, binary string, names []string, jobs int, timeout time.Duration) []testResult {
+	if jobs < 1 {
+		jobs = 1
+	}
+	results := make([]testResult, len(names))
+	queue := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < jobs; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := range queue {
+				// every worker is a thread of its own in the trace, next to the main thread
+				span := trace.Start("test", names[i]).Thread(w + 2)
+				results[i] = runTestCase(fw, binary, names[i], timeout)
+				span.Arg("status", results[i].status).End()
+			}
+		}(w)
+	}
+	for i := range names {
+		queue <- i
+	}
+	close(queue)
+	wg.Wait()
+	return results
+}
+
+// runIsolatedTests lists, shards and runs the test cases of the binary in isolation, printing a line per test case and
+// a summary. It returns false if any test case failed consistently.
+func runIsolatedTests(fw testFramework, target, binary string) bool {
+	names, err := listTests(fw, binary)
+	if err != nil {
+		log.Fatalf("could not list test cases: %+v", err)
+	}
+	if *testLastFailed {
+		names = filterLastFailed(target, names)
+	}
+	names, err = shardTests(names, *testShard)
+	if err != nil {
+		log.Fatalf("shard error: %+v", err)
+	}
+	log.Printf("running %d test cases in isolation (%d at a time)...", len(names), maxInt(*testJobs, 1))
+	start := time.Now()
+	outcomes := runRepeated(fw, binary, names)
+	passed := reportResults(outcomes)
+	if err := recordFlakiness(target, outcomes); err != nil {
+		log.Printf("could not record flakiness statistics: %+v", err)
+	}
+	if err := recordHistory(target, fw, start, passed, outcomes); err != nil {
+		log.Printf("could not record test history: %+v", err)
+	}
+	return passed
+}
+
+// runTestBinary runs the selected test cases of the binary in a single process, reading the result of every test case
+// from the framework's JUnit report. When the binary writes no report, as when it crashes or ignores the reporter, its
+// output is printed as is and only the overall result is recorded. It returns false if any test case failed.
+func runTestBinary(fw testFramework, target, binary string) bool {
+	names, err := listTests(fw, binary)
+	if err != nil {
+		log.Printf("could not list test cases, sections are reported as test cases of their own: %+v", err)
+	}
+	start := time.Now()
+	lim := flagLimits()
+	span := trace.Start("test", "run "+*name+" tests")
+	out, err := wrap(lim.command(binary, append(fw.specArgs(*testPattern, *testTags), fw.reportArgs()...)))
+	// the test binary exits non-zero when a test fails, which is not an error of cm's but fails the run
+	passed := err == nil
+	outcomes, ok := parseJUnit(out, names)
+	if ok {
+		passed = reportResults(outcomes) && passed
+	} else {
+		log.Println(string(out))
+		if sum, ok := fw.summary(out); ok {
+			log.Printf("test cases: %d | %d passed | %d failed", sum.total, sum.passed, sum.failed)
+			passed = passed && sum.failed == 0
+		}
+	}
+	reportLimit(lim, err)
+	span.Arg("passed", passed).End()
+	if err := recordHistory(target, fw, start, passed, outcomes); err != nil {
+		log.Printf("could not record test history: %+v", err)
+	}
+	return passed
+}
+
+// reportResults prints the outcome of every test case, with the output of those that did not pass, followed by a
+// catch2-style summary line. It returns true unless a test case failed consistently; flaky test cases are reported but
+// do not fail the run.
+func reportResults(outcomes []caseOutcome) bool {
+	counts := map[string]int{}
+	for _, o := range outcomes {
+		status := o.status()
+		counts[status]++
+		last := o.attempts[len(o.attempts)-1]
+		if status == statusFlaky {
+			fmt.Printf("--- %s: %s (%d/%d attempts failed)\n", status, o.name, o.failures(), len(o.attempts))
+		} else {
+			fmt.Printf("--- %s: %s (%.2fs)\n", status, o.name, last.duration.Seconds())
+		}
+		if status != statusPass {
+			for _, line := range strings.Split(strings.TrimSpace(string(o.lastFailure().output)), "\n") {
+				fmt.Println("    " + line)
+			}
+		}
+	}
+	fmt.Println("")
+	log.Printf(
+		"test cases: %d | %d passed | %d flaky | %d failed | %d crashed | %d timed out",
+		len(outcomes), counts[statusPass], counts[statusFlaky], counts[statusFail], counts[statusCrash],
+		counts[statusTimeout],
+	)
+	return counts[statusPass]+counts[statusFlaky] == len(outcomes)
+}
+
+// maxInt returns the larger of a and b
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
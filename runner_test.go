@@ -0,0 +1,99 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestShardTests(t *testing.T) {
+	names := []string{"e", "c", "a", "d", "b"}
+	tests := []struct {
+		shard   string
+		want    []string
+		wantErr bool
+	}{
+		{"", names, false},
+		{"1/1", []string{"a", "b", "c", "d", "e"}, false},
+		{"1/2", []string{"a", "c", "e"}, false},
+		{"2/2", []string{"b", "d"}, false},
+		{"5/5", []string{"e"}, false},
+		{"6/6", []string{}, false},
+		{"0/2", nil, true},
+		{"3/2", nil, true},
+		{"1/0", nil, true},
+		{"1", nil, true},
+		{"a/2", nil, true},
+		{"1/2/3", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := shardTests(names, tt.shard)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("shardTests(%q) error = %v, want error %v", tt.shard, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("shardTests(%q) = %q, want %q", tt.shard, got, tt.want)
+		}
+	}
+}
+
+func TestShardTestsCoverEveryNameOnce(t *testing.T) {
+	names := []string{"g", "f", "e", "d", "c", "b", "a"}
+	seen := map[string]int{}
+	for _, shard := range []string{"1/3", "2/3", "3/3"} {
+		got, err := shardTests(names, shard)
+		if err != nil {
+			t.Fatalf("shardTests(%q): %v", shard, err)
+		}
+		for _, n := range got {
+			seen[n]++
+		}
+	}
+	for _, n := range names {
+		if seen[n] != 1 {
+			t.Errorf("%q is in %d shards, want 1", n, seen[n])
+		}
+	}
+}
+
+// fakeTestBinary writes a shell script that behaves like a test binary running the test case named by its argument.
+// The "flaky" test case fails the first time it runs and passes afterwards.
+func fakeTestBinary(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "cm-runner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	script := `#!/bin/sh
+case "$1" in
+pass) echo ok ;;
+fail) echo "CHECK failed"; exit 1 ;;
+crash) kill -SEGV $$ ;;
+hang) exec sleep 5 ;;
+flaky) n=$(cat "$0.runs" 2>/dev/null || echo 0); echo $((n + 1)) > "$0.runs"; [ "$n" -gt 0 ] ;;
+esac
+`
+	binary := filepath.Join(dir, "tests")
+	if err := ioutil.WriteFile(binary, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return binary
+}
+
+func TestRunIsolated(t *testing.T) {
+	names := []string{"hang", "fail", "pass", "crash"}
+	results := runIsolated(catch2{}, fakeTestBinary(t), names, 3, 200*time.Millisecond)
+	want := []string{statusTimeout, statusFail, statusPass, statusCrash}
+	for i, r := range results {
+		if r.name != names[i] || r.status != want[i] {
+			t.Errorf("result %d = %s %s, want %s %s", i, r.name, r.status, names[i], want[i])
+		}
+	}
+	if got := string(results[1].output); got != "CHECK failed\n" {
+		t.Errorf("output of the failing test case = %q", got)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/damienstanton/cm/pkg/cm"
+)
+
+// runFlags and buildFlags are the flag sets of `cm run` and `cm build`, which only take the global flags. Given a
+// source file they work on that file alone, without a project around it.
+var (
+	runFlags   = flag.NewFlagSet("run", flag.ExitOnError)
+	buildFlags = flag.NewFlagSet("build", flag.ExitOnError)
+)
+
+// runSingle compiles a single source file into the cache and, for `cm run`, executes it with the given args and the
+// terminal attached, like go run
+func runSingle(file string, args []string) {
+	source, err := filepath.Abs(file)
+	if err != nil {
+		log.Fatalf("path error: %+v", err)
+	}
+	if *dryRun {
+		printPlan(singlePlan(source))
+		return
+	}
+	binary, err := singleBinary(source)
+	if err != nil {
+		log.Fatalf("%+v", err)
+	}
+	if !*run {
+		log.Printf("binary output path: \"%s\"", binary)
+		return
+	}
+	log.Printf("running %s...", filepath.Base(source))
+	fmt.Println("")
+	lim := flagLimits()
+	if err := wrapInteractive(lim.command(binary, args)); err != nil {
+		reportLimit(lim, err)
+		log.Fatalf("your program compiled but crashed at runtime: %+v\n", err)
+	}
+}
+
+// singleBinary returns the path of the cached binary for a source file, compiling it first unless a binary built from
+// the same file, headers and flags is already cached
+func singleBinary(source string) (string, error) {
+	binary, err := singlePath(source)
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Dir(binary)
+	if _, err := os.Stat(binary); err == nil {
+		log.Printf("using cached build of %s", filepath.Base(source))
+		return binary, nil
+	}
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return "", err
+	}
+	log.Printf("compiling %s...", filepath.Base(source))
+	if err := buildOptions().CompileFile(source, binary); err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	log.Println("🎉 compilation succeeded with no errors")
+	return binary, nil
+}
+
+// singlePath returns where the binary built from a source file is cached
+func singlePath(source string) (string, error) {
+	key, err := singleKey(source)
+	if err != nil {
+		return "", err
+	}
+	cache, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("could not locate cache dir: %w", err)
+	}
+	return filepath.Join(cache, "cm", "single", key, strings.TrimSuffix(filepath.Base(source), filepath.Ext(source))), nil
+}
+
+// singlePlan returns the plan of a single file build, which has nothing to do if the binary is already cached
+func singlePlan(source string) (*cm.Plan, error) {
+	binary, err := singlePath(source)
+	if err != nil {
+		return nil, err
+	}
+	plan := buildOptions().PlanFile(source, binary)
+	if isFile(binary) {
+		plan.Steps = make([]cm.Step, 0)
+	}
+	return plan, nil
+}
+
+// singleKey hashes everything that goes into a single file build: the compiler and flags, the source file's path and
+// the contents of the file and every header it includes (as reported by the compiler)
+func singleKey(source string) (string, error) {
+	h := sha256.New()
+	fmt.Fprintln(h, *compiler, *std, *optimize, source)
+	deps, err := scanDeps(source, nil)
+	if err != nil {
+		return "", err
+	}
+	for _, d := range deps {
+		b, err := ioutil.ReadFile(d)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintln(h, d, len(b))
+		h.Write(b)
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16], nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// useCompiler points -compiler at an installed C++ compiler for the duration of a test, skipping it if there is none
+func useCompiler(t *testing.T) {
+	for _, c := range []string{*compiler, "g++", "clang++", "c++"} {
+		if _, err := exec.LookPath(c); err == nil {
+			old := *compiler
+			*compiler = c
+			t.Cleanup(func() { *compiler = old })
+			return
+		}
+	}
+	t.Skip("no C++ compiler found")
+}
+
+func TestSingleKey(t *testing.T) {
+	useCompiler(t)
+	defer func(s string, o bool) { *std, *optimize = s, o }(*std, *optimize)
+	*std = "c++17"
+	dir, err := ioutil.TempDir("", "cm-single")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(name, content string) {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	source := filepath.Join(dir, "a.cpp")
+	write("a.cpp", "#include \"a.hpp\"\n#include <vector>\nint main() { return answer(); }\n")
+	write("a.hpp", "inline int answer() { return 42; }\n")
+	key := func() string {
+		k, err := singleKey(source)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return k
+	}
+	first := key()
+	if len(first) != 16 {
+		t.Errorf("singleKey() = %q, want 16 hex digits", first)
+	}
+	now := time.Now().Add(time.Minute)
+	os.Chtimes(filepath.Join(dir, "a.hpp"), now, now)
+	if k := key(); k != first {
+		t.Errorf("touching a header changed the key from %s to %s", first, k)
+	}
+
+	steps := []struct {
+		name   string
+		change func()
+	}{
+		{"header contents", func() { write("a.hpp", "inline int answer() { return 43; }\n") }},
+		{"-max", func() { *optimize = true }},
+		{"-std", func() { *std = "c++20" }},
+		{"source contents", func() { write("a.cpp", "#include \"a.hpp\"\nint main() { return answer(); }\n") }},
+	}
+	seen := map[string]string{first: "the initial build"}
+	for _, s := range steps {
+		s.change()
+		k := key()
+		if prev, ok := seen[k]; ok {
+			t.Errorf("changing the %s did not change the key, it is still that of %s", s.name, prev)
+		}
+		seen[k] = s.name
+	}
+
+	write("a.cpp", "#include \"missing.hpp\"\n")
+	if _, err := singleKey(source); err == nil {
+		t.Error("singleKey() of a source with a missing header did not fail")
+	}
+}
+
+func TestSinglePlan(t *testing.T) {
+	useCompiler(t)
+	defer os.Setenv("XDG_CACHE_HOME", os.Getenv("XDG_CACHE_HOME"))
+	defer os.Setenv("HOME", os.Getenv("HOME"))
+	cache := tempProject(t)
+	os.Setenv("XDG_CACHE_HOME", cache)
+	os.Setenv("HOME", cache)
+	dir := tempProject(t)
+	writeFiles(t, dir, map[string]string{"a.cpp": "int main() { return 0; }\n"})
+	source := filepath.Join(dir, "a.cpp")
+
+	plan, err := singlePlan(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Steps) != 1 || plan.Steps[0].Output != plan.Binary || plan.Project != "a" {
+		t.Fatalf("singlePlan() = %+v, want a single compile into the binary", plan)
+	}
+	if entries, _ := ioutil.ReadDir(cache); len(entries) != 0 {
+		t.Errorf("singlePlan() wrote %d files into the cache", len(entries))
+	}
+
+	binary, err := singleBinary(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if binary != plan.Binary {
+		t.Errorf("singleBinary() = %s, want the planned %s", binary, plan.Binary)
+	}
+	if plan, err = singlePlan(source); err != nil || len(plan.Steps) != 0 {
+		t.Errorf("singlePlan() of a cached build = %+v, %v, want nothing to do", plan, err)
+	}
+}
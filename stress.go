@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// stressFlags are the options accepted by `cm stress`, on top of the global flags
+var (
+	stressFlags  = flag.NewFlagSet("stress", flag.ExitOnError)
+	stressGen    = stressFlags.String("gen", "stress/gen.cpp", "generator source; it is run with the seed as its only argument and prints an input")
+	stressRef    = stressFlags.String("ref", "stress/ref.cpp", "reference (brute-force) solution source")
+	stressN      = stressFlags.Int("n", 1000, "number of inputs to try")
+	stressSeed   = stressFlags.Int("seed", 1, "first seed; every following input uses the next one")
+	stressCmp    = stressFlags.String("cmp", "ws", "output comparator: exact, ws (whitespace-insensitive) or float")
+	stressEps    = stressFlags.Float64("eps", 1e-6, "absolute or relative error tolerated by the float comparator")
+	stressTime   = stressFlags.Duration("timelimit", 5*time.Second, "CPU and wall clock time limit for each program run")
+	stressDir    = stressFlags.String("testdata", "testdata", "dir the failing input and the expected output are saved to")
+	stressShrink = stressFlags.Int("shrink", 100, "after the first failure, try this many more seeds and keep the smallest failing input")
+)
+
+// runStress builds the generator, the solution and the reference solution, then runs both solutions on generated inputs
+// until their outputs differ. The smallest failing input found within -shrink more seeds is saved as a judge case,
+// together with the reference output.
+func runStress(target string) {
+	cmp, err := comparator(*stressCmp, *stressEps)
+	if err != nil {
+		log.Fatalf("%+v", err)
+	}
+	log.Printf("compiling project...\n")
+	compile(target)
+	solution := target + "/bin/" + *name
+	gen := target + "/bin/" + *name + "-gen"
+	ref := target + "/bin/" + *name + "-ref"
+	for bin, src := range map[string]string{gen: *stressGen, ref: *stressRef} {
+		if !filepath.IsAbs(src) {
+			src = filepath.Join(target, src)
+		}
+		log.Printf("compiling %s...", src)
+		if err := buildOptions().CompileFile(src, bin); err != nil {
+			log.Fatalf("%+v", err)
+		}
+	}
+
+	log.Printf("stress testing %s against %s with seeds %d to %d...", *name, *stressRef, *stressSeed, *stressSeed+*stressN-1)
+	failed := findFailure(gen, ref, solution, cmp)
+	if failed == nil {
+		log.Printf("🎉 all %d inputs produced the same output", *stressN)
+		return
+	}
+
+	fmt.Printf("\nseed %d failed\ninput:\n%s\n", failed.seed, failed.input)
+	if failed.err != nil {
+		fmt.Printf("solution error: %v\n%s\n", failed.err, failed.got)
+	} else {
+		fmt.Printf("diff (reference vs solution):\n%s\n", diffLines(string(failed.want), string(failed.got)))
+	}
+	in, err := saveCase(target, fmt.Sprintf("stress-%d", failed.seed), failed.input, failed.want)
+	if err != nil {
+		log.Fatalf("could not save failing case: %+v", err)
+	}
+	log.Printf("saved the failing input to %s; rerun it with cm judge", in)
+	stopTrace()
+	os.Exit(1)
+}
+
+// stressFailure is an input on which the solution disagreed with the reference solution
+type stressFailure struct {
+	seed  int
+	input []byte
+	want  []byte
+	got   []byte
+	err   error
+}
+
+// findFailure tries the seeds from -seed on until the solutions disagree, then tries up to -shrink more seeds and
+// returns the smallest failing input, or nil if all -n inputs passed
+func findFailure(gen, ref, solution string, cmp func(want, got string) bool) *stressFailure {
+	var failed *stressFailure
+	last := *stressSeed + *stressN - 1
+	for seed := *stressSeed; seed <= last; seed++ {
+		f := stressOnce(gen, ref, solution, seed, cmp)
+		if f == nil {
+			continue
+		}
+		if failed == nil {
+			log.Printf("seed %d failed, looking for a smaller failing input in the next %d seeds...", seed, *stressShrink)
+			if seed+*stressShrink < last {
+				last = seed + *stressShrink
+			}
+		}
+		if failed == nil || len(f.input) < len(failed.input) {
+			failed = f
+		}
+	}
+	return failed
+}
+
+// stressOnce generates the input for one seed and runs both solutions on it, returning nil if their outputs match
+func stressOnce(gen, ref, solution string, seed int, cmp func(want, got string) bool) *stressFailure {
+	input, err := runWithInput(gen, []string{strconv.Itoa(seed)}, nil)
+	if err != nil {
+		log.Fatalf("generator failed for seed %d: %+v", seed, err)
+	}
+	want, err := runWithInput(ref, nil, input)
+	if err != nil {
+		log.Fatalf("reference solution failed for seed %d: %+v\ninput:\n%s", seed, err, input)
+	}
+	got, err := runWithInput(solution, nil, input)
+	if err == nil && cmp(string(want), string(got)) {
+		return nil
+	}
+	return &stressFailure{seed: seed, input: input, want: want, got: got, err: err}
+}
+
+// runWithInput runs the binary with the given args and stdin, returning its stdout. Stderr is only returned as part of
+// the error when the program fails.
+func runWithInput(binary string, args []string, input []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), *stressTime)
+	defer cancel()
+	lim := limits{cpu: *stressTime, mem: *memLimit, files: *fileLimit}
+	cmd, args := lim.command(binary, args)
+	command := exec.CommandContext(ctx, cmd, args...)
+	var stdout, stderr bytes.Buffer
+	command.Stdin = bytes.NewReader(input)
+	command.Stdout = &stdout
+	command.Stderr = &stderr
+	err := command.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return stdout.Bytes(), fmt.Errorf("timed out after %v", *stressTime)
+	}
+	if limit := lim.hit(command.ProcessState); limit != "" {
+		return stdout.Bytes(), fmt.Errorf("stopped by the %s", limit)
+	}
+	if err != nil {
+		return stdout.Bytes(), fmt.Errorf("%v: %s", err, stderr.Bytes())
+	}
+	return stdout.Bytes(), nil
+}
+
+// saveCase writes a judge case to the testdata dir, returning the path of the .in file
+func saveCase(target, name string, input, output []byte) (string, error) {
+	dir := *stressDir
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(target, dir)
+	}
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return "", err
+	}
+	in := filepath.Join(dir, name+".in")
+	if err := ioutil.WriteFile(in, input, 0664); err != nil {
+		return "", err
+	}
+	return in, ioutil.WriteFile(filepath.Join(dir, name+".out"), output, 0664)
+}
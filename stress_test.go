@@ -0,0 +1,97 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// stressPrograms writes a generator printing the numbers 1 to (7*seed)%10+1, a reference solution summing them and a
+// solution that is off by one once there are three or more numbers
+func stressPrograms(t *testing.T) (dir, gen, ref, solution string) {
+	dir, err := ioutil.TempDir("", "cm-stress")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	programs := map[string]string{
+		"gen":      "#!/bin/sh\nseq $(($1 * 7 % 10 + 1)) | tr '\\n' ' '; echo\n",
+		"ref":      "#!/bin/sh\nawk '{ s = 0; for (i = 1; i <= NF; i++) s += $i; print s }'\n",
+		"solution": "#!/bin/sh\nawk '{ s = 0; for (i = 1; i <= NF; i++) s += $i; if (NF >= 3) s++; print s }'\n",
+		"crash":    "#!/bin/sh\necho partial; kill -SEGV $$\n",
+	}
+	for name, script := range programs {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(script), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir, filepath.Join(dir, "gen"), filepath.Join(dir, "ref"), filepath.Join(dir, "solution")
+}
+
+func TestFindFailure(t *testing.T) {
+	defer func(seed, n, shrink int) { *stressSeed, *stressN, *stressShrink = seed, n, shrink }(
+		*stressSeed, *stressN, *stressShrink)
+	_, gen, ref, solution := stressPrograms(t)
+	cmp, _ := comparator("ws", 0)
+	// seeds 1 to 10 generate 8, 5, 2, 9, 6, 3, 10, 7, 4 and 1 numbers
+	tests := []struct {
+		name               string
+		seed, n, shrink    int
+		wantSeed           int
+		wantInput, wantOut string
+	}{
+		{"all pass", 3, 1, 100, 0, "", ""},
+		{"no failure after the first", 1, 1, 100, 1, "1 2 3 4 5 6 7 8 \n", "36\n"},
+		{"shrink stops at -n", 1, 2, 100, 2, "1 2 3 4 5 \n", "15\n"},
+		{"shrink within -shrink seeds", 1, 1000, 5, 6, "1 2 3 \n", "6\n"},
+		{"no shrinking", 1, 1000, 0, 1, "1 2 3 4 5 6 7 8 \n", "36\n"},
+		{"first failure after passing seeds", 3, 5, 1, 5, "1 2 3 4 5 6 \n", "21\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			*stressSeed, *stressN, *stressShrink = tt.seed, tt.n, tt.shrink
+			f := findFailure(gen, ref, solution, cmp)
+			if tt.wantSeed == 0 {
+				if f != nil {
+					t.Errorf("findFailure() = seed %d, want no failure", f.seed)
+				}
+				return
+			}
+			if f == nil {
+				t.Fatalf("findFailure() found no failure, want seed %d", tt.wantSeed)
+			}
+			if f.seed != tt.wantSeed || string(f.input) != tt.wantInput || string(f.want) != tt.wantOut || f.err != nil {
+				t.Errorf("findFailure() = seed %d, input %q, output %q, error %v, want seed %d, input %q, output %q",
+					f.seed, f.input, f.want, f.err, tt.wantSeed, tt.wantInput, tt.wantOut)
+			}
+		})
+	}
+}
+
+func TestStressOnceSolutionError(t *testing.T) {
+	dir, gen, ref, _ := stressPrograms(t)
+	cmp, _ := comparator("ws", 0)
+	f := stressOnce(gen, ref, filepath.Join(dir, "crash"), 3, cmp)
+	if f == nil || f.err == nil || string(f.got) != "partial\n" || string(f.want) != "3\n" {
+		t.Errorf("stressOnce() with a crashing solution = %+v", f)
+	}
+}
+
+func TestSaveCase(t *testing.T) {
+	defer func(dir string) { *stressDir = dir }(*stressDir)
+	target, _, _, _ := stressPrograms(t)
+	*stressDir = "testdata/stress"
+	in, err := saveCase(target, "stress-6", []byte("1 2 3\n"), []byte("6\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(target, "testdata/stress/stress-6.in"); in != want {
+		t.Errorf("saveCase() = %s, want %s", in, want)
+	}
+	for file, want := range map[string]string{"stress-6.in": "1 2 3\n", "stress-6.out": "6\n"} {
+		if b, err := ioutil.ReadFile(filepath.Join(target, "testdata/stress", file)); err != nil || string(b) != want {
+			t.Errorf("%s = %q, %v, want %q", file, b, err, want)
+		}
+	}
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/damienstanton/cm/pkg/cm"
+)
+
+// initFlags are the options accepted by `cm init`, on top of the global flags
+var (
+	initFlags    = flag.NewFlagSet("init", flag.ExitOnError)
+	initTemplate = initFlags.String("template", "app", "project template: app, lib, header-only, competitive, or the name or path of a template dir")
+)
+
+// templateData is what project templates are rendered with, both in file names and contents
+type templateData struct {
+	Name  string // project (and binary) name
+	Ident string // Name as a C++ identifier, used as the namespace
+	Type  string // project type written to cm.json
+}
+
+// templateSuffix marks the files of a template whose contents are Go templates; it is stripped from the file name.
+// Other files are copied as they are, so a template can hold files that contain {{ themselves.
+const templateSuffix = ".tmpl"
+
+// commonFiles are written by every template unless the template has its own
+var commonFiles = map[string]string{
+	".gitignore": `/bin/*
+!/bin/.gitkeep
+/.cm/
+/dist/
+/tests/catch.hpp
+/tests/test_main.cpp
+`,
+	cm.ConfigFile + templateSuffix: `{
+  "name": "{{.Name}}",
+  "type": "{{.Type}}",
+  "namespace": "{{.Ident}}"
+}
+`,
+}
+
+// builtinTemplates are the templates embedded in cm, by name
+var builtinTemplates = map[string]map[string]string{
+	"app": {
+		"src/main.cpp.tmpl": `#include <iostream>
+
+#include "{{.Name}}.hpp"
+
+auto main(int argc, char* argv[]) -> int {
+    std::cout << {{.Ident}}::greet(argc < 2 ? "world" : argv[1]) << std::endl;
+    return 0;
+}
+`,
+		"src/{{.Name}}.hpp.tmpl":        greetHeader,
+		"src/{{.Name}}.cpp.tmpl":        greetSource,
+		"tests/{{.Name}}_test.cpp.tmpl": greetTest,
+	},
+	"lib": {
+		"src/{{.Name}}.hpp.tmpl":        greetHeader,
+		"src/{{.Name}}.cpp.tmpl":        greetSource,
+		"tests/{{.Name}}_test.cpp.tmpl": greetTest,
+	},
+	"header-only": {
+		"src/{{.Name}}.hpp.tmpl": `#pragma once
+
+#include <string>
+
+namespace {{.Ident}} {
+
+// greet returns a greeting for the given name
+inline auto greet(std::string const& name) -> std::string {
+    return "Hello, " + name + "!";
+}
+
+}  // namespace {{.Ident}}
+`,
+		"tests/{{.Name}}_test.cpp.tmpl": greetTest,
+	},
+	"competitive": {
+		"src/main.cpp": `#include <iostream>
+
+#include "solve.hpp"
+
+auto main() -> int {
+    std::ios::sync_with_stdio(false);
+    std::cin.tie(nullptr);
+    long long a, b;
+    std::cin >> a >> b;
+    std::cout << solve(a, b) << "\n";
+    return 0;
+}
+`,
+		"src/solve.hpp": `#pragma once
+
+// solve is the solution, kept apart from main so it can be unit tested
+inline auto solve(long long a, long long b) -> long long {
+    return a + b;
+}
+`,
+		"tests/solve_test.cpp": `#include "catch.hpp"
+#include "solve.hpp"
+
+TEST_CASE("solve adds", "[solve]") {
+    REQUIRE(solve(1, 2) == 3);
+}
+`,
+		"testdata/01.in":  "1 2\n",
+		"testdata/01.out": "3\n",
+		"stress/gen.cpp": `#include <cstdlib>
+#include <iostream>
+#include <random>
+
+// gen prints a random input for the seed given as its only argument
+auto main(int argc, char* argv[]) -> int {
+    std::mt19937 rng(argc > 1 ? std::atoi(argv[1]) : 0);
+    std::uniform_int_distribution<long long> n(-1000, 1000);
+    std::cout << n(rng) << " " << n(rng) << "\n";
+    return 0;
+}
+`,
+		"stress/ref.cpp": `#include <iostream>
+
+// ref is a brute-force reference solution for cm stress
+auto main() -> int {
+    long long a, b;
+    std::cin >> a >> b;
+    std::cout << a + b << "\n";
+    return 0;
+}
+`,
+	},
+}
+
+const greetHeader = `#pragma once
+
+#include <string>
+
+namespace {{.Ident}} {
+
+// greet returns a greeting for the given name
+auto greet(std::string const& name) -> std::string;
+
+}  // namespace {{.Ident}}
+`
+
+const greetSource = `#include "{{.Name}}.hpp"
+
+namespace {{.Ident}} {
+
+auto greet(std::string const& name) -> std::string {
+    return "Hello, " + name + "!";
+}
+
+}  // namespace {{.Ident}}
+`
+
+const greetTest = `#include "catch.hpp"
+#include "{{.Name}}.hpp"
+
+TEST_CASE("greet says hello", "[{{.Name}}]") {
+    REQUIRE({{.Ident}}::greet("world") == "Hello, world!");
+}
+`
+
+// runInit scaffolds a project from a template in the target dir. Existing files are never overwritten, so running it
+// in an existing project only adds what is missing.
+func runInit(target string) {
+	files, kind, err := loadTemplate(*initTemplate)
+	if err != nil {
+		log.Fatalf("template error: %+v", err)
+	}
+	if err := mkScaffoldDirs(); err != nil {
+		log.Fatalf("dir write error: %v", err)
+	}
+	data := templateData{Name: *name, Ident: identifier(*name), Type: kind}
+	paths := make([]string, 0, len(files))
+	for p := range files {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		path, err := render(p, data)
+		if err != nil {
+			log.Fatalf("template error in file name %s: %+v", p, err)
+		}
+		content := files[p]
+		if strings.HasSuffix(path, templateSuffix) {
+			path = strings.TrimSuffix(path, templateSuffix)
+			if content, err = render(content, data); err != nil {
+				log.Fatalf("template error in %s: %+v", p, err)
+			}
+		}
+		dest := filepath.Join(target, filepath.FromSlash(path))
+		if _, err := os.Stat(dest); err == nil {
+			log.Printf("%s exists, leaving it as is", path)
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(dest), 0777); err != nil {
+			log.Fatalf("dir write error: %v", err)
+		}
+		if err := ioutil.WriteFile(dest, []byte(content), 0664); err != nil {
+			log.Fatalf("file write error: %v", err)
+		}
+		log.Printf("created %s", path)
+	}
+	log.Printf("init completed successfully for %s\n", target)
+}
+
+// loadTemplate returns the files of the named template, keyed by slash separated path, and the project type it
+// creates, which is read from the template's own cm.json if it has one. A template is either built in, a path to a dir,
+// or the name of a dir in the user's cm templates dir (e.g. ~/.config/cm/templates/<name>). The common files are added
+// unless the template has its own.
+func loadTemplate(name string) (map[string]string, string, error) {
+	files := map[string]string{}
+	kind := "app"
+	if builtin, ok := builtinTemplates[name]; ok {
+		for p, c := range builtin {
+			files[p] = c
+		}
+		if name == "lib" || name == "header-only" {
+			kind = name
+		}
+	} else {
+		dir := name
+		if !strings.ContainsRune(name, filepath.Separator) && !strings.HasPrefix(name, ".") {
+			config, err := os.UserConfigDir()
+			if err != nil {
+				return nil, "", fmt.Errorf("unknown template %q and no user config dir: %w", name, err)
+			}
+			dir = filepath.Join(config, "cm", "templates", name)
+		}
+		var err error
+		files, err = readTemplateDir(dir)
+		if err != nil {
+			return nil, "", fmt.Errorf("could not read template %q: %w", name, err)
+		}
+		if kind, err = templateType(files, kind); err != nil {
+			return nil, "", fmt.Errorf("template %q: %w", name, err)
+		}
+	}
+	for p, c := range commonFiles {
+		if !hasTemplateFile(files, strings.TrimSuffix(p, templateSuffix)) {
+			files[p] = c
+		}
+	}
+	return files, kind, nil
+}
+
+// templateType returns the project type set in the cm.json of a template, or kind if it has none. A templated type,
+// such as "{{.Type}}", leaves the type to cm.
+func templateType(files map[string]string, kind string) (string, error) {
+	for _, p := range []string{cm.ConfigFile, cm.ConfigFile + templateSuffix} {
+		content, ok := files[p]
+		if !ok {
+			continue
+		}
+		var c cm.Config
+		if err := json.Unmarshal([]byte(content), &c); err != nil {
+			if p != cm.ConfigFile {
+				// template actions outside of strings make a cm.json.tmpl invalid JSON before it is rendered
+				return kind, nil
+			}
+			return "", fmt.Errorf("invalid %s: %w", p, err)
+		}
+		if c.Type != "" && !strings.Contains(c.Type, "{{") {
+			kind = c.Type
+		}
+	}
+	return kind, nil
+}
+
+// hasTemplateFile reports whether the template writes the file at path, either as is or rendered
+func hasTemplateFile(files map[string]string, path string) bool {
+	_, plain := files[path]
+	_, rendered := files[path+templateSuffix]
+	return plain || rendered
+}
+
+// readTemplateDir reads every file under dir, keyed by its slash separated path relative to dir
+func readTemplateDir(dir string) (map[string]string, error) {
+	files := map[string]string{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = string(b)
+		return nil
+	})
+	return files, err
+}
+
+// render executes text as a template with the given data
+func render(text string, data templateData) (string, error) {
+	t, err := template.New("").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var b bytes.Buffer
+	if err := t.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// nonIdent matches the runs of characters that may not appear in a C++ identifier
+var nonIdent = regexp.MustCompile(`[^A-Za-z0-9_]+`)
+
+// identifier turns a project name into a valid C++ identifier
+func identifier(name string) string {
+	id := nonIdent.ReplaceAllString(name, "_")
+	if id == "" || (id[0] >= '0' && id[0] <= '9') {
+		id = "_" + id
+	}
+	return id
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestIdentifier(t *testing.T) {
+	tests := map[string]string{
+		"hello":     "hello",
+		"my-app":    "my_app",
+		"my app.v2": "my_app_v2",
+		"2048":      "_2048",
+		"":          "_",
+		"c++":       "c_",
+	}
+	for name, want := range tests {
+		if got := identifier(name); got != want {
+			t.Errorf("identifier(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestRender(t *testing.T) {
+	data := templateData{Name: "my-app", Ident: "my_app", Type: "lib"}
+	got, err := render("src/{{.Name}}.hpp: namespace {{.Ident}} ({{.Type}})", data)
+	if want := "src/my-app.hpp: namespace my_app (lib)"; err != nil || got != want {
+		t.Errorf("render() = %q, %v, want %q", got, err, want)
+	}
+	if _, err := render("{{.Missing}}", data); err == nil {
+		t.Error("render() of an unknown field did not fail")
+	}
+	if _, err := render("{{.Name", data); err == nil {
+		t.Error("render() of an unterminated action did not fail")
+	}
+}
+
+func TestBuiltinTemplatesRender(t *testing.T) {
+	data := templateData{Name: "my-app", Ident: "my_app", Type: "app"}
+	for name := range builtinTemplates {
+		files, _, err := loadTemplate(name)
+		if err != nil {
+			t.Fatalf("loadTemplate(%q): %v", name, err)
+		}
+		for p, content := range files {
+			if _, err := render(p, data); err != nil {
+				t.Errorf("%s: file name %s does not render: %v", name, p, err)
+			}
+			if _, err := render(content, data); err != nil {
+				t.Errorf("%s: %s does not render: %v", name, p, err)
+			}
+		}
+	}
+}
+
+func TestLoadTemplate(t *testing.T) {
+	home, err := ioutil.TempDir("", "cm-templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(home)
+	defer os.Setenv("XDG_CONFIG_HOME", os.Getenv("XDG_CONFIG_HOME"))
+	os.Setenv("XDG_CONFIG_HOME", home)
+	user := filepath.Join(home, "cm", "templates", "mine")
+	for p, content := range map[string]string{"src/main.cpp": "int main() {}\n", "cm.json": "{}\n"} {
+		path := filepath.Join(user, filepath.FromSlash(p))
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		files []string
+		kind  string
+	}{
+		{"lib", []string{".gitignore", "cm.json.tmpl", "src/{{.Name}}.cpp.tmpl", "src/{{.Name}}.hpp.tmpl",
+			"tests/{{.Name}}_test.cpp.tmpl"}, "lib"},
+		{"mine", []string{".gitignore", "cm.json", "src/main.cpp"}, "app"},
+		{user, []string{".gitignore", "cm.json", "src/main.cpp"}, "app"},
+	}
+	for _, tt := range tests {
+		files, kind, err := loadTemplate(tt.name)
+		if err != nil {
+			t.Errorf("loadTemplate(%q): %v", tt.name, err)
+			continue
+		}
+		paths := make([]string, 0, len(files))
+		for p := range files {
+			paths = append(paths, p)
+		}
+		sort.Strings(paths)
+		if strings.Join(paths, " ") != strings.Join(tt.files, " ") || kind != tt.kind {
+			t.Errorf("loadTemplate(%q) = %q, %s, want %q, %s", tt.name, paths, kind, tt.files, tt.kind)
+		}
+		if tt.name != "lib" && files["cm.json"] != "{}\n" {
+			t.Errorf("loadTemplate(%q) replaced the template's own cm.json with %q", tt.name, files["cm.json"])
+		}
+	}
+	if _, _, err := loadTemplate("missing"); err == nil {
+		t.Error("loadTemplate() of an unknown template did not fail")
+	}
+}
+
+func TestTemplateType(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string
+		want    string
+		wantErr bool
+	}{
+		{"no cm.json", map[string]string{"src/main.cpp": ""}, "app", false},
+		{"no type", map[string]string{"cm.json": "{}"}, "app", false},
+		{"plain cm.json", map[string]string{"cm.json": `{"type": "lib"}`}, "lib", false},
+		{"rendered cm.json", map[string]string{"cm.json.tmpl": `{"name": "{{.Name}}", "type": "header-only"}`},
+			"header-only", false},
+		{"templated type", map[string]string{"cm.json.tmpl": `{"type": "{{.Type}}"}`}, "app", false},
+		{"actions outside strings", map[string]string{"cm.json.tmpl": `{"type": "lib"{{if .Name}},{{end}}}`}, "app",
+			false},
+		{"invalid cm.json", map[string]string{"cm.json": `{"type": `}, "", true},
+	}
+	for _, tt := range tests {
+		got, err := templateType(tt.files, "app")
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("templateType() of %s = %q, %v, want %q", tt.name, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"flag"
+	"strings"
+)
+
+// testFlags are the options accepted by `cm test`, on top of the global flags
+var (
+	testFlags   = flag.NewFlagSet("test", flag.ExitOnError)
+	testPattern = testFlags.String("run", "", "only run test cases whose name matches the given pattern, e.g. \"Greeting*\"")
+	testTags    = testFlags.String("tags", "", "only run test cases with the given tags, e.g. \"[greeting]\" (test suites for doctest)")
+	testFiles   = testFlags.String("files", "", "comma-separated list of files (or globs) in tests/ to compile, default all")
+	testFw      = testFlags.String("framework", "", "test framework: catch2, catch2-v3, doctest or custom (default from cm.json, else catch2)")
+)
+
+// testFileGlobs returns the comma-separated files (or globs) given with -files
+func testFileGlobs() []string {
+	if *testFiles == "" {
+		return nil
+	}
+	return strings.Split(*testFiles, ",")
+}
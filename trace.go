@@ -0,0 +1,37 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strings"
+
+	"github.com/damienstanton/cm/pkg/cm"
+)
+
+// trace records the run when -trace is given; it is nil otherwise, which records nothing
+var trace *cm.Trace
+
+// stopTrace ends the trace started by startTrace; it does nothing without -trace or when called again
+var stopTrace = func() {}
+
+// startTrace records the run into the -trace file, as a span for the whole run with its phases inside
+func startTrace(cmd string) {
+	if *traceFile == "" {
+		return
+	}
+	f, err := os.Create(*traceFile)
+	if err != nil {
+		log.Fatalf("could not create trace: %+v", err)
+	}
+	trace = cm.NewTrace(f)
+	span := trace.Start("cm", strings.TrimSpace("cm "+cmd))
+	stopTrace = func() {
+		stopTrace = func() {}
+		span.End()
+		if err := trace.Close(); err != nil {
+			log.Printf("could not write trace: %+v", err)
+		}
+		f.Close()
+		log.Printf("trace written to %s", *traceFile)
+	}
+}